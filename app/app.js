@@ -1,4 +1,7 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  await handleEmailLinks();
+  await handleSSORedirect();
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -36,7 +39,7 @@ async function createVideoDraft() {
     });
     const data = await res.json();
     if (!res.ok) {
-      throw new Error(`Failed to create video draft: ${data.error}`);
+      throw new Error(`Failed to create video draft: ${data.detail}`);
     }
 
     const videoID = data.id;
@@ -61,9 +64,12 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
-      throw new Error(`Failed to login: ${data.error}`);
+      throw new Error(`Failed to login: ${data.detail}`);
+    }
+    if (data.mfa_required) {
+      data = await completeTOTPLogin(data.challenge_token);
     }
 
     if (data.token) {
@@ -79,6 +85,34 @@ async function login() {
   }
 }
 
+// completeTOTPLogin exchanges a login challenge for a session using a code
+// from the user's authenticator app or one of their recovery codes.
+async function completeTOTPLogin(challengeToken) {
+  const input = prompt('Enter the code from your authenticator app, or a recovery code');
+  if (!input) {
+    throw new Error('Two-factor authentication code required');
+  }
+  const body = { challenge_token: challengeToken };
+  if (/^\d{6}$/.test(input.replace(/\s/g, ''))) {
+    body.code = input;
+  } else {
+    body.recovery_code = input;
+  }
+
+  const res = await fetch('/api/login/totp', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.detail}`);
+  }
+  return data;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -93,7 +127,7 @@ async function signup() {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to create user: ${data.error}`);
+      throw new Error(`Failed to create user: ${data.detail}`);
     }
     console.log('User created!');
     await login();
@@ -102,6 +136,113 @@ async function signup() {
   }
 }
 
+function loginWithSSO() {
+  window.location.href = '/api/auth/oidc/login';
+}
+
+// handleSSORedirect picks up the result of a single sign-on login, which the
+// server passes back in the URL fragment.
+async function handleSSORedirect() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  if (!params.has('token') && !params.has('mfa_challenge') && !params.has('sso_error')) {
+    return;
+  }
+  history.replaceState(null, '', window.location.pathname + window.location.search);
+
+  try {
+    if (params.has('sso_error')) {
+      throw new Error(params.get('sso_error'));
+    }
+    let token = params.get('token');
+    if (params.has('mfa_challenge')) {
+      const data = await completeTOTPLogin(params.get('mfa_challenge'));
+      token = data.token;
+    }
+    localStorage.setItem('token', token);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+// handleEmailLinks redeems the verify_token, reset_token and
+// email_change_token query parameters carried by links in account emails.
+async function handleEmailLinks() {
+  const params = new URLSearchParams(window.location.search);
+  const verifyToken = params.get('verify_token');
+  const resetToken = params.get('reset_token');
+  const emailChangeToken = params.get('email_change_token');
+  if (!verifyToken && !resetToken && !emailChangeToken) return;
+  window.history.replaceState({}, '', window.location.pathname);
+
+  try {
+    if (emailChangeToken) {
+      const res = await fetch('/api/users/email/confirm', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: emailChangeToken }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to change email: ${data.detail}`);
+      }
+      alert('Your email address has been changed.');
+    }
+
+    if (verifyToken) {
+      const res = await fetch('/api/users/verify', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: verifyToken }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to verify email: ${data.detail}`);
+      }
+      alert('Email verified! You can now upload videos.');
+    }
+
+    if (resetToken) {
+      const password = prompt('Choose a new password');
+      if (!password) return;
+      const res = await fetch('/api/password_reset/confirm', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: resetToken, password }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to reset password: ${data.detail}`);
+      }
+      alert('Password updated. Please log in.');
+    }
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function requestPasswordReset() {
+  const email = document.getElementById('email').value;
+  if (!email) {
+    alert('Enter your email address first.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password_reset', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to request password reset: ${data.detail}`);
+    }
+    alert('If that email has an account, a reset link is on its way.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 function logout() {
   localStorage.removeItem('token');
   document.getElementById('auth-section').style.display = 'block';
@@ -139,7 +280,7 @@ async function uploadThumbnail(videoID) {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to upload thumbnail. Error: ${data.error}`);
+      throw new Error(`Failed to upload thumbnail. Error: ${data.detail}`);
     }
 
     await res.json();
@@ -161,6 +302,11 @@ async function uploadVideoFile(videoID) {
 
   uploadBtnSelector = 'upload-video-btn';
   setUploadButtonState(true, uploadBtnSelector);
+  const uploadBtn = document.getElementById(uploadBtnSelector);
+  const stopWatching = watchVideoProgress(videoID, (event) => {
+    if (!uploadBtn.disabled) return;
+    uploadBtn.textContent = describeProgress(event);
+  });
 
   try {
     const res = await fetch(`/api/video_upload/${videoID}`, {
@@ -172,7 +318,7 @@ async function uploadVideoFile(videoID) {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to upload video file. Error: ${data.error}`);
+      throw new Error(`Failed to upload video file. Error: ${data.detail}`);
     }
 
     console.log('Video uploaded!');
@@ -181,9 +327,64 @@ async function uploadVideoFile(videoID) {
     alert(`Error: ${error.message}`);
   }
 
+  stopWatching();
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+// watchVideoProgress calls onEvent with each of a video's progress events
+// until the returned function is called. EventSource can't send the
+// Authorization header, so the stream is read with fetch.
+function watchVideoProgress(videoID, onEvent) {
+  const controller = new AbortController();
+  (async () => {
+    const res = await fetch(`/api/videos/${videoID}/events`, {
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      signal: controller.signal,
+    });
+    if (!res.ok) return;
+    const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffered = '';
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) return;
+      buffered += value;
+      const messages = buffered.split('\n\n');
+      buffered = messages.pop();
+      for (const message of messages) {
+        const data = message
+          .split('\n')
+          .filter((line) => line.startsWith('data: '))
+          .map((line) => line.slice('data: '.length))
+          .join('\n');
+        if (data) onEvent(JSON.parse(data));
+      }
+    }
+  })().catch((error) => {
+    if (error.name !== 'AbortError') console.error('Progress stream failed:', error);
+  });
+  return () => controller.abort();
+}
+
+function describeProgress(event) {
+  const percent = event.percent === undefined ? '' : ` ${Math.floor(event.percent)}%`;
+  switch (event.stage) {
+    case 'uploading':
+      return `Uploading...${percent}`;
+    case 'processing':
+      return `Processing...${percent}`;
+    case 'storing':
+      return 'Storing...';
+    case 'ready':
+      return 'Done';
+    case 'failed':
+      return 'Failed';
+    default:
+      return event.stage;
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
@@ -196,7 +397,7 @@ async function getVideos() {
     });
     if (!res.ok) {
       const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
+      throw new Error(`Failed to get videos. Error: ${data.detail}`);
     }
 
     const videos = await res.json();
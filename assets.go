@@ -1,7 +1,26 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +29,188 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// workspaceKey namespaces a storage key under the organization that owns the
+// media, so each organization's objects share an orgs/<id>/ prefix. Personal
+// media keeps the bare key.
+func workspaceKey(orgID *uuid.UUID, key string) string {
+	if orgID == nil {
+		return key
+	}
+	return path.Join("orgs", orgID.String(), key)
+}
+
+// thumbnailURL is where a thumbnail in the local store is served from, by
+// the server at origin.
+func (cfg *apiConfig) thumbnailURL(origin, key string) string {
+	return fmt.Sprintf("%s/%s", origin, filepath.ToSlash(filepath.Join(cfg.assetsRoot, key)))
+}
+
+// thumbnailKey recovers the storage key from a URL made by thumbnailURL.
+func (cfg *apiConfig) thumbnailKey(thumbnailURL string) (string, bool) {
+	u, err := url.Parse(thumbnailURL)
+	if err != nil {
+		return "", false
+	}
+	prefix := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(cfg.assetsRoot)), "/") + "/"
+	p := strings.TrimPrefix(u.Path, "/")
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, prefix), true
+}
+
+// localVideosDir is where videos are kept, under the assets root, when
+// they're stored locally.
+const localVideosDir = "videos"
+
+// configureVideoStore sets where videos are kept and served from: an S3
+// bucket behind CloudFront, or the assets directory.
+func (cfg *apiConfig) configureVideoStore(ctx context.Context, conf config.StorageConfig) error {
+	if conf.Videos == config.VideoStoreLocal {
+		cfg.videoStore = cfg.instrumentStore("local", storage.LocalStore{Root: filepath.Join(cfg.assetsRoot, localVideosDir)})
+		cfg.videoBaseURL = cfg.publicURL + "/assets/" + localVideosDir
+		return nil
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(conf.S3.Region))
+	if err != nil {
+		return fmt.Errorf("couldn't load AWS config: %w", err)
+	}
+	client := s3.NewFromConfig(awsConfig)
+	store := storage.S3Store{Client: client, Bucket: conf.S3.Bucket}
+	cfg.videoStore = cfg.instrumentStore("s3", store)
+	cfg.videoPresigner = store
+	cfg.videoBaseURL = strings.TrimSuffix(conf.S3.CloudFrontURL, "/")
+	return nil
+}
+
+// videoURL is where a video in the video store is served from.
+func (cfg *apiConfig) videoURL(key string) string {
+	return fmt.Sprintf("%s/%s", cfg.videoBaseURL, key)
+}
+
+// videoKey recovers the storage key from a URL made by videoURL.
+func (cfg *apiConfig) videoKey(videoURL string) (string, bool) {
+	prefix := cfg.videoBaseURL + "/"
+	if !strings.HasPrefix(videoURL, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(videoURL, prefix), true
+}
+
+// assetLinkTTL is how long the signed links to the media of a video that
+// isn't public work.
+const assetLinkTTL = time.Hour
+
+// assetsHandler serves thumbnails, and videos when they're stored locally,
+// from the assets directory. The media of public videos is served to
+// anyone. Anything else needs a link signed by dbVideoToSignedVideo, so the
+// media of private and unlisted videos is only served to people who could
+// load the video, and only for assetLinkTTL after they did.
+func (cfg *apiConfig) assetsHandler() http.Handler {
+	files := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !cfg.verifyAssetLink(r.URL.Path, query.Get("expires"), query.Get("signature")) {
+			public, err := cfg.db.WithContext(r.Context()).IsPublicVideoMedia(r.URL.Path)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check media", err)
+				return
+			}
+			// Not found rather than forbidden, so links can't be probed
+			// for media that exists.
+			if !public {
+				respondWithError(w, http.StatusNotFound, "Not found", nil)
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// dbVideoToSignedVideo returns a video as it's shown to someone allowed to
+// see it. Unless the video is public, its media URLs are swapped for links
+// that work for assetLinkTTL: signed links to /assets/, or presigned S3
+// links in place of the CloudFront URL.
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.Visibility == database.VisibilityPublic {
+		return video, nil
+	}
+	expiresAt := time.Now().Add(assetLinkTTL)
+	if video.ThumbnailURL != nil {
+		thumbnailURL := cfg.signedAssetURL(*video.ThumbnailURL, expiresAt)
+		video.ThumbnailURL = &thumbnailURL
+	}
+	if video.VideoURL == nil {
+		return video, nil
+	}
+	videoURL := cfg.signedAssetURL(*video.VideoURL, expiresAt)
+	if key, ok := cfg.videoKey(*video.VideoURL); ok && cfg.videoPresigner != nil {
+		var err error
+		videoURL, err = cfg.videoPresigner.PresignGet(ctx, key, assetLinkTTL)
+		if err != nil {
+			return database.Video{}, fmt.Errorf("couldn't presign video: %w", err)
+		}
+	}
+	video.VideoURL = &videoURL
+	return video, nil
+}
+
+// signedAssetURL adds a signature to a URL in /assets/ that makes the file
+// it points to servable until expiresAt. Other URLs are returned unchanged.
+func (cfg *apiConfig) signedAssetURL(rawURL string, expiresAt time.Time) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/assets/") {
+		return rawURL
+	}
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", cfg.assetSignature(u.Path, expires))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (cfg *apiConfig) assetSignature(assetPath, expires string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	fmt.Fprintf(mac, "asset:%s:%s", assetPath, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAssetLink checks a signed asset link's signature and expiry.
+func (cfg *apiConfig) verifyAssetLink(assetPath, expires, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	expected := cfg.assetSignature(assetPath, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(time.Unix(unix, 0))
+}
+
+// deleteVideoBlobs removes a video's thumbnail and media file from storage.
+// Media that isn't in one of our stores is left alone.
+func (cfg *apiConfig) deleteVideoBlobs(ctx context.Context, video database.Video) error {
+	if video.ThumbnailURL != nil {
+		if key, ok := cfg.thumbnailKey(*video.ThumbnailURL); ok {
+			err := cfg.thumbnailStore.Delete(ctx, key)
+			if err != nil {
+				return fmt.Errorf("couldn't delete thumbnail %s: %w", key, err)
+			}
+		}
+	}
+	if video.VideoURL != nil {
+		if key, ok := cfg.videoKey(*video.VideoURL); ok {
+			err := cfg.videoStore.Delete(ctx, key)
+			if err != nil {
+				return fmt.Errorf("couldn't delete video %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// createTestThumbnail creates a video with a thumbnail in the assets
+// directory, and returns the path the thumbnail is served at.
+func createTestThumbnail(t *testing.T, cfg *apiConfig, user database.User, name string, visibility database.VideoVisibility) string {
+	t.Helper()
+	err := os.MkdirAll(cfg.assetsRoot, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(cfg.assetsRoot, name), []byte("thumbnail"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: name, UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.publicURL + "/assets/" + name
+	video.ThumbnailURL = &thumbnailURL
+	video.Visibility = visibility
+	err = cfg.db.UpdateVideo(&video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "/assets/" + name
+}
+
+func TestAssetsServePublicMedia(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	assetPath := createTestThumbnail(t, cfg, user, "public.png", database.VisibilityPublic)
+
+	resp := sendTestRequest(t, srv, http.MethodGet, assetPath, "", nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+}
+
+func TestAssetsRequireSignedLinkForPrivateMedia(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	assetPath := createTestThumbnail(t, cfg, user, "private.png", database.VisibilityPrivate)
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	resp := sendTestRequest(t, srv, http.MethodGet, assetPath, "", nil)
+	decodeTestResponse(t, resp, http.StatusNotFound, nil)
+
+	// The owner is handed a signed link, which works without a token.
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", session.Token, nil)
+	var videos []database.Video
+	decodeTestResponse(t, resp, http.StatusOK, &videos)
+	if len(videos) != 1 || videos[0].ThumbnailURL == nil {
+		t.Fatalf("got %+v, want one video with a thumbnail", videos)
+	}
+	signed := requestPath(t, *videos[0].ThumbnailURL)
+	if signed == assetPath {
+		t.Fatalf("private video's thumbnail URL %q isn't signed", signed)
+	}
+	resp = sendTestRequest(t, srv, http.MethodGet, signed, "", nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+
+	expired := requestPath(t, cfg.signedAssetURL(cfg.publicURL+assetPath, time.Now().Add(-time.Minute)))
+	otherFile := createTestThumbnail(t, cfg, user, "other.png", database.VisibilityPrivate)
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := otherFile + "?" + u.RawQuery
+	for _, link := range []string{expired, tampered} {
+		resp = sendTestRequest(t, srv, http.MethodGet, link, "", nil)
+		decodeTestResponse(t, resp, http.StatusNotFound, nil)
+	}
+}
+
+func TestSignedVideoPresignsS3Media(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.videoBaseURL = "https://cdn.example.com"
+	cfg.videoPresigner = storage.S3Store{
+		Client: s3.New(s3.Options{
+			Region: "us-east-1",
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+			}),
+		}),
+		Bucket: "tubely",
+	}
+	videoURL := cfg.videoURL("abc.mp4")
+	video := database.Video{VideoURL: &videoURL, Visibility: database.VisibilityPrivate}
+
+	signed, err := cfg.dbVideoToSignedVideo(context.Background(), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(*signed.VideoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(*signed.VideoURL, cfg.videoBaseURL) || !strings.Contains(u.Host, "tubely") || u.Path != "/abc.mp4" {
+		t.Fatalf("got %s, want a presigned S3 URL for abc.mp4", *signed.VideoURL)
+	}
+	if u.Query().Get("X-Amz-Signature") == "" || u.Query().Get("X-Amz-Expires") != "3600" {
+		t.Fatalf("got %s, want a signature that expires after an hour", *signed.VideoURL)
+	}
+
+	// Public videos keep their CloudFront URL.
+	video.Visibility = database.VisibilityPublic
+	signed, err = cfg.dbVideoToSignedVideo(context.Background(), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *signed.VideoURL != videoURL {
+		t.Fatalf("public video: got %s, want %s", *signed.VideoURL, videoURL)
+	}
+}
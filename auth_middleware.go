@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+var (
+	// errUnknownUser means a token was issued to an account that no longer
+	// exists.
+	errUnknownUser = errors.New("token's user doesn't exist")
+	// errUserDisabled means a token was issued to an account that has since
+	// been disabled.
+	errUserDisabled = errors.New("token's user is disabled")
+	// errTokenRevoked means the token was issued before its account's
+	// sessions were ended, such as by a password change.
+	errTokenRevoked = errors.New("token has been revoked")
+	// errUserLookup means the token's account couldn't be loaded.
+	errUserLookup = errors.New("couldn't get token's user")
+)
+
+// authenticate validates the request's bearer access token and loads the
+// account it was issued to. It returns auth.ErrNoAuthHeaderIncluded if the
+// request carries no token at all. The caller's role is read from the
+// database rather than trusted from the token, and the token must be of the
+// account's current token version, so an account that is demoted, disabled,
+// deleted or signed out everywhere loses access immediately rather than
+// when its tokens expire.
+func (cfg *apiConfig) authenticate(r *http.Request) (authz.Principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+	accessToken, err := auth.ValidateAccessToken(token, cfg.jwtSecret)
+	if err != nil {
+		return authz.Principal{}, err
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(accessToken.UserID)
+	if err != nil {
+		return authz.Principal{}, fmt.Errorf("%w: %w", errUserLookup, err)
+	}
+	if user == nil {
+		return authz.Principal{}, errUnknownUser
+	}
+	if user.DisabledAt != nil {
+		return authz.Principal{}, errUserDisabled
+	}
+	if accessToken.Version != user.TokenVersion {
+		return authz.Principal{}, errTokenRevoked
+	}
+	return authz.Principal{
+		UserID: user.ID,
+		Role:   auth.Role(user.Role),
+		Scopes: accessToken.Scopes,
+	}, nil
+}
+
+// respondWithAuthError responds to a request authenticate rejected.
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrNoAuthHeaderIncluded):
+		respondWithErrorCode(w, http.StatusUnauthorized, codeTokenMissing, "Couldn't find JWT", err)
+	case errors.Is(err, errUserDisabled):
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDisabled, "Account is disabled", nil)
+	case errors.Is(err, errUserLookup):
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+	default:
+		respondWithErrorCode(w, http.StatusUnauthorized, codeTokenInvalid, "Couldn't validate JWT", err)
+	}
+}
+
+// requireAuth rejects requests without a valid access token for an active
+// account and passes the caller's principal to next in the request context.
+func (cfg *apiConfig) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		logging.Add(r.Context(), slog.String(logging.KeyUserID, principal.UserID.String()))
+		next(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+	}
+}
+
+// optionalAuth lets anonymous requests through without a principal. A token
+// that is present but invalid is still rejected, rather than silently
+// treating the caller as anonymous.
+func (cfg *apiConfig) optionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := cfg.authenticate(r)
+		if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+			next(w, r)
+			return
+		}
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		logging.Add(r.Context(), slog.String(logging.KeyUserID, principal.UserID.String()))
+		next(w, r.WithContext(authz.NewContext(r.Context(), principal)))
+	}
+}
+
+// requireRole rejects callers without at least the given role. It must run
+// inside requireAuth, which has read the caller's role from the database.
+func requireRole(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := authz.FromContext(r.Context())
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+			return
+		}
+		if !principal.HasRole(role) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireScope rejects callers whose token wasn't issued for scope. It must
+// run inside requireAuth.
+func requireScope(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := authz.FromContext(r.Context())
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Authentication required", nil)
+			return
+		}
+		if !principal.HasScope(scope) {
+			respondWithErrorCode(w, http.StatusForbidden, codeInsufficientScope, "Token is missing the "+string(scope)+" scope", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestPrincipal returns the caller set by requireAuth. Handlers behind
+// optionalAuth should use authz.FromContext to tell anonymous callers apart.
+func requestPrincipal(r *http.Request) authz.Principal {
+	principal, _ := authz.FromContext(r.Context())
+	return principal
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestDisabledUserTokenRejected(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	admin := createTestUser(t, cfg, "admin@example.com", "adminpass")
+	err := cfg.db.SetUserRole(admin.ID, string(auth.RoleAdmin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	adminSession := loginTestUser(t, srv, "admin@example.com", "adminpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	resp := sendTestRequest(t, srv, http.MethodGet, "/api/videos", session.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+
+	resp = sendTestRequest(t, srv, http.MethodPost, "/admin/users/"+user.ID.String()+"/disable", adminSession.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+
+	// Both requireAuth and optionalAuth turn the token away, though it
+	// hasn't expired.
+	for _, path := range []string{"/api/videos", "/api/videos/" + user.ID.String()} {
+		resp = sendTestRequest(t, srv, http.MethodGet, path, session.Token, nil)
+		if code := problemCode(t, resp, http.StatusForbidden); code != codeAccountDisabled {
+			t.Errorf("GET %s: got code %q, want %q", path, code, codeAccountDisabled)
+		}
+	}
+	resp = sendTestRequest(t, srv, http.MethodPost, "/api/refresh", session.RefreshToken, nil)
+	decodeTestResponse(t, resp, http.StatusUnauthorized, nil)
+
+	// Disabling ended the account's sessions, so re-enabling it doesn't
+	// bring them back.
+	resp = sendTestRequest(t, srv, http.MethodPost, "/admin/users/"+user.ID.String()+"/enable", adminSession.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", session.Token, nil)
+	decodeTestResponse(t, resp, http.StatusUnauthorized, nil)
+	session = loginTestUser(t, srv, "user@example.com", "userpass")
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", session.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+}
+
+func TestDemotedAdminLosesAccess(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	admin := createTestUser(t, cfg, "admin@example.com", "adminpass")
+	err := cfg.db.SetUserRole(admin.ID, string(auth.RoleAdmin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := loginTestUser(t, srv, "admin@example.com", "adminpass")
+
+	resp := sendTestRequest(t, srv, http.MethodGet, "/admin/users", session.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+
+	err = cfg.db.SetUserRole(admin.ID, string(auth.RoleUser))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = sendTestRequest(t, srv, http.MethodGet, "/admin/users", session.Token, nil)
+	decodeTestResponse(t, resp, http.StatusForbidden, nil)
+}
+
+func TestPasswordChangeRevokesAccessTokens(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "oldpassword")
+	other := loginTestUser(t, srv, "user@example.com", "oldpassword")
+	current := loginTestUser(t, srv, "user@example.com", "oldpassword")
+
+	resp := sendTestRequest(t, srv, http.MethodPut, "/api/users/password", current.Token, map[string]string{
+		"current_password": "oldpassword",
+		"new_password":     "newpassword",
+	})
+	var fresh testSession
+	decodeTestResponse(t, resp, http.StatusOK, &fresh)
+
+	// Every token issued before the change is turned away, the caller's
+	// own included, though none has expired.
+	for _, token := range []string{other.Token, current.Token} {
+		resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", token, nil)
+		if code := problemCode(t, resp, http.StatusUnauthorized); code != codeTokenInvalid {
+			t.Errorf("old token: got code %q, want %q", code, codeTokenInvalid)
+		}
+	}
+	resp = sendTestRequest(t, srv, http.MethodPost, "/api/refresh", other.RefreshToken, nil)
+	decodeTestResponse(t, resp, http.StatusUnauthorized, nil)
+
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", fresh.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+	resp = sendTestRequest(t, srv, http.MethodPost, "/api/refresh", fresh.RefreshToken, nil)
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	decodeTestResponse(t, resp, http.StatusOK, &refreshed)
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", refreshed.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+}
+
+func TestDeletedUserTokenRejected(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	other := loginTestUser(t, srv, "user@example.com", "userpass")
+	current := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	resp := sendTestRequest(t, srv, http.MethodDelete, "/api/users", current.Token, map[string]string{
+		"password": "userpass",
+	})
+	decodeTestResponse(t, resp, http.StatusNoContent, nil)
+
+	for _, token := range []string{other.Token, current.Token} {
+		resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", token, nil)
+		if code := problemCode(t, resp, http.StatusUnauthorized); code != codeTokenInvalid {
+			t.Errorf("deleted account's token: got code %q, want %q", code, codeTokenInvalid)
+		}
+	}
+	resp = sendTestRequest(t, srv, http.MethodPost, "/api/refresh", other.RefreshToken, nil)
+	decodeTestResponse(t, resp, http.StatusUnauthorized, nil)
+}
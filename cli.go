@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// command is one of tubely's subcommands, such as "user create". Its name
+// is the words that select it.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands is filled in by init, since commands print their own usage from
+// it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "run the HTTP server and job workers (the default)", runServe},
+		{"migrate", "", "create or upgrade the database schema", runMigrate},
+		{"user list", "", "list accounts", runUserList},
+		{"user create", "<email>", "create an account", runUserCreate},
+		{"user disable", "<email|id>", "disable an account and end its sessions", runUserDisable},
+		{"user enable", "<email|id>", "re-enable a disabled account", runUserEnable},
+		{"user reset-password", "<email|id>", "set a new password and end the account's sessions", runUserResetPassword},
+		{"video list", "", "list videos", runVideoList},
+		{"video inspect", "<id>", "show a video and whether its media is stored", runVideoInspect},
+		{"video reprocess", "<id>", "run a video's media through processing again", runVideoReprocess},
+		{"video delete", "<id>", "delete a video and its stored media", runVideoDelete},
+		{"video import", "<dir>", "import a directory of MP4s, with sidecar JSON and thumbnails, for a user", runVideoImport},
+		{"storage reconcile", "", "find stored media without a video, and videos without media", runStorageReconcile},
+		{"db backup", "<file>", "write a consistent copy of the database", runDBBackup},
+	}
+}
+
+// errUsage means the command line was wrong and the problem has already
+// been printed.
+var errUsage = errors.New("usage")
+
+// runCLI runs the command named by args and returns the exit status. With
+// no command, or only flags, it serves, as tubely always has.
+func runCLI(args []string) int {
+	if len(args) > 0 && (args[0] == "help" || args[0] == "--help") {
+		printCommands(os.Stdout)
+		return 0
+	}
+	cmd, args, ok := findCommand(args)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tubely: unknown command %q\n\n", strings.Join(args, " "))
+		printCommands(os.Stderr)
+		return 2
+	}
+
+	// Interrupting a command cancels what it's doing rather than killing
+	// it halfway through a write.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := cmd.run(ctx, args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+
+	var errs config.Errors
+	if errors.As(err, &errs) {
+		fmt.Fprintf(os.Stderr, "tubely %s: invalid configuration:\n", cmd.name)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  %s\n", err)
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "tubely %s: %s\n", cmd.name, err)
+	return 1
+}
+
+// findCommand picks the command named by the leading words of args and
+// returns the rest.
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commands[0], args, true
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		match := true
+		for i, word := range words {
+			if args[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, args, false
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: tubely <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command reads the server's configuration, so it works on the same")
+	fmt.Fprintln(w, "database and storage. Run tubely <command> -h for its flags.")
+}
+
+// newFlagSet makes the flag set for a command. Its usage lists the
+// command's own flags; configuration flags are accepted too, but only
+// listed by tubely serve -h.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("tubely "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		var cmd command
+		for _, c := range commands {
+			if c.name == name {
+				cmd = c
+			}
+		}
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: tubely %s [flags] %s\n\n", name, cmd.args)
+		fmt.Fprintf(out, "%s%s.\n\n", strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+		own := flag.NewFlagSet(name, flag.ContinueOnError)
+		own.SetOutput(out)
+		fs.VisitAll(func(f *flag.Flag) {
+			if !config.IsFlag(f.Name) {
+				own.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		own.PrintDefaults()
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Configuration flags, such as -config and -database.path, are accepted too;")
+		fmt.Fprintln(out, "tubely serve -h lists them.")
+	}
+	return fs
+}
+
+// loadCommandConfig parses a command's flags, which must leave nargs
+// arguments, and loads the configuration. Logs go to stderr so they don't
+// mix with the command's output.
+func loadCommandConfig(fs *flag.FlagSet, args []string, nargs int) (*config.Config, error) {
+	loader := config.NewLoader(fs)
+	err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, usageError(err)
+	}
+	if fs.NArg() != nargs {
+		fmt.Fprintf(fs.Output(), "expected %d argument(s), got %d\n\n", nargs, fs.NArg())
+		fs.Usage()
+		return nil, errUsage
+	}
+	conf, err := loader.Load()
+	if err != nil {
+		return nil, err
+	}
+	level, err := logging.ParseLevel(conf.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logging.New(os.Stderr, level))
+	return conf, nil
+}
+
+// parseInterspersed parses flags that come before or after the
+// arguments, as in tubely user create a@example.com -role admin, which the
+// flag package stops parsing at the first argument.
+func parseInterspersed(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	// Leave fs.Args() holding just the arguments.
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+// setupCommand is loadCommandConfig, then opens the database and stores.
+// The caller must close the returned config.
+func setupCommand(fs *flag.FlagSet, args []string, nargs int) (*apiConfig, error) {
+	conf, err := loadCommandConfig(fs, args, nargs)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIConfig(conf)
+}
+
+// usageError converts a flag parsing error, which the flag set has already
+// printed, into errUsage. Asking for help isn't an error.
+func usageError(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return errUsage
+}
+
+// newTable writes aligned columns to stdout; flush it when done.
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoRefreshToken is returned by Refresh when the client has no session
+// to refresh.
+var ErrNoRefreshToken = errors.New("tubely: no refresh token")
+
+// User is an account.
+type User struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+// Session is a signed-in user with their tokens. The access token is sent
+// with each request; the refresh token gets a new access token when it
+// expires.
+type Session struct {
+	User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// MFARequiredError is returned by Login when the account has two-factor
+// authentication enabled. Finish signing in with LoginTOTP.
+type MFARequiredError struct {
+	ChallengeToken string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "tubely: two-factor authentication required"
+}
+
+// Login signs in with an email and password, and the client uses the
+// session from then on.
+func (c *Client) Login(ctx context.Context, email, password string) (Session, error) {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	// The response is a session, or a challenge if a second factor is
+	// needed.
+	type response struct {
+		Session
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/login", parameters{Email: email, Password: password})
+	if err != nil {
+		return Session{}, err
+	}
+	var resp response
+	_, err = c.do(req, &resp)
+	if err != nil {
+		return Session{}, err
+	}
+	if resp.MFARequired {
+		return Session{}, &MFARequiredError{ChallengeToken: resp.ChallengeToken}
+	}
+	c.SetTokens(resp.Token, resp.RefreshToken)
+	return resp.Session, nil
+}
+
+// TOTPLoginParams finishes a sign-in that needed a second factor: the
+// challenge from Login with either a code from the user's authenticator or
+// one of their recovery codes.
+type TOTPLoginParams struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+// LoginTOTP finishes a sign-in Login started, and the client uses the
+// session from then on.
+func (c *Client) LoginTOTP(ctx context.Context, params TOTPLoginParams) (Session, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/login/totp", params)
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	_, err = c.do(req, &session)
+	if err != nil {
+		return Session{}, err
+	}
+	c.SetTokens(session.Token, session.RefreshToken)
+	return session, nil
+}
+
+// Refresh gets a new access token with the session's refresh token, and
+// uses it from then on. Call it when a request fails with a 401 because
+// the access token has expired.
+func (c *Client) Refresh(ctx context.Context) (string, error) {
+	type response struct {
+		Token string `json:"token"`
+	}
+
+	_, refreshToken := c.Tokens()
+	if refreshToken == "" {
+		return "", ErrNoRefreshToken
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/refresh", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+refreshToken)
+	var resp response
+	_, err = c.do(req, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+	// Another refresh may have replaced the session meanwhile.
+	if c.tokens.refreshToken == refreshToken {
+		c.tokens.accessToken = resp.Token
+	}
+	return resp.Token, nil
+}
@@ -0,0 +1,194 @@
+// Package client is a typed Go client for the Tubely API, for services
+// that call it instead of a browser. It covers signing in, refreshing
+// access tokens, and creating, uploading, listing, updating and deleting
+// videos; the whole API is described by the OpenAPI document the server
+// serves at /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	workspaceHeader      = "X-Workspace-ID"
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxErrorBody is how much of a response that isn't a problem is kept
+	// as an error's detail.
+	maxErrorBody = 4096
+)
+
+// Client calls the Tubely API. It keeps the tokens of the session it signed
+// in with, and is safe to use from several goroutines.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// workspace is the organization requests work in, or empty for the
+	// caller's personal videos.
+	workspace string
+	tokens    *tokens
+}
+
+// tokens is shared by a client and the clients InWorkspace makes from it,
+// so refreshing one refreshes them all.
+type tokens struct {
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+}
+
+// New returns a client for the API at baseURL, such as
+// "https://tubely.example.com". A nil httpClient means http.DefaultClient.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		tokens:     &tokens{},
+	}
+}
+
+// SetTokens makes the client use a session it didn't sign in for itself.
+// refreshToken may be empty if the client won't need to refresh.
+func (c *Client) SetTokens(accessToken, refreshToken string) {
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+	c.tokens.accessToken = accessToken
+	c.tokens.refreshToken = refreshToken
+}
+
+// Tokens returns the client's current access and refresh tokens.
+func (c *Client) Tokens() (accessToken, refreshToken string) {
+	c.tokens.mu.Lock()
+	defer c.tokens.mu.Unlock()
+	return c.tokens.accessToken, c.tokens.refreshToken
+}
+
+// InWorkspace returns a client that works in an organization: it creates
+// and lists that organization's videos rather than the caller's personal
+// ones. It shares c's session.
+func (c *Client) InWorkspace(orgID uuid.UUID) *Client {
+	workspaced := *c
+	workspaced.workspace = orgID.String()
+	return &workspaced
+}
+
+// Error is an error response from the API.
+type Error struct {
+	StatusCode int
+	// Code is a stable identifier to branch on, such as
+	// "invalid_credentials" or "quota_exceeded". It's empty if the
+	// response wasn't a problem.
+	Code string
+	// Detail describes the error for people.
+	Detail string
+	// Fields lists the invalid fields of a request that failed validation.
+	Fields []FieldError
+}
+
+// FieldError is one invalid field in a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("tubely: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, field := range e.Fields {
+		msg += fmt.Sprintf("; %s %s", field.Field, field.Message)
+	}
+	return msg
+}
+
+// newRequest makes a request to the API, authenticated with the client's
+// access token and sent in its workspace. A non-nil body is sent as JSON.
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken, _ := c.Tokens(); accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	if c.workspace != "" {
+		req.Header.Set(workspaceHeader, c.workspace)
+	}
+	return req, nil
+}
+
+// do sends a request and decodes a successful response's JSON into out,
+// unless out is nil. Unsuccessful responses are returned as an *Error. The
+// response's headers are returned either way.
+func (c *Client) do(req *http.Request, out any) (http.Header, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Header, responseError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.Header, nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return resp.Header, fmt.Errorf("tubely: couldn't decode response: %w", err)
+	}
+	return resp.Header, nil
+}
+
+func responseError(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return fmt.Errorf("tubely: %d %s: couldn't read response: %w", resp.StatusCode, http.StatusText(resp.StatusCode), err)
+	}
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" {
+		var problem struct {
+			Detail string       `json:"detail"`
+			Code   string       `json:"code"`
+			Errors []FieldError `json:"errors"`
+		}
+		if json.Unmarshal(body, &problem) == nil {
+			apiErr.Code = problem.Code
+			apiErr.Detail = problem.Detail
+			apiErr.Fields = problem.Errors
+			return apiErr
+		}
+	}
+	apiErr.Detail = strings.TrimSpace(string(body))
+	return apiErr
+}
@@ -0,0 +1,410 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestServer serves handler and returns a client for it.
+func newTestServer(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, srv.Client())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "code": code, "detail": detail})
+}
+
+func decodeBody(t *testing.T, r *http.Request, v any) {
+	t.Helper()
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s: Content-Type is %q", r.Method, r.URL.Path, got)
+	}
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		t.Errorf("%s %s: couldn't decode body: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	userID := uuid.New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		decodeBody(t, r, &params)
+		if params["email"] != "me@example.com" || params["password"] != "hunter2" {
+			writeProblem(w, http.StatusUnauthorized, "invalid_credentials", "Incorrect email or password")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id":            userID,
+			"email":         "me@example.com",
+			"role":          "user",
+			"token":         "access",
+			"refresh_token": "refresh",
+		})
+	})
+	mux.HandleFunc("GET /api/videos", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Authorization is %q after login", got)
+		}
+		writeJSON(w, http.StatusOK, []ListedVideo{})
+	})
+	c := newTestServer(t, mux)
+	ctx := context.Background()
+
+	_, err := c.Login(ctx, "me@example.com", "wrong")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("wrong password: got %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "invalid_credentials" {
+		t.Fatalf("wrong password: got %d %q", apiErr.StatusCode, apiErr.Code)
+	}
+
+	session, err := c.Login(ctx, "me@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != userID || session.Email != "me@example.com" || session.Token != "access" {
+		t.Fatalf("got session %+v", session)
+	}
+	accessToken, refreshToken := c.Tokens()
+	if accessToken != "access" || refreshToken != "refresh" {
+		t.Fatalf("client has tokens %q, %q", accessToken, refreshToken)
+	}
+	_, err = c.ListVideos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginMFARequired(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"mfa_required": true, "challenge_token": "challenge"})
+	})
+	mux.HandleFunc("POST /api/login/totp", func(w http.ResponseWriter, r *http.Request) {
+		var params TOTPLoginParams
+		decodeBody(t, r, &params)
+		if params.ChallengeToken != "challenge" || params.Code != "123456" {
+			writeProblem(w, http.StatusUnauthorized, "invalid_mfa_code", "Invalid code")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"token": "access", "refresh_token": "refresh"})
+	})
+	c := newTestServer(t, mux)
+	ctx := context.Background()
+
+	_, err := c.Login(ctx, "me@example.com", "hunter2")
+	var mfaErr *MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		t.Fatalf("got %v, want an *MFARequiredError", err)
+	}
+	if accessToken, _ := c.Tokens(); accessToken != "" {
+		t.Fatalf("client has access token %q before the second factor", accessToken)
+	}
+
+	_, err = c.LoginTOTP(ctx, TOTPLoginParams{ChallengeToken: mfaErr.ChallengeToken, Code: "123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken, _ := c.Tokens(); accessToken != "access" {
+		t.Fatalf("client has access token %q", accessToken)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer refresh" {
+			writeProblem(w, http.StatusUnauthorized, "token_invalid", "Couldn't validate refresh token")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"token": "new-access"})
+	})
+	c := newTestServer(t, mux)
+	ctx := context.Background()
+
+	_, err := c.Refresh(ctx)
+	if !errors.Is(err, ErrNoRefreshToken) {
+		t.Fatalf("without a session: got %v, want ErrNoRefreshToken", err)
+	}
+
+	c.SetTokens("old-access", "refresh")
+	workspaced := c.InWorkspace(uuid.New())
+	token, err := workspaced.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "new-access" {
+		t.Fatalf("got token %q", token)
+	}
+	// Clients made with InWorkspace share the session.
+	accessToken, refreshToken := c.Tokens()
+	if accessToken != "new-access" || refreshToken != "refresh" {
+		t.Fatalf("client has tokens %q, %q", accessToken, refreshToken)
+	}
+
+	c.SetTokens("old-access", "revoked")
+	_, err = c.Refresh(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "token_invalid" {
+		t.Fatalf("revoked refresh token: got %v", err)
+	}
+	if accessToken, _ := c.Tokens(); accessToken != "old-access" {
+		t.Fatalf("failed refresh replaced the access token with %q", accessToken)
+	}
+}
+
+func TestVideoCRUD(t *testing.T) {
+	orgID := uuid.New()
+	videos := map[uuid.UUID]*Video{}
+	etags := map[uuid.UUID]int{}
+	etag := func(id uuid.UUID) string {
+		return `"` + strings.Repeat("v", etags[id]) + `"`
+	}
+	lookup := func(w http.ResponseWriter, r *http.Request) *Video {
+		id, err := uuid.Parse(r.PathValue("videoID"))
+		if err != nil || videos[id] == nil {
+			writeProblem(w, http.StatusNotFound, "", "Video not found")
+			return nil
+		}
+		return videos[id]
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/videos", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Workspace-ID"); got != orgID.String() {
+			t.Errorf("X-Workspace-ID is %q", got)
+		}
+		if got := r.Header.Get("Idempotency-Key"); got != "create-1" {
+			t.Errorf("Idempotency-Key is %q", got)
+		}
+		var params CreateVideoParams
+		decodeBody(t, r, &params)
+		if params.Title == "" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"code":"validation_failed","errors":[{"field":"title","code":"required","message":"is required"}]}`)
+			return
+		}
+		video := &Video{ID: uuid.New(), Title: params.Title, Description: params.Description, Visibility: VisibilityPrivate, OrganizationID: &orgID}
+		videos[video.ID] = video
+		etags[video.ID] = 1
+		writeJSON(w, http.StatusCreated, video)
+	})
+	mux.HandleFunc("GET /api/videos", func(w http.ResponseWriter, r *http.Request) {
+		var listed []ListedVideo
+		for _, video := range videos {
+			listed = append(listed, ListedVideo{Video: *video, Role: CollaboratorOwner})
+		}
+		writeJSON(w, http.StatusOK, listed)
+	})
+	mux.HandleFunc("GET /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		video := lookup(w, r)
+		if video == nil {
+			return
+		}
+		w.Header().Set("ETag", etag(video.ID))
+		writeJSON(w, http.StatusOK, video)
+	})
+	mux.HandleFunc("PATCH /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		video := lookup(w, r)
+		if video == nil {
+			return
+		}
+		if r.Header.Get("If-Match") != etag(video.ID) {
+			writeProblem(w, http.StatusPreconditionFailed, "", "Video has changed")
+			return
+		}
+		var params map[string]any
+		decodeBody(t, r, &params)
+		// Only the fields being changed are sent.
+		if _, ok := params["description"]; ok {
+			t.Errorf("unchanged description was sent: %v", params)
+		}
+		if title, ok := params["title"].(string); ok {
+			video.Title = title
+		}
+		if visibility, ok := params["visibility"].(string); ok {
+			video.Visibility = Visibility(visibility)
+		}
+		etags[video.ID]++
+		w.Header().Set("ETag", etag(video.ID))
+		writeJSON(w, http.StatusOK, video)
+	})
+	mux.HandleFunc("DELETE /api/videos/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		video := lookup(w, r)
+		if video == nil {
+			return
+		}
+		delete(videos, video.ID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := newTestServer(t, mux).InWorkspace(orgID)
+	ctx := context.Background()
+
+	_, err := c.CreateVideo(ctx, CreateVideoParams{IdempotencyKey: "create-1"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("without a title: got %v, want a 422 *Error", err)
+	}
+	if len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "title" {
+		t.Fatalf("without a title: got fields %+v", apiErr.Fields)
+	}
+
+	created, err := c.CreateVideo(ctx, CreateVideoParams{Title: "Boots", Description: "A bear", IdempotencyKey: "create-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Title != "Boots" || created.Description != "A bear" {
+		t.Fatalf("created %+v", created)
+	}
+
+	listed, err := c.ListVideos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Role != CollaboratorOwner {
+		t.Fatalf("listed %+v", listed)
+	}
+
+	fetched, err := c.GetVideo(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.ETag == "" {
+		t.Fatal("GetVideo didn't set the ETag")
+	}
+
+	title := "Boots the bear"
+	visibility := VisibilityPublic
+	updated, err := c.UpdateVideo(ctx, created.ID, fetched.ETag, UpdateVideoParams{Title: &title, Visibility: &visibility})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != title || updated.Visibility != VisibilityPublic || updated.Description != "A bear" {
+		t.Fatalf("updated %+v", updated)
+	}
+	if updated.ETag == fetched.ETag {
+		t.Fatal("UpdateVideo didn't return the new ETag")
+	}
+
+	_, err = c.UpdateVideo(ctx, created.ID, fetched.ETag, UpdateVideoParams{Title: &title})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("stale ETag: got %v, want a 412 *Error", err)
+	}
+
+	err = c.DeleteVideo(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetVideo(ctx, created.ID)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleted video: got %v, want a 404 *Error", err)
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	videoID := uuid.New()
+	file := bytes.Repeat([]byte("tubely"), 100_000)
+
+	var gotContentLength int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/video_upload/{videoID}", func(w http.ResponseWriter, r *http.Request) {
+		gotContentLength = r.ContentLength
+		if got := r.Header.Get("Idempotency-Key"); got != "upload-1" {
+			t.Errorf("Idempotency-Key is %q", got)
+		}
+		part, header, err := r.FormFile("video")
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		defer part.Close()
+		if got := header.Header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("part Content-Type is %q", got)
+		}
+		if header.Filename != "boots.mp4" {
+			t.Errorf("filename is %q", header.Filename)
+		}
+		dat, err := io.ReadAll(part)
+		if err != nil || !bytes.Equal(dat, file) {
+			t.Errorf("server got %d bytes, want the %d sent", len(dat), len(file))
+		}
+		videoURL := "https://cdn.example.com/" + r.PathValue("videoID") + ".mp4"
+		writeJSON(w, http.StatusOK, Video{ID: videoID, VideoURL: &videoURL})
+	})
+	c := newTestServer(t, mux)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// body hides its length from the client unless size is set.
+		size      int64
+		wantTotal int64
+	}{
+		{name: "known size", size: int64(len(file)), wantTotal: int64(len(file))},
+		{name: "unknown size", size: 0, wantTotal: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastSent int64
+			calls := 0
+			video, err := c.UploadVideo(ctx, videoID, Upload{
+				Body:           io.MultiReader(bytes.NewReader(file)),
+				Size:           tt.size,
+				Filename:       "boots.mp4",
+				IdempotencyKey: "upload-1",
+				Progress: func(sent, total int64) {
+					calls++
+					if total != tt.wantTotal {
+						t.Errorf("progress total is %d, want %d", total, tt.wantTotal)
+					}
+					if sent <= lastSent {
+						t.Errorf("progress went from %d to %d", lastSent, sent)
+					}
+					lastSent = sent
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.VideoURL == nil {
+				t.Fatal("response has no video URL")
+			}
+			if calls < 2 {
+				t.Errorf("progress was reported %d times", calls)
+			}
+			if lastSent != int64(len(file)) {
+				t.Errorf("progress ended at %d, want %d", lastSent, len(file))
+			}
+			if tt.size > 0 && gotContentLength <= tt.size {
+				t.Errorf("Content-Length is %d, want the file's %d plus the form's framing", gotContentLength, tt.size)
+			}
+			if tt.size == 0 && gotContentLength != -1 {
+				t.Errorf("Content-Length is %d for a file of unknown size", gotContentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Visibility is who can watch a video: only its collaborators, anyone with
+// its link, or anyone.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+// CollaboratorRole is what someone may do with a video: view it, edit it,
+// or, as an owner, also delete and share it.
+type CollaboratorRole string
+
+const (
+	CollaboratorViewer CollaboratorRole = "viewer"
+	CollaboratorEditor CollaboratorRole = "editor"
+	CollaboratorOwner  CollaboratorRole = "owner"
+)
+
+type Video struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ThumbnailURL   *string    `json:"thumbnail_url"`
+	VideoURL       *string    `json:"video_url"`
+	SizeBytes      int64      `json:"size_bytes"`
+	Visibility     Visibility `json:"visibility"`
+	Tags           []string   `json:"tags"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description"`
+	UserID         uuid.UUID  `json:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	// ETag is the version of the video's metadata, which UpdateVideo needs.
+	// It's set by GetVideo and UpdateVideo.
+	ETag string `json:"-"`
+}
+
+// ListedVideo is a video with the caller's role on it.
+type ListedVideo struct {
+	Video
+	Role CollaboratorRole `json:"role"`
+}
+
+type CreateVideoParams struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// IdempotencyKey, if set, makes the request safe to retry: a retry
+	// with the same key gets the video the first request created.
+	IdempotencyKey string `json:"-"`
+}
+
+// CreateVideo creates a video, in the client's workspace if it has one.
+// Upload its media with UploadVideo.
+func (c *Client) CreateVideo(ctx context.Context, params CreateVideoParams) (Video, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/videos", params)
+	if err != nil {
+		return Video{}, err
+	}
+	if params.IdempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, params.IdempotencyKey)
+	}
+	var video Video
+	_, err = c.do(req, &video)
+	return video, err
+}
+
+// ListVideos lists the caller's personal videos and the videos shared with
+// them or, in a workspace, the organization's videos. Newest are first.
+func (c *Client) ListVideos(ctx context.Context) ([]ListedVideo, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/videos", nil)
+	if err != nil {
+		return nil, err
+	}
+	var videos []ListedVideo
+	_, err = c.do(req, &videos)
+	return videos, err
+}
+
+func (c *Client) GetVideo(ctx context.Context, videoID uuid.UUID) (Video, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/videos/"+videoID.String(), nil)
+	if err != nil {
+		return Video{}, err
+	}
+	var video Video
+	header, err := c.do(req, &video)
+	if err != nil {
+		return Video{}, err
+	}
+	video.ETag = header.Get("ETag")
+	return video, nil
+}
+
+// UpdateVideoParams changes a video's metadata. Fields left nil are
+// unchanged.
+type UpdateVideoParams struct {
+	Title       *string     `json:"title,omitempty"`
+	Description *string     `json:"description,omitempty"`
+	Visibility  *Visibility `json:"visibility,omitempty"`
+	Tags        *[]string   `json:"tags,omitempty"`
+}
+
+// UpdateVideo changes a video's metadata. etag is the ETag of the video as
+// last fetched; if it has changed since, the update fails with a 412 Error
+// and the video should be fetched again.
+func (c *Client) UpdateVideo(ctx context.Context, videoID uuid.UUID, etag string, params UpdateVideoParams) (Video, error) {
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/videos/"+videoID.String(), params)
+	if err != nil {
+		return Video{}, err
+	}
+	req.Header.Set("If-Match", etag)
+	var video Video
+	header, err := c.do(req, &video)
+	if err != nil {
+		return Video{}, err
+	}
+	video.ETag = header.Get("ETag")
+	return video, nil
+}
+
+// DeleteVideo deletes a video and its stored media.
+func (c *Client) DeleteVideo(ctx context.Context, videoID uuid.UUID) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/api/videos/"+videoID.String(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req, nil)
+	return err
+}
+
+// Upload is a file to upload.
+type Upload struct {
+	Body io.Reader
+	// Size is the length of Body, if it's known. The request is then sent
+	// with a Content-Length, so the server can report the upload's
+	// progress too. It must be exact.
+	Size     int64
+	Filename string
+	// ContentType is the file's media type: video/mp4 for videos, which is
+	// the default, and image/jpeg or image/png for thumbnails.
+	ContentType string
+	// IdempotencyKey, if set, makes the upload safe to retry with the same
+	// file: a retry with the same key gets the first upload's response.
+	IdempotencyKey string
+	// Progress, if set, is called as the file is sent, with how many of its
+	// bytes have been sent and its Size, or -1 if that isn't known.
+	Progress func(sent, total int64)
+}
+
+// UploadVideo uploads an MP4 as a video's media, replacing any it had. It
+// returns once the server has processed and stored it.
+func (c *Client) UploadVideo(ctx context.Context, videoID uuid.UUID, upload Upload) (Video, error) {
+	if upload.ContentType == "" {
+		upload.ContentType = "video/mp4"
+	}
+	return c.upload(ctx, "/api/video_upload/"+videoID.String(), "video", upload)
+}
+
+// UploadThumbnail uploads a JPEG or PNG image as a video's thumbnail.
+func (c *Client) UploadThumbnail(ctx context.Context, videoID uuid.UUID, upload Upload) (Video, error) {
+	return c.upload(ctx, "/api/thumbnail_upload/"+videoID.String(), "thumbnail", upload)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// upload sends a file as the given form field of a multipart request. The
+// file is streamed rather than read into memory first.
+func (c *Client) upload(ctx context.Context, path, field string, upload Upload) (Video, error) {
+	filename := upload.Filename
+	if filename == "" {
+		filename = field
+	}
+
+	// The form's framing is written up front, so its length and the
+	// request's are known if the file's is.
+	var head bytes.Buffer
+	form := multipart.NewWriter(&head)
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+	partHeader.Set("Content-Type", upload.ContentType)
+	_, err := form.CreatePart(partHeader)
+	if err != nil {
+		return Video{}, err
+	}
+	headLen := head.Len()
+	err = form.Close()
+	if err != nil {
+		return Video{}, err
+	}
+	tail := bytes.Clone(head.Bytes()[headLen:])
+	head.Truncate(headLen)
+
+	total := int64(-1)
+	if upload.Size > 0 {
+		total = upload.Size
+	}
+	var file io.Reader = upload.Body
+	if upload.Progress != nil {
+		file = &progressReader{r: upload.Body, total: total, progress: upload.Progress}
+	}
+	body := io.MultiReader(&head, file, bytes.NewReader(tail))
+
+	req, err := c.newRequest(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return Video{}, err
+	}
+	req.Body = io.NopCloser(body)
+	req.ContentLength = -1
+	if total >= 0 {
+		req.ContentLength = int64(headLen) + total + int64(len(tail))
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if upload.IdempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, upload.IdempotencyKey)
+	}
+
+	var video Video
+	_, err = c.do(req, &video)
+	return video, err
+}
+
+// progressReader reports how much of a file has been read.
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runMigrate brings the database schema up to date. Opening the database
+// does the work; the server does the same on startup, so this is for
+// migrating ahead of a deploy.
+func runMigrate(ctx context.Context, args []string) error {
+	flags := newFlagSet("migrate")
+	conf, err := loadCommandConfig(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	db, err := database.NewClient(conf.Database.Path)
+	if err != nil {
+		return fmt.Errorf("couldn't migrate %s: %w", conf.Database.Path, err)
+	}
+	defer db.Close()
+	version, err := db.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is up to date (SQLite %s)\n", conf.Database.Path, version)
+	return nil
+}
+
+func runDBBackup(ctx context.Context, args []string) error {
+	flags := newFlagSet("db backup")
+	conf, err := loadCommandConfig(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	_, err = os.Stat(path)
+	if err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	db, err := database.NewClient(conf.Database.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = db.Backup(ctx, path)
+	if err != nil {
+		return fmt.Errorf("couldn't back up %s: %w", conf.Database.Path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %s to %s (%d bytes)\n", conf.Database.Path, path, info.Size())
+	return nil
+}
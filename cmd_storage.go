@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runStorageReconcile compares the thumbnail and video stores with the
+// videos table. Orphans are blobs no video refers to, left behind by
+// failed uploads or deletes; missing blobs are referred to but gone.
+func runStorageReconcile(ctx context.Context, args []string) error {
+	flags := newFlagSet("storage reconcile")
+	deleteOrphans := flags.Bool("delete-orphans", false, "delete blobs no video refers to")
+	cfg, err := setupCommand(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	videos, err := cfg.db.WithContext(ctx).GetAllVideos()
+	if err != nil {
+		return err
+	}
+	thumbnails := map[string]bool{}
+	media := map[string]bool{}
+	for _, video := range videos {
+		if video.ThumbnailURL != nil {
+			if key, ok := cfg.thumbnailKey(*video.ThumbnailURL); ok {
+				thumbnails[key] = true
+			}
+		}
+		if video.VideoURL != nil {
+			if key, ok := cfg.videoKey(*video.VideoURL); ok {
+				media[key] = true
+			}
+		}
+	}
+
+	stores := []struct {
+		name       string
+		store      storage.Store
+		referenced map[string]bool
+		// skip leaves out keys that belong to another store nested inside
+		// this one.
+		skip string
+	}{
+		// Locally stored videos live under the assets root, which is also
+		// the thumbnail store's root.
+		{"thumbnail", cfg.thumbnailStore, thumbnails, localVideosDir + "/"},
+		{"video", cfg.videoStore, media, ""},
+	}
+	var orphans, missing, deleted int
+	for _, s := range stores {
+		keys, err := s.store.List(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list the %s store: %w", s.name, err)
+		}
+		stored := map[string]bool{}
+		for _, key := range keys {
+			if s.skip != "" && strings.HasPrefix(key, s.skip) {
+				continue
+			}
+			stored[key] = true
+		}
+
+		for _, key := range sortedSet(stored) {
+			if s.referenced[key] {
+				continue
+			}
+			orphans++
+			if !*deleteOrphans {
+				fmt.Printf("orphan\t%s\t%s\n", s.name, key)
+				continue
+			}
+			err := s.store.Delete(ctx, key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return fmt.Errorf("couldn't delete %s: %w", key, err)
+			}
+			deleted++
+			fmt.Printf("deleted\t%s\t%s\n", s.name, key)
+		}
+		for _, key := range sortedSet(s.referenced) {
+			if !stored[key] {
+				missing++
+				fmt.Printf("missing\t%s\t%s\n", s.name, key)
+			}
+		}
+	}
+
+	fmt.Printf("%d videos checked: %d orphaned blobs (%d deleted), %d missing\n", len(videos), orphans, deleted, missing)
+	return nil
+}
+
+func sortedSet(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func runUserList(ctx context.Context, args []string) error {
+	flags := newFlagSet("user list")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	cfg, err := setupCommand(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	users, err := cfg.db.WithContext(ctx).GetUsers()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		for i := range users {
+			users[i].Password = ""
+		}
+		return printJSON(users)
+	}
+	table := newTable()
+	fmt.Fprintln(table, "ID\tEMAIL\tROLE\tVERIFIED\tDISABLED\tCREATED")
+	for _, user := range users {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			user.ID, user.Email, user.Role,
+			yesNo(user.VerifiedAt != nil), yesNo(user.DisabledAt != nil),
+			user.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return table.Flush()
+}
+
+func runUserCreate(ctx context.Context, args []string) error {
+	flags := newFlagSet("user create")
+	password := flags.String("password", "", "password; one is generated and printed if not given")
+	role := flags.String("role", string(auth.RoleUser), "role: user, moderator or admin")
+	unverified := flags.Bool("unverified", false, "make the user verify their email address before uploading")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	email := flags.Arg(0)
+	_, err = mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("%q isn't an email address", email)
+	}
+	if !auth.Role(*role).Valid() {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+	existing, err := cfg.db.WithContext(ctx).GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if existing.ID != uuid.Nil {
+		return fmt.Errorf("%s already has an account", email)
+	}
+
+	generated := *password == ""
+	if generated {
+		*password, err = generatePassword()
+		if err != nil {
+			return err
+		}
+	}
+	if len(*password) > 72 {
+		return fmt.Errorf("password must be at most 72 bytes")
+	}
+	hashedPassword, err := auth.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	db := cfg.db.WithContext(ctx)
+	user, err := db.CreateUser(database.CreateUserParams{
+		Email:    email,
+		Password: hashedPassword,
+	})
+	if err != nil {
+		return err
+	}
+	if *role != string(auth.RoleUser) {
+		err = db.SetUserRole(user.ID, *role)
+		if err != nil {
+			return err
+		}
+	}
+	if !*unverified {
+		err = db.MarkUserVerified(user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Created %s %s (%s)\n", *role, email, user.ID)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return nil
+}
+
+func runUserDisable(ctx context.Context, args []string) error {
+	return setUserDisabled(ctx, "user disable", args, true)
+}
+
+func runUserEnable(ctx context.Context, args []string) error {
+	return setUserDisabled(ctx, "user enable", args, false)
+}
+
+func setUserDisabled(ctx context.Context, name string, args []string, disabled bool) error {
+	flags := newFlagSet(name)
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	user, err := cfg.findUser(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = cfg.db.WithContext(ctx).SetUserDisabled(user.ID, disabled)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		fmt.Printf("Disabled %s and ended their sessions\n", user.Email)
+	} else {
+		fmt.Printf("Enabled %s\n", user.Email)
+	}
+	return nil
+}
+
+func runUserResetPassword(ctx context.Context, args []string) error {
+	flags := newFlagSet("user reset-password")
+	password := flags.String("password", "", "new password; one is generated and printed if not given")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	user, err := cfg.findUser(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	generated := *password == ""
+	if generated {
+		*password, err = generatePassword()
+		if err != nil {
+			return err
+		}
+	}
+	if len(*password) > 72 {
+		return fmt.Errorf("password must be at most 72 bytes")
+	}
+	hashedPassword, err := auth.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+	db := cfg.db.WithContext(ctx)
+	err = db.UpdateUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		return err
+	}
+	// As when the user changes it, a new password ends every session.
+	err = db.EndUserSessions(user.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reset the password of %s and ended their sessions\n", user.Email)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return nil
+}
+
+// findUser looks a user up by ID or email address.
+func (cfg *apiConfig) findUser(ctx context.Context, ref string) (*database.User, error) {
+	db := cfg.db.WithContext(ctx)
+	if id, err := uuid.Parse(ref); err == nil {
+		user, err := db.GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("no user with ID %s", id)
+		}
+		return user, nil
+	}
+	user, err := db.GetUserByEmail(ref)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, fmt.Errorf("no user with email %s", ref)
+	}
+	return &user, nil
+}
+
+// generatePassword makes a random password for an operator to pass on.
+func generatePassword() (string, error) {
+	raw := make([]byte, 18)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func runVideoList(ctx context.Context, args []string) error {
+	flags := newFlagSet("video list")
+	owner := flags.String("user", "", "only list videos created by this user, by email or ID")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	cfg, err := setupCommand(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	var videos []database.Video
+	if *owner != "" {
+		user, err := cfg.findUser(ctx, *owner)
+		if err != nil {
+			return err
+		}
+		videos, err = cfg.db.WithContext(ctx).GetVideosCreatedBy(user.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		videos, err = cfg.db.WithContext(ctx).GetAllVideos()
+		if err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		return printJSON(videos)
+	}
+	table := newTable()
+	fmt.Fprintln(table, "ID\tTITLE\tUSER\tVISIBILITY\tSIZE\tMEDIA\tCREATED")
+	for _, video := range videos {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			video.ID, video.Title, video.UserID, video.Visibility, video.SizeBytes,
+			yesNo(video.VideoURL != nil), video.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return table.Flush()
+}
+
+// videoInspection is what video inspect prints: the video, and where its
+// media is kept.
+type videoInspection struct {
+	Video     database.Video `json:"video"`
+	Media     *storedBlob    `json:"media"`
+	Thumbnail *storedBlob    `json:"thumbnail"`
+}
+
+// storedBlob describes a blob a video refers to. Key is empty if the URL
+// isn't in one of our stores.
+type storedBlob struct {
+	URL    string `json:"url"`
+	Key    string `json:"key,omitempty"`
+	Stored bool   `json:"stored"`
+}
+
+func runVideoInspect(ctx context.Context, args []string) error {
+	flags := newFlagSet("video inspect")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	video, err := cfg.findVideo(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	inspection := videoInspection{Video: video}
+	if video.VideoURL != nil {
+		key, ok := cfg.videoKey(*video.VideoURL)
+		inspection.Media, err = inspectBlob(ctx, cfg.videoStore, *video.VideoURL, key, ok)
+		if err != nil {
+			return err
+		}
+	}
+	if video.ThumbnailURL != nil {
+		key, ok := cfg.thumbnailKey(*video.ThumbnailURL)
+		inspection.Thumbnail, err = inspectBlob(ctx, cfg.thumbnailStore, *video.ThumbnailURL, key, ok)
+		if err != nil {
+			return err
+		}
+	}
+	return printJSON(inspection)
+}
+
+func inspectBlob(ctx context.Context, store storage.Store, url, key string, ok bool) (*storedBlob, error) {
+	blob := &storedBlob{URL: url}
+	if !ok {
+		return blob, nil
+	}
+	blob.Key = key
+	body, err := store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return blob, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	body.Close()
+	blob.Stored = true
+	return blob, nil
+}
+
+// runVideoReprocess downloads a video's media and runs it through the same
+// processing as an upload, replacing the stored file.
+func runVideoReprocess(ctx context.Context, args []string) error {
+	flags := newFlagSet("video reprocess")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	video, err := cfg.findVideo(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if video.VideoURL == nil {
+		return fmt.Errorf("video %s has no media", video.ID)
+	}
+	oldKey, ok := cfg.videoKey(*video.VideoURL)
+	if !ok {
+		return fmt.Errorf("video %s's media, %s, isn't in the video store", video.ID, *video.VideoURL)
+	}
+
+	body, err := cfg.videoStore.Get(ctx, oldKey)
+	if err != nil {
+		return fmt.Errorf("couldn't get %s: %w", oldKey, err)
+	}
+	defer body.Close()
+	tempFile, err := os.CreateTemp(cfg.tempDir, "reprocess-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	_, err = io.Copy(tempFile, body)
+	if err != nil {
+		return fmt.Errorf("couldn't download %s: %w", oldKey, err)
+	}
+
+	err = cfg.storeVideoFile(ctx, &video, tempFile.Name())
+	if err != nil {
+		return err
+	}
+	// The video points at the new file now, so the old one is only
+	// clutter if it can't be removed.
+	err = cfg.videoStore.Delete(ctx, oldKey)
+	if err != nil {
+		slog.WarnContext(ctx, "Couldn't delete replaced video file", "key", oldKey, "error", err)
+	}
+	fmt.Printf("Reprocessed %s: %s (%d bytes)\n", video.ID, *video.VideoURL, video.SizeBytes)
+	return nil
+}
+
+func runVideoDelete(ctx context.Context, args []string) error {
+	flags := newFlagSet("video delete")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	video, err := cfg.findVideo(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = cfg.deleteVideoBlobs(ctx, video)
+	if err != nil {
+		return fmt.Errorf("couldn't delete stored media: %w", err)
+	}
+	err = cfg.db.WithContext(ctx).DeleteVideo(video.ID)
+	if err != nil {
+		return err
+	}
+	cfg.emitWebhookEvent(ctx, webhookEventVideoDeleted, video)
+	fmt.Printf("Deleted %s (%s)\n", video.ID, video.Title)
+	return nil
+}
+
+// runVideoImport imports a directory in the foreground, printing each file
+// as it's done. Running it again after an interruption or a failure resumes
+// the unfinished files and skips the rest.
+func runVideoImport(ctx context.Context, args []string) error {
+	flags := newFlagSet("video import")
+	owner := flags.String("user", "", "user to import the videos for, by email or ID (required)")
+	cfg, err := setupCommand(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+
+	if *owner == "" {
+		return errors.New("-user is required")
+	}
+	user, err := cfg.findUser(ctx, *owner)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	imp, err := cfg.db.WithContext(ctx).CreateImport(user.ID, dir)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.runImport(ctx, &imp, time.Time{}, func(p importProgress) {
+		switch p.Outcome {
+		case importImported:
+			fmt.Printf("[%d/%d] %s: imported as %s\n", p.Index, p.Total, p.Path, p.VideoID)
+		case importSkipped:
+			fmt.Printf("[%d/%d] %s: already imported as %s\n", p.Index, p.Total, p.Path, p.VideoID)
+		case importFailed:
+			fmt.Printf("[%d/%d] %s: failed: %s\n", p.Index, p.Total, p.Path, p.Err)
+		}
+	})
+	if ctx.Err() != nil {
+		err = errors.New("interrupted; run the import again to resume")
+	}
+	finishErr := cfg.finishImport(ctx, &imp, err)
+	if err != nil {
+		return err
+	}
+	if finishErr != nil {
+		return finishErr
+	}
+	fmt.Printf("Imported %d, skipped %d, failed %d of %d videos for %s\n", imp.Imported, imp.Skipped, imp.Failed, imp.Total, user.Email)
+	if imp.Failed > 0 {
+		return fmt.Errorf("%d videos failed to import; fix them and run the import again", imp.Failed)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) findVideo(ctx context.Context, ref string) (database.Video, error) {
+	id, err := uuid.Parse(ref)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("%q isn't a video ID", ref)
+	}
+	video, err := cfg.db.WithContext(ctx).GetVideo(id)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, fmt.Errorf("no video with ID %s", id)
+	}
+	return video, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+	emailChangeTTL       = 24 * time.Hour
+)
+
+// issueUserToken creates a single-use token for a user and returns the raw
+// token to embed in an email link.
+func (cfg *apiConfig) issueUserToken(ctx context.Context, userID uuid.UUID, purpose database.TokenPurpose, ttl time.Duration) (string, error) {
+	return cfg.issueToken(ctx, database.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+}
+
+// issueToken stores a single-use token described by params, filling in its
+// hash, and returns the raw token.
+func (cfg *apiConfig) issueToken(ctx context.Context, params database.CreateUserTokenParams) (string, error) {
+	token, err := auth.MakeOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+	params.TokenHash = auth.HashToken(token)
+	err = cfg.db.WithContext(ctx).CreateUserToken(params)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// appLink builds a link into the web app carrying a token in its query.
+func (cfg *apiConfig) appLink(param, token string) string {
+	return fmt.Sprintf("%s/app/?%s=%s", cfg.publicURL, param, url.QueryEscape(token))
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.issueUserToken(ctx, user.ID, database.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Welcome to Tubely!\n\nConfirm your email address to start uploading videos:\n\n%s\n\nThis link expires in %s.\n",
+			cfg.appLink("verify_token", token),
+			emailVerificationTTL,
+		),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.issueUserToken(ctx, user.ID, database.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Tubely account.\n\nChoose a new password here:\n\n%s\n\nThis link expires in %s and can only be used once. If you didn't ask for this, you can ignore this email.\n",
+			cfg.appLink("reset_token", token),
+			passwordResetTTL,
+		),
+	})
+}
+
+// sendEmailChangeEmails asks the new address to confirm the change and lets
+// the current address know one was requested.
+func (cfg *apiConfig) sendEmailChangeEmails(ctx context.Context, user database.User, newEmail string) error {
+	token, err := cfg.issueToken(ctx, database.CreateUserTokenParams{
+		UserID:    user.ID,
+		Purpose:   database.TokenPurposeEmailChange,
+		ExpiresAt: time.Now().UTC().Add(emailChangeTTL),
+		NewEmail:  newEmail,
+	})
+	if err != nil {
+		return err
+	}
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Tubely email address",
+		Body: fmt.Sprintf(
+			"Confirm that you want to use this address for your Tubely account:\n\n%s\n\nThis link expires in %s.\n",
+			cfg.appLink("email_change_token", token),
+			emailChangeTTL,
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Tubely email address is being changed",
+		Body: fmt.Sprintf(
+			"Someone asked to change the email address on your Tubely account to %s. It won't change until the new address is confirmed.\n\nIf this wasn't you, reset your password right away.\n",
+			newEmail,
+		),
+	})
+}
+
+func (cfg *apiConfig) sendDataExportEmail(ctx context.Context, user database.User, export database.DataExport) error {
+	expiresAt := dataExportLinkExpiry(export)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Tubely data export is ready",
+		Body: fmt.Sprintf(
+			"The copy of your Tubely data you asked for is ready to download:\n\n%s\n\nThis link works until %s. You can get a new link from your account until the export is deleted on %s.\n",
+			cfg.dataExportDownloadURL(export.ID, expiresAt),
+			expiresAt.UTC().Format(time.RFC1123),
+			export.ExpiresAt.UTC().Format(time.RFC1123),
+		),
+	})
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindDataExport       = "data_export"
+	jobKindDataExportExpire = "data_export_expire"
+
+	// dataExportRetention is how long a finished archive is kept.
+	dataExportRetention = 7 * 24 * time.Hour
+	// dataExportLinkTTL is how long a single download link works. A fresh
+	// one can be fetched for as long as the archive is kept.
+	dataExportLinkTTL = 24 * time.Hour
+)
+
+type dataExportJob struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+// enqueueJob queues a background job and wakes the runner. The job is tagged
+// with the request ID carried by ctx, if any, for its logs.
+func (cfg *apiConfig) enqueueJob(ctx context.Context, kind string, payload any, runAt time.Time, maxAttempts int) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.WithContext(ctx).CreateJob(database.CreateJobParams{
+		Kind:        kind,
+		Payload:     data,
+		MaxAttempts: maxAttempts,
+		RunAt:       runAt,
+		RequestID:   logging.RequestID(ctx),
+		TraceParent: tracing.Inject(ctx),
+	})
+	if err != nil {
+		return err
+	}
+	cfg.jobs.Notify()
+	return nil
+}
+
+func (cfg *apiConfig) registerJobs() {
+	cfg.jobs.Register(jobKindDataExport, tracing.WrapJob(cfg.runDataExport))
+	cfg.jobs.Register(jobKindDataExportExpire, tracing.WrapJob(cfg.runDataExportExpire))
+	cfg.jobs.Register(jobKindVideoImport, tracing.WrapJob(cfg.runVideoImport))
+	cfg.jobs.Register(jobKindWebhookDelivery, tracing.WrapJob(cfg.runWebhookDelivery))
+}
+
+// runDataExport builds a user's export archive, stores it and emails them a
+// download link. The export is marked failed once the job runs out of
+// attempts.
+func (cfg *apiConfig) runDataExport(ctx context.Context, job database.Job) error {
+	params := dataExportJob{}
+	err := json.Unmarshal(job.Payload, &params)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	export, err := cfg.db.WithContext(ctx).GetDataExport(params.ExportID)
+	if err != nil {
+		return err
+	}
+	if export == nil || export.Status != database.DataExportStatusPending {
+		// The account was deleted, or an earlier attempt got this far.
+		return nil
+	}
+
+	err = cfg.buildDataExport(ctx, *export)
+	if err != nil && job.Attempts >= job.MaxAttempts && ctx.Err() == nil {
+		failErr := cfg.db.WithContext(ctx).FailDataExport(export.ID, "Couldn't build the export")
+		if failErr != nil {
+			slog.ErrorContext(ctx, "Couldn't mark export failed", "export_id", export.ID, "error", failErr)
+		}
+	}
+	return err
+}
+
+func (cfg *apiConfig) buildDataExport(ctx context.Context, export database.DataExport) error {
+	user, err := cfg.db.WithContext(ctx).GetUser(export.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	file, err := os.CreateTemp(cfg.tempDir, "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = cfg.writeDataExport(ctx, file, *user)
+	if err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(user.ID.String(), export.ID.String()+".zip")
+	err = cfg.exportStore.Put(ctx, key, file, "application/zip")
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().UTC().Add(dataExportRetention)
+	err = cfg.db.WithContext(ctx).CompleteDataExport(export.ID, key, size, expiresAt)
+	if err != nil {
+		return err
+	}
+	// The account may have been deleted while the archive was being built.
+	current, err := cfg.db.WithContext(ctx).GetDataExport(export.ID)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return cfg.exportStore.Delete(ctx, key)
+	}
+
+	err = cfg.enqueueJob(ctx, jobKindDataExportExpire, dataExportJob{ExportID: export.ID}, expiresAt, 5)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.sendDataExportEmail(ctx, *user, *current)
+	if err != nil {
+		// The archive is ready and listed in the app; a lost email isn't
+		// worth building it again.
+		slog.WarnContext(ctx, "Couldn't send export email", "export_id", export.ID, "error", err)
+	}
+	return nil
+}
+
+// exportUser is the account record as it appears in an export. The password
+// hash and 2FA secret are left out.
+type exportUser struct {
+	ID                  uuid.UUID                `json:"id"`
+	Email               string                   `json:"email"`
+	Role                string                   `json:"role"`
+	CreatedAt           time.Time                `json:"created_at"`
+	UpdatedAt           time.Time                `json:"updated_at"`
+	VerifiedAt          *time.Time               `json:"verified_at"`
+	DisabledAt          *time.Time               `json:"disabled_at"`
+	TwoFactorEnabledAt  *time.Time               `json:"two_factor_enabled_at"`
+	LinkedIdentities    []database.UserIdentity  `json:"linked_identities"`
+	OrganizationMembers []exportOrganizationRole `json:"organizations"`
+}
+
+type exportOrganizationRole struct {
+	ID   uuid.UUID                 `json:"id"`
+	Name string                    `json:"name"`
+	Role database.OrganizationRole `json:"role"`
+}
+
+// exportSession describes a sign-in without the refresh token itself.
+type exportSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type exportVideo struct {
+	database.Video
+	// Files lists the archive paths of the video's media.
+	Files []string `json:"files"`
+}
+
+type exportSharedVideo struct {
+	ID    uuid.UUID                 `json:"id"`
+	Title string                    `json:"title"`
+	Role  database.CollaboratorRole `json:"role"`
+}
+
+// writeDataExport writes the zip archive for a user: JSON files describing
+// their account, sessions and videos, and the media of every video they
+// created.
+func (cfg *apiConfig) writeDataExport(ctx context.Context, w io.Writer, user database.User) error {
+	totp, err := cfg.db.WithContext(ctx).GetUserTOTP(user.ID)
+	if err != nil {
+		return err
+	}
+	identities, err := cfg.db.WithContext(ctx).GetUserIdentities(user.ID)
+	if err != nil {
+		return err
+	}
+	memberships, err := cfg.db.WithContext(ctx).GetUserOrganizations(user.ID)
+	if err != nil {
+		return err
+	}
+	tokens, err := cfg.db.WithContext(ctx).GetUserRefreshTokens(user.ID)
+	if err != nil {
+		return err
+	}
+	videos, err := cfg.db.WithContext(ctx).GetVideosCreatedBy(user.ID)
+	if err != nil {
+		return err
+	}
+	shared, err := cfg.db.WithContext(ctx).GetCollaboratorVideos(user.ID)
+	if err != nil {
+		return err
+	}
+
+	account := exportUser{
+		ID:                  user.ID,
+		Email:               user.Email,
+		Role:                user.Role,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
+		VerifiedAt:          user.VerifiedAt,
+		DisabledAt:          user.DisabledAt,
+		LinkedIdentities:    identities,
+		OrganizationMembers: []exportOrganizationRole{},
+	}
+	if totp != nil {
+		account.TwoFactorEnabledAt = totp.EnabledAt
+	}
+	for _, membership := range memberships {
+		account.OrganizationMembers = append(account.OrganizationMembers, exportOrganizationRole{
+			ID:   membership.ID,
+			Name: membership.Name,
+			Role: membership.Role,
+		})
+	}
+
+	sessions := []exportSession{}
+	for _, token := range tokens {
+		sessions = append(sessions, exportSession{
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+			RevokedAt: token.RevokedAt,
+		})
+	}
+
+	sharedVideos := []exportSharedVideo{}
+	for _, video := range shared {
+		sharedVideos = append(sharedVideos, exportSharedVideo{
+			ID:    video.ID,
+			Title: video.Title,
+			Role:  video.Role,
+		})
+	}
+
+	archive := zip.NewWriter(w)
+	exportVideos := []exportVideo{}
+	for _, video := range videos {
+		files, err := cfg.writeVideoMedia(ctx, archive, video)
+		if err != nil {
+			return err
+		}
+		exportVideos = append(exportVideos, exportVideo{Video: video, Files: files})
+	}
+
+	documents := []struct {
+		name string
+		data any
+	}{
+		{"user.json", account},
+		{"sessions.json", sessions},
+		{"videos.json", exportVideos},
+		{"shared_videos.json", sharedVideos},
+	}
+	now := time.Now()
+	for _, doc := range documents {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: doc.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(doc.data)
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// writeVideoMedia copies a video's thumbnail and media file from storage
+// into the archive and returns the paths it wrote. Media that isn't in one
+// of our stores, or has gone missing from it, is skipped.
+func (cfg *apiConfig) writeVideoMedia(ctx context.Context, archive *zip.Writer, video database.Video) ([]string, error) {
+	type blob struct {
+		store storage.Store
+		key   string
+		name  string
+	}
+	blobs := []blob{}
+	if video.ThumbnailURL != nil {
+		if key, ok := cfg.thumbnailKey(*video.ThumbnailURL); ok {
+			blobs = append(blobs, blob{cfg.thumbnailStore, key, "thumbnail" + path.Ext(key)})
+		}
+	}
+	if video.VideoURL != nil {
+		if key, ok := cfg.videoKey(*video.VideoURL); ok {
+			blobs = append(blobs, blob{cfg.videoStore, key, "video" + path.Ext(key)})
+		}
+	}
+
+	files := []string{}
+	for _, b := range blobs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		body, err := b.store.Get(ctx, b.key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch %s: %w", b.key, err)
+		}
+		name := path.Join("media", video.ID.String(), b.name)
+		// Media is already compressed, so it's stored as is.
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: video.UpdatedAt})
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		_, err = io.Copy(f, body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't copy %s: %w", b.key, err)
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// runDataExportExpire deletes an export's archive once it has been kept for
+// dataExportRetention.
+func (cfg *apiConfig) runDataExportExpire(ctx context.Context, job database.Job) error {
+	params := dataExportJob{}
+	err := json.Unmarshal(job.Payload, &params)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	export, err := cfg.db.WithContext(ctx).GetDataExport(params.ExportID)
+	if err != nil {
+		return err
+	}
+	if export == nil || export.StorageKey == nil {
+		return nil
+	}
+	err = cfg.exportStore.Delete(ctx, *export.StorageKey)
+	if err != nil {
+		return err
+	}
+	return cfg.db.WithContext(ctx).ExpireDataExport(export.ID)
+}
+
+// dataExportDownloadURL returns a link that downloads an export without
+// authentication until expiresAt.
+func (cfg *apiConfig) dataExportDownloadURL(exportID uuid.UUID, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return fmt.Sprintf(
+		"%s/api/exports/%s/download?expires=%s&signature=%s",
+		cfg.publicURL, exportID, expires, cfg.dataExportSignature(exportID, expires),
+	)
+}
+
+func (cfg *apiConfig) dataExportSignature(exportID uuid.UUID, expires string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	fmt.Fprintf(mac, "data_export:%s:%s", exportID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDataExportLink checks a download link's signature and expiry.
+func (cfg *apiConfig) verifyDataExportLink(exportID uuid.UUID, expires, signature string) bool {
+	expected := cfg.dataExportSignature(exportID, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Before(time.Unix(unix, 0))
+}
+
+// dataExportLinkExpiry is when a link issued now should stop working: after
+// dataExportLinkTTL, or when the archive is deleted if that's sooner.
+func dataExportLinkExpiry(export database.DataExport) time.Time {
+	expiresAt := time.Now().Add(dataExportLinkTTL)
+	if export.ExpiresAt != nil && export.ExpiresAt.Before(expiresAt) {
+		return *export.ExpiresAt
+	}
+	return expiresAt
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// buildTestExport requests an export through the API, runs the job that
+// builds it and returns the ready export.
+func buildTestExport(t *testing.T, cfg *apiConfig, srv *httptest.Server, token string) dataExportResponse {
+	t.Helper()
+	resp := sendTestRequest(t, srv, http.MethodPost, "/api/exports", token, nil)
+	var export dataExportResponse
+	decodeTestResponse(t, resp, http.StatusAccepted, &export)
+
+	job, err := cfg.db.ClaimJob([]string{jobKindDataExport}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		t.Fatal("no export job was queued")
+	}
+	err = cfg.runDataExport(context.Background(), *job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/exports/"+export.ID.String(), token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, &export)
+	if export.Status != database.DataExportStatusReady || export.DownloadURL == nil {
+		t.Fatalf("got export %+v, want it ready with a download link", export)
+	}
+	return export
+}
+
+// downloadTestExport fetches a download link and returns the response with
+// its body read.
+func downloadTestExport(t *testing.T, srv *httptest.Server, link string) (*http.Response, []byte) {
+	t.Helper()
+	resp := sendTestRequest(t, srv, http.MethodGet, requestPath(t, link), "", nil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestDataExportLeavesOutSecrets(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	// Signed in before turning on 2FA, so no code is needed.
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	const totpSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	err := cfg.db.SetPendingTOTP(user.ID, totpSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.EnableTOTP(user.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := buildTestExport(t, cfg, srv, session.Token)
+
+	resp, body := downloadTestExport(t, srv, *export.DownloadURL)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("got status %d and type %q, want the archive", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var account map[string]any
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{user.Password, totpSecret, session.RefreshToken} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s has the secret %q", f.Name, secret)
+			}
+		}
+		if f.Name == "user.json" {
+			err = json.Unmarshal(data, &account)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if account == nil {
+		t.Fatal("archive has no user.json")
+	}
+	if account["email"] != "user@example.com" || account["two_factor_enabled_at"] == nil {
+		t.Fatalf("got account %v, want the email and when 2FA was enabled", account)
+	}
+	for _, field := range []string{"password", "hashed_password", "secret", "totp_secret"} {
+		if _, ok := account[field]; ok {
+			t.Errorf("user.json has %s", field)
+		}
+	}
+}
+
+func TestDataExportDownloadLink(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	export := buildTestExport(t, cfg, srv, session.Token)
+
+	link, err := url.Parse(*export.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := link.Query()
+	tamper := func(name, value string) string {
+		u := *link
+		q := u.Query()
+		q.Set(name, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	signature := query.Get("signature")
+	flipped := "0"
+	if strings.HasPrefix(signature, "0") {
+		flipped = "1"
+	}
+
+	tests := []struct {
+		name string
+		link string
+	}{
+		{"expired", cfg.dataExportDownloadURL(export.ID, time.Now().Add(-time.Minute))},
+		{"tampered signature", tamper("signature", flipped+signature[1:])},
+		{"extended expiry", tamper("expires", "9999999999")},
+		{"no signature", tamper("signature", "")},
+	}
+	for _, tt := range tests {
+		resp, _ := downloadTestExport(t, srv, tt.link)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want %d", tt.name, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	resp, _ := downloadTestExport(t, srv, *export.DownloadURL)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d for the untouched link, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestDataExportExpireJobDeletesArchive(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	export := buildTestExport(t, cfg, srv, session.Token)
+	stored, err := cfg.db.GetDataExport(export.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *stored.StorageKey
+
+	payload, err := json.Marshal(dataExportJob{ExportID: export.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.runDataExportExpire(context.Background(), database.Job{Kind: jobKindDataExportExpire, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cfg.exportStore.Get(context.Background(), key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("got error %v fetching the archive, want it deleted", err)
+	}
+	stored, err = cfg.db.GetDataExport(export.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != database.DataExportStatusExpired {
+		t.Fatalf("got status %s, want %s", stored.Status, database.DataExportStatusExpired)
+	}
+	// Links handed out before it expired stop working.
+	resp, _ := downloadTestExport(t, srv, *export.DownloadURL)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d downloading an expired export, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerUserEmailUpdate starts an email change. The account keeps its
+// current address until the link sent to the new one is followed.
+func (cfg *apiConfig) handlerUserEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email        string `json:"email" validate:"required,email,max=254"`
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code" validate:"max=16"`
+		RecoveryCode string `json:"recovery_code" validate:"max=32"`
+	}
+	type response struct {
+		PendingEmail string `json:"pending_email"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	user, _, ok := cfg.reauthenticate(w, r, userID, params.Password, params.Code, params.RecoveryCode)
+	if !ok {
+		return
+	}
+	if strings.EqualFold(params.Email, user.Email) {
+		respondWithError(w, http.StatusBadRequest, "That's already your email address", nil)
+		return
+	}
+	if !cfg.allowRequest(w, r, accountKey("email_change", user.ID.String()), emailChangeRule) {
+		return
+	}
+
+	existing, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+		return
+	}
+	if existing.ID != uuid.Nil {
+		respondWithErrorCode(w, http.StatusConflict, codeEmailTaken, "That email is already in use", nil)
+		return
+	}
+
+	err = cfg.sendEmailChangeEmails(r.Context(), *user, params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send confirmation email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{PendingEmail: params.Email})
+}
+
+func (cfg *apiConfig) handlerUserEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token" validate:"required,max=128"`
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	userID, newEmail, err := cfg.db.WithContext(r.Context()).ConsumeEmailChangeToken(auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check confirmation token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Confirmation link is invalid or has expired", nil)
+		return
+	}
+
+	// The address may have been taken since the change was requested.
+	existing, err := cfg.db.WithContext(r.Context()).GetUserByEmail(newEmail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+		return
+	}
+	if existing.ID != uuid.Nil && existing.ID != userID {
+		respondWithErrorCode(w, http.StatusConflict, codeEmailTaken, "That email is already in use", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).UpdateUserEmail(userID, newEmail)
+	if errors.Is(err, database.ErrEmailTaken) {
+		respondWithErrorCode(w, http.StatusConflict, codeEmailTaken, "That email is already in use", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUserPasswordUpdate changes the caller's password and signs out every
+// session, access tokens included. The caller gets a fresh session in the
+// response.
+func (cfg *apiConfig) handlerUserPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,maxbytes=72"`
+		Code            string `json:"code" validate:"max=16"`
+		RecoveryCode    string `json:"recovery_code" validate:"max=32"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	user, _, ok := cfg.reauthenticate(w, r, userID, params.CurrentPassword, params.Code, params.RecoveryCode)
+	if !ok {
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).UpdateUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).EndUserSessions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't end sessions", err)
+		return
+	}
+
+	// The new session is issued at the token version the old ones were
+	// just revoked with.
+	user, err = cfg.db.WithContext(r.Context()).GetUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	cfg.respondWithSession(w, r, *user)
+}
+
+// handlerUserDelete deletes the caller's account, their personal videos and
+// any organization they are the only member of, including stored media.
+// Owners of shared organizations must hand ownership over first.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code" validate:"max=16"`
+		RecoveryCode string `json:"recovery_code" validate:"max=32"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	user, _, ok := cfg.reauthenticate(w, r, userID, params.Password, params.Code, params.RecoveryCode)
+	if !ok {
+		return
+	}
+
+	soleOrgs, blocking, err := cfg.userOrganizationsForDeletion(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organizations", err)
+		return
+	}
+	if len(blocking) > 0 {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf(
+			"Make someone else an owner of %s before deleting your account",
+			strings.Join(blocking, ", "),
+		), nil)
+		return
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).GetVideos(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	for _, orgID := range soleOrgs {
+		orgVideos, err := cfg.db.WithContext(r.Context()).GetOrganizationVideos(orgID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		videos = append(videos, orgVideos...)
+	}
+
+	// Media goes first, so a storage failure leaves the account in place to
+	// retry rather than leaving orphaned files behind.
+	for _, video := range videos {
+		err = cfg.deleteVideoBlobs(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete stored media", err)
+			return
+		}
+	}
+	exports, err := cfg.db.WithContext(r.Context()).GetUserDataExports(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve exports", err)
+		return
+	}
+	for _, export := range exports {
+		if export.StorageKey == nil {
+			continue
+		}
+		err = cfg.exportStore.Delete(r.Context(), *export.StorageKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete data export", err)
+			return
+		}
+	}
+	for _, orgID := range soleOrgs {
+		err = cfg.db.WithContext(r.Context()).DeleteOrganization(orgID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete organization", err)
+			return
+		}
+	}
+	err = cfg.db.WithContext(r.Context()).DeleteUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userOrganizationsForDeletion sorts a user's organizations into those that
+// would be left empty by deleting the user, and the names of those that
+// would be left without an owner.
+func (cfg *apiConfig) userOrganizationsForDeletion(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, []string, error) {
+	memberships, err := cfg.db.WithContext(ctx).GetUserOrganizations(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	soleOrgs := []uuid.UUID{}
+	blocking := []string{}
+	for _, membership := range memberships {
+		members, err := cfg.db.WithContext(ctx).GetOrganizationMembers(membership.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(members) == 1 {
+			soleOrgs = append(soleOrgs, membership.ID)
+			continue
+		}
+		if membership.Role != database.OrganizationRoleOwner {
+			continue
+		}
+		owners, err := cfg.db.WithContext(ctx).CountOrganizationOwners(membership.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if owners <= 1 {
+			blocking = append(blocking, membership.Name)
+		}
+	}
+	return soleOrgs, blocking, nil
+}
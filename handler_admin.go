@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type adminUser struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	return adminUser{
+		ID:         user.ID,
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		Email:      user.Email,
+		Role:       user.Role,
+		DisabledAt: user.DisabledAt,
+	}
+}
+
+func (cfg *apiConfig) adminTargetUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return nil, false
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	if !authz.CanManageUsers(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+
+	users, err := cfg.db.WithContext(r.Context()).GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	resp := make([]adminUser, len(users))
+	for i, user := range users {
+		resp[i] = newAdminUser(user)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	cfg.setUserDisabled(w, r, true)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	cfg.setUserDisabled(w, r, false)
+}
+
+func (cfg *apiConfig) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	principal := requestPrincipal(r)
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	if !authz.CanDisableUser(principal, *user) {
+		respondWithError(w, http.StatusForbidden, "You can't change this account", nil)
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).SetUserDisabled(user.ID, disabled)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	user, err = cfg.db.WithContext(r.Context()).GetUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
+
+func (cfg *apiConfig) handlerAdminUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role auth.Role `json:"role" validate:"required,oneof=user moderator admin"`
+	}
+
+	principal := requestPrincipal(r)
+	if !authz.CanManageUsers(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	if user.ID == principal.UserID && params.Role != auth.RoleAdmin {
+		respondWithError(w, http.StatusForbidden, "You can't remove your own admin role", nil)
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).SetUserRole(user.ID, string(params.Role))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	user, err = cfg.db.WithContext(r.Context()).GetUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newAdminUser(*user))
+}
+
+func (cfg *apiConfig) handlerAdminVideosList(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	if !authz.CanListAllVideos(principal) {
+		respondWithError(w, http.StatusForbidden, "Moderator role required", nil)
+		return
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).GetAllVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	for i := range videos {
+		videos[i], err = cfg.dbVideoToSignedVideo(r.Context(), videos[i])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) handlerAdminOrganizationQuota(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxVideos       int64 `json:"max_videos" validate:"min=0"`
+		MaxStorageBytes int64 `json:"max_storage_bytes" validate:"min=0"`
+	}
+
+	principal := requestPrincipal(r)
+	if !authz.CanSetOrganizationQuota(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	org, err := cfg.db.WithContext(r.Context()).GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	if org == nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).SetOrganizationQuota(org.ID, params.MaxVideos, params.MaxStorageBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update organization", err)
+		return
+	}
+
+	org, err = cfg.db.WithContext(r.Context()).GetOrganization(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// handlerAdminUserUnlock lifts a login lockout and clears the account's rate
+// limits.
+func (cfg *apiConfig) handlerAdminUserUnlock(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	if !authz.CanManageUsers(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+	user, ok := cfg.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	keys := []string{
+		lockoutKey(user.Email),
+		accountKey("login", user.Email),
+		accountKey("signup", user.Email),
+		accountKey("refresh", user.ID.String()),
+		accountKey("email_change", user.ID.String()),
+	}
+	for _, key := range keys {
+		err := cfg.limiter.Reset(r.Context(), key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unlock account", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
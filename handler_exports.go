@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+type dataExportResponse struct {
+	database.DataExport
+	DownloadURL *string `json:"download_url,omitempty"`
+}
+
+func (cfg *apiConfig) dataExportResponse(export database.DataExport) dataExportResponse {
+	resp := dataExportResponse{DataExport: export}
+	if export.Status == database.DataExportStatusReady {
+		downloadURL := cfg.dataExportDownloadURL(export.ID, dataExportLinkExpiry(export))
+		resp.DownloadURL = &downloadURL
+	}
+	return resp
+}
+
+// handlerDataExportCreate starts building an archive of the caller's data.
+// The caller is emailed when it's ready, and can poll for it meanwhile.
+func (cfg *apiConfig) handlerDataExportCreate(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	exports, err := cfg.db.WithContext(r.Context()).GetUserDataExports(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve exports", err)
+		return
+	}
+	for _, export := range exports {
+		if export.Status == database.DataExportStatusPending {
+			respondWithError(w, http.StatusConflict, "An export is already being prepared", nil)
+			return
+		}
+	}
+	if !cfg.allowRequest(w, r, accountKey("data_export", userID.String()), dataExportRule) {
+		return
+	}
+
+	export, err := cfg.db.WithContext(r.Context()).CreateDataExport(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+	err = cfg.enqueueJob(r.Context(), jobKindDataExport, dataExportJob{ExportID: export.ID}, time.Now(), 3)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue export", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, cfg.dataExportResponse(export))
+}
+
+func (cfg *apiConfig) handlerDataExportsList(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	exports, err := cfg.db.WithContext(r.Context()).GetUserDataExports(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve exports", err)
+		return
+	}
+	resp := []dataExportResponse{}
+	for _, export := range exports {
+		resp = append(resp, cfg.dataExportResponse(export))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerDataExportGet reports an export's progress. Once it's ready the
+// response carries a fresh download link.
+func (cfg *apiConfig) handlerDataExportGet(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	export, err := cfg.db.WithContext(r.Context()).GetDataExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export == nil || export.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.dataExportResponse(*export))
+}
+
+// handlerDataExportDownload streams an export archive. It's authorized by the
+// signed link rather than a bearer token, so it works from an email or a
+// plain browser download.
+func (cfg *apiConfig) handlerDataExportDownload(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	query := r.URL.Query()
+	if !cfg.verifyDataExportLink(exportID, query.Get("expires"), query.Get("signature")) {
+		respondWithError(w, http.StatusForbidden, "Download link is invalid or has expired", nil)
+		return
+	}
+
+	export, err := cfg.db.WithContext(r.Context()).GetDataExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export == nil || export.Status != database.DataExportStatusReady || export.StorageKey == nil {
+		respondWithError(w, http.StatusNotFound, "Export is no longer available", nil)
+		return
+	}
+
+	body, err := cfg.exportStore.Get(r.Context(), *export.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Export is no longer available", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open export", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Length", strconv.FormatInt(export.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		`attachment; filename="tubely-export-%s.zip"`,
+		export.CreatedAt.UTC().Format("2006-01-02"),
+	))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
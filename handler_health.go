@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/health"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// readinessTimeout bounds each readiness check, so a hung dependency fails
+// its check rather than the orchestrator's probe.
+const readinessTimeout = 2 * time.Second
+
+// handlerHealthz reports that the process is up and serving. It checks no
+// dependencies, so a slow database doesn't get the server restarted.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, map[string]health.Status{"status": health.StatusOK})
+}
+
+// handlerReadyz checks everything the server needs to serve requests and
+// reports each check. It responds 503 if any failed.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	report := cfg.readiness.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, status, report)
+}
+
+func (cfg *apiConfig) readinessChecks() *health.Checker {
+	checker := health.NewChecker(readinessTimeout)
+	checker.Add("database", cfg.checkDatabase)
+	checker.Add("assets_dir", health.WritableDir(cfg.assetsRoot))
+	checker.Add("thumbnail_store", checkStore(cfg.thumbnailStore))
+	checker.Add("video_store", checkStore(cfg.videoStore))
+	checker.Add("export_store", checkStore(cfg.exportStore))
+	checker.Add("ffmpeg", health.Command("ffmpeg"))
+	checker.Add("ffprobe", health.Command("ffprobe"))
+	checker.Add("job_worker", cfg.checkJobWorker)
+	return checker
+}
+
+func (cfg *apiConfig) checkDatabase(ctx context.Context) (string, error) {
+	err := cfg.db.Ping(ctx)
+	if err != nil {
+		return "", err
+	}
+	version, err := cfg.db.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	return "sqlite " + version, nil
+}
+
+func checkStore(store storage.Store) health.Check {
+	return func(ctx context.Context) (string, error) {
+		return "", store.Ping(ctx)
+	}
+}
+
+// checkJobWorker fails if the runner has stopped, or if it has an idle
+// worker that hasn't managed to check the queue for a while.
+func (cfg *apiConfig) checkJobWorker(ctx context.Context) (string, error) {
+	status := cfg.jobs.Status()
+	if !status.Running {
+		return "", fmt.Errorf("job runner is not running")
+	}
+	detail := fmt.Sprintf("%d of %d workers busy", status.Busy, status.Workers)
+	if status.Busy >= status.Workers {
+		return detail, nil
+	}
+	staleAfter := 10 * cfg.jobs.PollInterval
+	if status.LastPoll.IsZero() {
+		return detail, fmt.Errorf("job queue hasn't been polled yet")
+	}
+	since := time.Since(status.LastPoll)
+	detail += fmt.Sprintf(", last polled %s ago", since.Round(time.Millisecond))
+	if since > staleAfter {
+		return detail, fmt.Errorf("job queue hasn't been polled for %s", since.Round(time.Second))
+	}
+	return detail, nil
+}
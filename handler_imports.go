@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/google/uuid"
+)
+
+// handlerAdminImportCreate queues a bulk import of a directory under the
+// import root into a user's workspace. The import runs in the background;
+// poll it for progress.
+func (cfg *apiConfig) handlerAdminImportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UserID uuid.UUID `json:"user_id" validate:"required"`
+		Dir    string    `json:"dir" validate:"required,max=1024"`
+	}
+
+	principal := requestPrincipal(r)
+	if !authz.CanImportVideos(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+	if cfg.importRoot == "" {
+		respondWithError(w, http.StatusForbidden, "Imports through the API are off; set IMPORT_ROOT to turn them on", nil)
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	dir := filepath.FromSlash(params.Dir)
+	if !filepath.IsLocal(dir) && dir != "." {
+		respondWithValidationErrors(w, []fieldError{{Field: "dir", Code: "invalid", Message: "must be a relative path within the import root"}})
+		return
+	}
+	dir = filepath.Join(cfg.importRoot, dir)
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		respondWithValidationErrors(w, []fieldError{{Field: "dir", Code: "not_found", Message: "must be a directory within the import root"}})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read import directory", err)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(params.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	imp, err := cfg.db.WithContext(r.Context()).CreateImport(user.ID, dir)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create import", err)
+		return
+	}
+	err = cfg.enqueueJob(r.Context(), jobKindVideoImport, videoImportJob{ImportID: imp.ID}, time.Now(), importJobAttempts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue import", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, imp)
+}
+
+func (cfg *apiConfig) handlerAdminImportsList(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	if !authz.CanImportVideos(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+
+	imports, err := cfg.db.WithContext(r.Context()).GetImports()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve imports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, imports)
+}
+
+func (cfg *apiConfig) handlerAdminImportGet(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	if !authz.CanImportVideos(principal) {
+		respondWithError(w, http.StatusForbidden, "Admin role required", nil)
+		return
+	}
+
+	importID, err := uuid.Parse(r.PathValue("importID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid import ID", err)
+		return
+	}
+	imp, err := cfg.db.WithContext(r.Context()).GetImport(importID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get import", err)
+		return
+	}
+	if imp == nil {
+		respondWithError(w, http.StatusNotFound, "Import not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, imp)
+}
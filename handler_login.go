@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -11,58 +12,77 @@ import (
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		Password string `json:"password" validate:"required"`
+		Email    string `json:"email" validate:"required,max=254"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err := decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+	if !decodeJSON(w, r, &params) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	if !cfg.allowRequest(w, r, accountKey("login", params.Email), loginAccountRule) {
+		return
+	}
+	lockKey := lockoutKey(params.Email)
+	if !cfg.checkLockout(w, r, lockKey) {
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		cfg.recordFailure(r.Context(), lockKey, loginLockout)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
-	)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
+	if user.DisabledAt != nil {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDisabled, "Account is disabled", nil)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	// The lockout is only cleared once the login is complete, so a known
+	// password can't be used to reset failed second-factor attempts.
+	if totp != nil && totp.EnabledAt != nil {
+		challengeToken, err := auth.MakeChallengeJWT(user.ID, cfg.jwtSecret, mfaChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaChallenge{
+			MFARequired:    true,
+			ChallengeToken: challengeToken,
+		})
 		return
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
+	cfg.resetLimit(r.Context(), lockKey)
+	cfg.respondWithSession(w, r, user)
+}
+
+// respondWithSession issues a new access and refresh token pair for a user
+// who has fully authenticated.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r.Context(), user)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
 		return
 	}
 
@@ -72,3 +92,31 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		RefreshToken: refreshToken,
 	})
 }
+
+func (cfg *apiConfig) createSession(ctx context.Context, user database.User) (accessToken, refreshToken string, err error) {
+	accessToken, err = auth.MakeJWT(
+		user.ID,
+		auth.Role(user.Role),
+		user.TokenVersion,
+		cfg.jwtSecret,
+		time.Hour*24*30,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
+	}
+
+	refreshToken, err = auth.MakeRefreshToken()
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
+	}
+
+	_, err = cfg.db.WithContext(ctx).CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:    user.ID,
+		Token:     refreshToken,
+		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
+	}
+	return accessToken, refreshToken, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateCookie = "tubely_oidc_state"
+	oidcLoginTTL    = 10 * time.Minute
+)
+
+var errSSOEmailUnverified = errors.New("identity provider didn't return a verified email")
+
+// handlerOIDCLogin starts an SSO login by sending the browser to the
+// identity provider. The state is bound to the browser with a cookie, and
+// the nonce and PKCE verifier are kept server-side until the callback.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	state, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start sign-in", err)
+		return
+	}
+	nonce, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start sign-in", err)
+		return
+	}
+	codeVerifier, err := oidc.NewRandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start sign-in", err)
+		return
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider", err)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).CreateOIDCLoginState(database.OIDCLoginState{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save sign-in state", err)
+		return
+	}
+
+	http.SetCookie(w, cfg.oidcStateCookie(state, int(oidcLoginTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes an SSO login. The browser is sent back to the
+// app with the session tokens, a 2FA challenge, or an error in the URL
+// fragment, which never reaches server logs.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	http.SetCookie(w, cfg.oidcStateCookie("", -1))
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		slog.InfoContext(r.Context(), "SSO provider returned error", "error", providerErr, "description", query.Get("error_description"))
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in was cancelled or denied"}})
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in session didn't match; please try again"}})
+		return
+	}
+	loginState, err := cfg.db.WithContext(r.Context()).ConsumeOIDCLoginState(auth.HashToken(state))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sign-in state", err)
+		return
+	}
+	if loginState == nil {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Sign-in expired; please try again"}})
+		return
+	}
+
+	tokens, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier)
+	if err != nil {
+		slog.WarnContext(r.Context(), "SSO code exchange failed", "error", err)
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Couldn't complete sign-in with the identity provider"}})
+		return
+	}
+	idToken, err := cfg.oidc.VerifyIDToken(r.Context(), tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		slog.WarnContext(r.Context(), "SSO ID token rejected", "error", err)
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Couldn't complete sign-in with the identity provider"}})
+		return
+	}
+
+	user, err := cfg.ssoUser(r.Context(), idToken)
+	if errors.Is(err, errSSOEmailUnverified) {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Your identity provider account has no verified email"}})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign in", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		cfg.redirectToApp(w, r, url.Values{"sso_error": {"Account is disabled"}})
+		return
+	}
+
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp != nil && totp.EnabledAt != nil {
+		challengeToken, err := auth.MakeChallengeJWT(user.ID, cfg.jwtSecret, mfaChallengeTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+			return
+		}
+		cfg.redirectToApp(w, r, url.Values{"mfa_challenge": {challengeToken}})
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r.Context(), *user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	cfg.redirectToApp(w, r, url.Values{
+		"token":         {accessToken},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// ssoUser finds or creates the Tubely user for an identity provider account.
+// Accounts are linked by subject once known, and otherwise by email, which
+// the provider must have verified.
+func (cfg *apiConfig) ssoUser(ctx context.Context, idToken *oidc.IDToken) (*database.User, error) {
+	identity, err := cfg.db.WithContext(ctx).GetUserIdentity(idToken.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		user, err := cfg.db.WithContext(ctx).GetUser(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("identity %s linked to missing user %s", idToken.Subject, identity.UserID)
+		}
+		return user, nil
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, errSSOEmailUnverified
+	}
+
+	existing, err := cfg.db.WithContext(ctx).GetUserByEmail(idToken.Email)
+	if err != nil {
+		return nil, err
+	}
+	var userID uuid.UUID
+	if existing.ID == uuid.Nil {
+		// SSO-only accounts get a password nobody knows; the owner can set
+		// one through a password reset if they ever need it.
+		userID, err = cfg.createSSOUser(ctx, idToken.Email)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		userID = existing.ID
+		if existing.VerifiedAt == nil {
+			// Nobody has proven they own this email locally, so whoever
+			// registered it may not be the person the provider vouches for.
+			// Their password and sessions must not survive the link.
+			err = cfg.claimUnverifiedUser(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = cfg.db.WithContext(ctx).CreateUserIdentity(database.UserIdentity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		UserID:  userID,
+		Email:   idToken.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfg.db.WithContext(ctx).GetUser(userID)
+}
+
+func (cfg *apiConfig) createSSOUser(ctx context.Context, email string) (uuid.UUID, error) {
+	hashedPassword, err := unusablePasswordHash()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	user, err := cfg.db.WithContext(ctx).CreateUser(database.CreateUserParams{
+		Email:    email,
+		Password: hashedPassword,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	err = cfg.db.WithContext(ctx).MarkUserVerified(user.ID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return user.ID, nil
+}
+
+func (cfg *apiConfig) claimUnverifiedUser(ctx context.Context, userID uuid.UUID) error {
+	hashedPassword, err := unusablePasswordHash()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.WithContext(ctx).UpdateUserPassword(userID, hashedPassword)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.WithContext(ctx).EndUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	return cfg.db.WithContext(ctx).MarkUserVerified(userID)
+}
+
+func unusablePasswordHash() (string, error) {
+	password, err := oidc.NewRandomString()
+	if err != nil {
+		return "", err
+	}
+	return auth.HashPassword(password)
+}
+
+func (cfg *apiConfig) oidcStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		// Lax, not Strict: the callback is a top-level navigation from the
+		// provider's site and must carry the cookie.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (cfg *apiConfig) redirectToApp(w http.ResponseWriter, r *http.Request, fragment url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, cfg.publicURL+"/app/#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+)
+
+// oidcTest is a server with single sign-on through a mock identity provider
+// served from the same origin under /mock-oidc.
+type oidcTest struct {
+	cfg    *apiConfig
+	srv    *httptest.Server
+	client *http.Client
+}
+
+// newOIDCTest starts a server that signs in through a mock provider. If jwks
+// isn't nil, the provider's signing keys are served from it instead.
+func newOIDCTest(t *testing.T, jwks *oidc.MockProvider) *oidcTest {
+	t.Helper()
+	cfg := newTestConfig(t)
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	issuer := srv.URL + "/mock-oidc"
+	mock, err := oidc.NewMockProvider(issuer, "tubely", "mock-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.publicURL = srv.URL
+	cfg.oidc = oidc.NewClient(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  srv.URL + "/api/auth/oidc/callback",
+	})
+	if jwks != nil {
+		mux.Handle("GET /mock-oidc/jwks", http.StripPrefix("/mock-oidc", jwks.Handler()))
+	}
+	mux.Handle("/mock-oidc/", http.StripPrefix("/mock-oidc", mock.Handler()))
+	mux.Handle("/", cfg.routes(nil))
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &oidcTest{cfg: cfg, srv: srv, client: client}
+}
+
+// get fetches rawURL without following redirects, sending cookie if it
+// isn't nil, and returns where the response redirects to.
+func (o *oidcTest) get(t *testing.T, rawURL string, cookie *http.Cookie) (*url.URL, *http.Response) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("GET %s: got status %d, want %d", req.URL.Path, resp.StatusCode, http.StatusFound)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location, resp
+}
+
+// login starts a sign-in, returning the provider URL the browser is sent
+// to and the state cookie it's given.
+func (o *oidcTest) login(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+	authURL, resp := o.get(t, o.srv.URL+"/api/auth/oidc/login", nil)
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return authURL, cookie
+		}
+	}
+	t.Fatal("login didn't set the state cookie")
+	return nil, nil
+}
+
+// authorize signs in to the provider as email, submitting the parameters in
+// authURL, and returns the callback URL the provider redirects to.
+func (o *oidcTest) authorize(t *testing.T, authURL *url.URL, email string) string {
+	t.Helper()
+	form := authURL.Query()
+	form.Set("email", email)
+	form.Set("email_verified", "true")
+	resp, err := o.client.PostForm(o.srv.URL+authURL.Path, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("POST %s: got status %d, want %d", authURL.Path, resp.StatusCode, http.StatusFound)
+	}
+	return resp.Header.Get("Location")
+}
+
+// callback returns the app fragment the callback at callbackURL redirects
+// to.
+func (o *oidcTest) callback(t *testing.T, callbackURL string, cookie *http.Cookie) url.Values {
+	t.Helper()
+	location, _ := o.get(t, callbackURL, cookie)
+	if location.Path != "/app/" {
+		t.Fatalf("callback redirected to %s, want the app", location)
+	}
+	fragment, err := url.ParseQuery(location.EscapedFragment())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func TestOIDCLogin(t *testing.T) {
+	o := newOIDCTest(t, nil)
+
+	authURL, cookie := o.login(t)
+	if !strings.HasPrefix(authURL.String(), o.srv.URL+"/mock-oidc/authorize?") {
+		t.Fatalf("login redirected to %s, want the provider", authURL)
+	}
+	callbackURL := o.authorize(t, authURL, "sso@example.com")
+	fragment := o.callback(t, callbackURL, cookie)
+	if fragment.Get("sso_error") != "" || fragment.Get("token") == "" || fragment.Get("refresh_token") == "" {
+		t.Fatalf("got fragment %v, want a session", fragment)
+	}
+
+	resp := sendTestRequest(t, o.srv, http.MethodGet, "/api/videos", fragment.Get("token"), nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+	user, err := o.cfg.db.GetUserByEmail("sso@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.VerifiedAt == nil {
+		t.Fatal("SSO account wasn't marked verified")
+	}
+
+	// The state is single-use, so the callback can't be replayed.
+	fragment = o.callback(t, callbackURL, cookie)
+	if fragment.Get("token") != "" || fragment.Get("sso_error") == "" {
+		t.Fatalf("replayed callback: got fragment %v, want an error", fragment)
+	}
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	o := newOIDCTest(t, nil)
+	authURL, cookie := o.login(t)
+	callbackURL, err := url.Parse(o.authorize(t, authURL, "sso@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another sign-in's state, as when an attacker sends the victim a
+	// callback URL from their own sign-in.
+	otherAuthURL, _ := o.login(t)
+	forged := *callbackURL
+	query := forged.Query()
+	query.Set("state", otherAuthURL.Query().Get("state"))
+	forged.RawQuery = query.Encode()
+
+	tests := []struct {
+		name        string
+		callbackURL string
+		cookie      *http.Cookie
+	}{
+		{"no cookie", callbackURL.String(), nil},
+		{"other state", forged.String(), cookie},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fragment := o.callback(t, tt.callbackURL, tt.cookie)
+			if fragment.Get("token") != "" || fragment.Get("sso_error") == "" {
+				t.Fatalf("got fragment %v, want an error", fragment)
+			}
+		})
+	}
+	user, err := o.cfg.db.GetUserByEmail("sso@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "" {
+		t.Fatal("a mismatched callback created an account")
+	}
+}
+
+func TestOIDCCallbackPKCEMismatch(t *testing.T) {
+	o := newOIDCTest(t, nil)
+	authURL, cookie := o.login(t)
+
+	// A code issued for another PKCE challenge, as when an attacker injects
+	// a code from their own sign-in, can't be redeemed with this sign-in's
+	// verifier.
+	verifier, err := oidc.NewRandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	query.Set("code_challenge", oidc.CodeChallengeS256(verifier))
+	authURL.RawQuery = query.Encode()
+
+	callbackURL := o.authorize(t, authURL, "sso@example.com")
+	fragment := o.callback(t, callbackURL, cookie)
+	if fragment.Get("token") != "" || fragment.Get("sso_error") == "" {
+		t.Fatalf("got fragment %v, want an error", fragment)
+	}
+	user, err := o.cfg.db.GetUserByEmail("sso@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "" {
+		t.Fatal("a code for another PKCE challenge created an account")
+	}
+}
+
+func TestOIDCCallbackBadSignature(t *testing.T) {
+	// The provider's JWKS publishes another key under the kid the ID token
+	// is signed with, so the token's signature doesn't verify.
+	other, err := oidc.NewMockProvider("http://localhost/other", "tubely", "mock-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newOIDCTest(t, other)
+	authURL, cookie := o.login(t)
+	callbackURL := o.authorize(t, authURL, "sso@example.com")
+	fragment := o.callback(t, callbackURL, cookie)
+	if fragment.Get("token") != "" || fragment.Get("sso_error") == "" {
+		t.Fatalf("got fragment %v, want an error", fragment)
+	}
+	user, err := o.cfg.db.GetUserByEmail("sso@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "" {
+		t.Fatal("an ID token with a bad signature created an account")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// workspaceHeader selects the organization a request acts in. Requests
+// without it (or the equivalent workspace query parameter) act in the
+// caller's personal workspace.
+const workspaceHeader = "X-Workspace-ID"
+
+// requestWorkspace resolves the organization selected by the request. It
+// returns nil for the personal workspace, and writes an error response and
+// returns false if the selection is invalid or the caller isn't a member.
+func (cfg *apiConfig) requestWorkspace(w http.ResponseWriter, r *http.Request, principal authz.Principal) (*database.OrganizationMembership, bool) {
+	selector := r.Header.Get(workspaceHeader)
+	if selector == "" {
+		selector = r.URL.Query().Get("workspace")
+	}
+	if selector == "" {
+		return nil, true
+	}
+
+	orgID, err := uuid.Parse(selector)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID", err)
+		return nil, false
+	}
+	org, err := cfg.db.WithContext(r.Context()).GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return nil, false
+	}
+	member, err := cfg.db.WithContext(r.Context()).GetOrganizationMember(orgID, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return nil, false
+	}
+	if org == nil || member == nil {
+		respondWithError(w, http.StatusNotFound, "Workspace not found", nil)
+		return nil, false
+	}
+
+	return &database.OrganizationMembership{Organization: *org, Role: member.Role}, true
+}
+
+// organizationRole loads the organization in the request path and the
+// caller's role in it, which is empty if they aren't a member.
+func (cfg *apiConfig) organizationRole(w http.ResponseWriter, r *http.Request, principal authz.Principal) (*database.Organization, database.OrganizationRole, bool) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return nil, "", false
+	}
+	org, err := cfg.db.WithContext(r.Context()).GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return nil, "", false
+	}
+	member, err := cfg.db.WithContext(r.Context()).GetOrganizationMember(orgID, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return nil, "", false
+	}
+
+	var role database.OrganizationRole
+	if member != nil {
+		role = member.Role
+	}
+	if org == nil || !authz.CanViewOrganization(principal, role) {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return nil, "", false
+	}
+	return org, role, true
+}
+
+func (cfg *apiConfig) handlerOrganizationsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+
+	org, err := cfg.db.WithContext(r.Context()).CreateOrganization(params.Name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, database.OrganizationMembership{
+		Organization: org,
+		Role:         database.OrganizationRoleOwner,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	orgs, err := cfg.db.WithContext(r.Context()).GetUserOrganizations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (cfg *apiConfig) handlerOrganizationGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Organization
+		Role  database.OrganizationRole  `json:"role"`
+		Usage database.OrganizationUsage `json:"usage"`
+	}
+
+	principal := requestPrincipal(r)
+
+	org, role, ok := cfg.organizationRole(w, r, principal)
+	if !ok {
+		return
+	}
+
+	usage, err := cfg.db.WithContext(r.Context()).GetOrganizationUsage(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Organization: *org,
+		Role:         role,
+		Usage:        usage,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersList(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+
+	org, _, ok := cfg.organizationRole(w, r, principal)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.WithContext(r.Context()).GetOrganizationMembers(org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email" validate:"required,email,max=254"`
+		Role  database.OrganizationRole `json:"role" validate:"required,oneof=member admin owner"`
+	}
+
+	principal := requestPrincipal(r)
+
+	org, role, ok := cfg.organizationRole(w, r, principal)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	if !authz.CanGrantOrganizationRole(principal, role, params.Role) {
+		respondWithError(w, http.StatusForbidden, "You can't grant that role in this organization", nil)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+
+	existing, err := cfg.db.WithContext(r.Context()).GetOrganizationMember(org.ID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return
+	}
+	demotingOwner := existing != nil && existing.Role == database.OrganizationRoleOwner && params.Role != database.OrganizationRoleOwner
+	if demotingOwner && !cfg.canRemoveOwner(w, r, org.ID, principal, role) {
+		return
+	}
+
+	member, err := cfg.db.WithContext(r.Context()).UpsertOrganizationMember(database.CreateOrganizationMemberParams{
+		OrganizationID: org.ID,
+		UserID:         user.ID,
+		Role:           params.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add member", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, member)
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersRemove(w http.ResponseWriter, r *http.Request) {
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	principal := requestPrincipal(r)
+
+	org, role, ok := cfg.organizationRole(w, r, principal)
+	if !ok {
+		return
+	}
+	// Members can always leave an organization on their own.
+	if memberID != principal.UserID && !authz.CanManageOrganizationMembers(principal, role) {
+		respondWithError(w, http.StatusForbidden, "You can't manage members of this organization", nil)
+		return
+	}
+
+	member, err := cfg.db.WithContext(r.Context()).GetOrganizationMember(org.ID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+		return
+	}
+	if member == nil {
+		respondWithError(w, http.StatusNotFound, "Member not found", nil)
+		return
+	}
+	if member.Role == database.OrganizationRoleOwner && !cfg.canRemoveOwner(w, r, org.ID, principal, role) {
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).DeleteOrganizationMember(org.ID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canRemoveOwner checks that the caller may take away another member's owner
+// role, and that the organization won't be left without an owner.
+func (cfg *apiConfig) canRemoveOwner(w http.ResponseWriter, r *http.Request, orgID uuid.UUID, principal authz.Principal, role database.OrganizationRole) bool {
+	if !authz.CanGrantOrganizationRole(principal, role, database.OrganizationRoleOwner) {
+		respondWithError(w, http.StatusForbidden, "Only owners can change another owner's role", nil)
+		return false
+	}
+	owners, err := cfg.db.WithContext(r.Context()).CountOrganizationOwners(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count owners", err)
+		return false
+	}
+	if owners <= 1 {
+		respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+		return false
+	}
+	return true
+}
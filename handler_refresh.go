@@ -18,14 +18,28 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	user, err := cfg.db.WithContext(r.Context()).GetUserByRefreshToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
 
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDisabled, "Account is disabled", nil)
+		return
+	}
+	if !cfg.allowRequest(w, r, accountKey("refresh", user.ID.String()), refreshAccountRule) {
+		return
+	}
+
 	accessToken, err := auth.MakeJWT(
 		user.ID,
+		auth.Role(user.Role),
+		user.TokenVersion,
 		cfg.jwtSecret,
 		time.Hour,
 	)
@@ -46,7 +60,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	err = cfg.db.WithContext(r.Context()).RevokeRefreshToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
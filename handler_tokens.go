@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// scopedTokenTTL is how long a scoped access token lasts. Scoped tokens
+// aren't tied to a session, so they can't be refreshed.
+const scopedTokenTTL = 30 * 24 * time.Hour
+
+type scopedTokenResponse struct {
+	Token     string       `json:"token"`
+	Scopes    []auth.Scope `json:"scopes"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// handlerScopedTokenCreate issues an access token limited to the requested
+// scopes, for scripts and integrations that shouldn't hold a full session.
+// A token can only be narrowed, so the caller's own token must carry every
+// scope asked for.
+func (cfg *apiConfig) handlerScopedTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Scopes []auth.Scope `json:"scopes" validate:"required,max=10"`
+	}
+
+	principal := requestPrincipal(r)
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	scopes, errs := normalizeScopes(params.Scopes)
+	if len(errs) > 0 {
+		respondWithValidationErrors(w, errs)
+		return
+	}
+	for _, scope := range scopes {
+		if !principal.HasScope(scope) {
+			respondWithErrorCode(w, http.StatusForbidden, codeInsufficientScope, "Token is missing the "+string(scope)+" scope", nil)
+			return
+		}
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+		return
+	}
+	expiresAt := time.Now().UTC().Add(scopedTokenTTL)
+	token, err := auth.MakeScopedJWT(user.ID, auth.Role(user.Role), user.TokenVersion, scopes, cfg.jwtSecret, scopedTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, scopedTokenResponse{Token: token, Scopes: scopes, ExpiresAt: expiresAt})
+}
+
+// normalizeScopes checks that each scope is known and drops duplicates,
+// keeping the order they were given in.
+func normalizeScopes(scopes []auth.Scope) ([]auth.Scope, []fieldError) {
+	names := make([]string, len(auth.Scopes))
+	for i, scope := range auth.Scopes {
+		names[i] = string(scope)
+	}
+
+	normalized := make([]auth.Scope, 0, len(scopes))
+	var errs []fieldError
+	for i, scope := range scopes {
+		switch {
+		case !scope.Valid():
+			errs = append(errs, fieldError{
+				Field:   fmt.Sprintf("scopes[%d]", i),
+				Code:    "invalid_choice",
+				Message: "must be one of " + strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1],
+			})
+		case !slices.Contains(normalized, scope):
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, errs
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestScopedToken(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	resp := sendTestRequest(t, srv, http.MethodPost, "/api/tokens", session.Token, map[string]any{
+		"scopes": []string{"videos:read", "videos:read"},
+	})
+	var token scopedTokenResponse
+	decodeTestResponse(t, resp, http.StatusCreated, &token)
+	if token.Token == "" || len(token.Scopes) != 1 {
+		t.Fatalf("got %+v, want a token with the videos:read scope", token)
+	}
+
+	resp = sendTestRequest(t, srv, http.MethodGet, "/api/videos", token.Token, nil)
+	decodeTestResponse(t, resp, http.StatusOK, nil)
+
+	// Routes needing a scope the token doesn't carry are refused, even
+	// though the user could use them with a full token.
+	tests := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPost, "/api/videos", map[string]any{"title": "Nope"}},
+		{http.MethodGet, "/api/webhooks", nil},
+		{http.MethodPost, "/api/tokens", map[string]any{"scopes": []string{"videos:write"}}},
+	}
+	for _, tt := range tests {
+		resp = sendTestRequest(t, srv, tt.method, tt.path, token.Token, tt.body)
+		if code := problemCode(t, resp, http.StatusForbidden); code != codeInsufficientScope {
+			t.Errorf("%s %s: got code %q, want %q", tt.method, tt.path, code, codeInsufficientScope)
+		}
+	}
+	resp = sendTestRequest(t, srv, http.MethodPost, "/api/videos", session.Token, map[string]any{"title": "Yes"})
+	decodeTestResponse(t, resp, http.StatusCreated, nil)
+}
+
+func TestScopedTokenRejectsUnknownScopes(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	for _, scopes := range [][]string{{"videos:delete"}, {}} {
+		resp := sendTestRequest(t, srv, http.MethodPost, "/api/tokens", session.Token, map[string]any{"scopes": scopes})
+		decodeTestResponse(t, resp, http.StatusUnprocessableEntity, nil)
+	}
+}
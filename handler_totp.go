@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer        = "Tubely"
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// mfaChallenge is returned by the login endpoint instead of a session when
+// the account has two-factor authentication enabled.
+type mfaChallenge struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Accepted TOTP time steps and recovery codes are used up so neither can be
+// replayed.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, totp database.UserTOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		counter, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return cfg.db.WithContext(ctx).UseTOTPCounter(totp.UserID, counter)
+	}
+	if recoveryCode != "" {
+		hash := auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode))
+		return cfg.db.WithContext(ctx).ConsumeRecoveryCode(totp.UserID, hash)
+	}
+	return false, nil
+}
+
+// newRecoveryCodes generates a fresh set of recovery codes, returning the
+// codes to show the user once and the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// reauthenticate confirms the caller still knows their password and, if 2FA
+// is enabled, holds a second factor, before a sensitive account change.
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, r *http.Request, userID uuid.UUID, password, code, recoveryCode string) (*database.User, *database.UserTOTP, bool) {
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, nil, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Account no longer exists", nil)
+		return nil, nil, false
+	}
+
+	lockKey := lockoutKey(user.Email)
+	if !cfg.checkLockout(w, r, lockKey) {
+		return nil, nil, false
+	}
+	err = auth.CheckPasswordHash(password, user.Password)
+	if err != nil {
+		cfg.recordFailure(r.Context(), lockKey, loginLockout)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidCredentials, "Incorrect password", err)
+		return nil, nil, false
+	}
+
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return nil, nil, false
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		return user, totp, true
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), *totp, code, recoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return nil, nil, false
+	}
+	if !ok {
+		cfg.recordFailure(r.Context(), lockKey, loginLockout)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidMFACode, "Invalid authentication code", nil)
+		return nil, nil, false
+	}
+	return user, totp, true
+}
+
+func (cfg *apiConfig) handlerLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"max=16"`
+		RecoveryCode   string `json:"recovery_code" validate:"max=32"`
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	userID, err := auth.ValidateChallengeJWT(params.ChallengeToken, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", err)
+		return
+	}
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithErrorCode(w, http.StatusForbidden, codeAccountDisabled, "Account is disabled", nil)
+		return
+	}
+
+	lockKey := lockoutKey(user.Email)
+	if !cfg.checkLockout(w, r, lockKey) {
+		return
+	}
+
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		// 2FA was turned off after the challenge was issued.
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", nil)
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), *totp, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return
+	}
+	if !ok {
+		cfg.recordFailure(r.Context(), lockKey, loginLockout)
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidMFACode, "Invalid authentication code", nil)
+		return
+	}
+
+	cfg.resetLimit(r.Context(), lockKey)
+	cfg.respondWithSession(w, r, *user)
+}
+
+func (cfg *apiConfig) handlerTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Enabled                bool       `json:"enabled"`
+		EnabledAt              *time.Time `json:"enabled_at"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		respondWithJSON(w, http.StatusOK, response{})
+		return
+	}
+
+	remaining, err := cfg.db.WithContext(r.Context()).CountRecoveryCodes(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count recovery codes", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Enabled:                true,
+		EnabledAt:              totp.EnabledAt,
+		RecoveryCodesRemaining: remaining,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+		QRCodePNG  []byte `json:"qr_code_png"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Account no longer exists", nil)
+		return
+	}
+	existing, err := cfg.db.WithContext(r.Context()).GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if existing != nil && existing.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret", err)
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).SetPendingTOTP(userID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	uri := auth.TOTPURI(totpIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't render QR code", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRCodePNG:  png,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code" validate:"required,max=16"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	totp, err := cfg.db.WithContext(r.Context()).GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp == nil {
+		respondWithError(w, http.StatusNotFound, "Start enrollment first", nil)
+		return
+	}
+	if totp.EnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), *totp, params.Code, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return
+	}
+	if !ok {
+		respondWithErrorCode(w, http.StatusUnauthorized, codeInvalidMFACode, "Invalid authentication code", nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).EnableTOTP(userID, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerTOTPRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code" validate:"max=16"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	_, totp, ok := cfg.reauthenticate(w, r, userID, params.Password, params.Code, "")
+	if !ok {
+		return
+	}
+	if totp == nil || totp.EnabledAt == nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code" validate:"max=16"`
+		RecoveryCode string `json:"recovery_code" validate:"max=32"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	_, totp, ok := cfg.reauthenticate(w, r, userID, params.Password, params.Code, params.RecoveryCode)
+	if !ok {
+		return
+	}
+	if totp == nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is not enabled", nil)
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).DisableTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestVerifySecondFactorRejectsReusedCode(t *testing.T) {
+	cfg := newTestConfig(t)
+	ctx := context.Background()
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetPendingTOTP(user.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.EnableTOTP(user.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code := auth.TOTP(key, now, auth.TOTPPeriod, auth.TOTPDigits, sha1.New)
+	earlier := auth.TOTP(key, now.Add(-auth.TOTPPeriod), auth.TOTPPeriod, auth.TOTPDigits, sha1.New)
+
+	ok, err := cfg.verifySecondFactor(ctx, *totp, code, "")
+	if err != nil || !ok {
+		t.Fatalf("current code: got %v, %v", ok, err)
+	}
+	ok, err = cfg.verifySecondFactor(ctx, *totp, code, "")
+	if err != nil || ok {
+		t.Fatalf("reused code: got %v, %v", ok, err)
+	}
+	// A code from before the one accepted is still in the window, but
+	// can't be used either.
+	ok, err = cfg.verifySecondFactor(ctx, *totp, earlier, "")
+	if err != nil || ok {
+		t.Fatalf("earlier code: got %v, %v", ok, err)
+	}
+}
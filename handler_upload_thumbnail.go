@@ -1,17 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -23,19 +24,12 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	principal := requestPrincipal(r)
+	if !cfg.requireVerifiedUser(w, r, principal.UserID) {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	slog.InfoContext(r.Context(), "uploading thumbnail", "video_id", videoID)
 
 	const maxMemory = 10 << 20
 
@@ -43,7 +37,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 
 	file, header, err := r.FormFile("thumbnail")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		respondWithFormFileError(w, err)
 		return
 	}
 	defer file.Close()
@@ -54,54 +48,72 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Invalid media type", nil)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Thumbnail must be a JPEG or PNG image", nil)
 		return
 	}
-	fileExtension := strings.Split(mediaType, "/")[1]
-
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanEditVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
 		return
 	}
 
-	randomBytes := make([]byte, 16)
-	_, err = rand.Read(randomBytes)
+	err = cfg.storeThumbnail(r.Context(), &video, file, mediaType, "http://"+r.Host)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to generate random bytes", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to store thumbnail", err)
 		return
 	}
 
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
-	path := filepath.Join(cfg.assetsRoot, fmt.Sprintf("%s.%s", randomString, fileExtension))
+	cfg.metrics.ObserveUpload("thumbnail", mediaType, header.Size)
 
-	thumbnailFile, err := os.Create(path)
+	// Respond with updated JSON of the video's metadata. Use the provided respondWithJSON function and pass it the updated database.Video struct to marshal.
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create thumbnail file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
 		return
 	}
-	defer thumbnailFile.Close()
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
 
-	fmt.Println("saving thumbnail to", path)
+// storeThumbnail puts a JPEG or PNG image in the thumbnail store and points
+// the video at it, served from origin.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, video *database.Video, file io.Reader, mediaType, origin string) error {
+	fileExtension := strings.Split(mediaType, "/")[1]
 
-	_, err = io.Copy(thumbnailFile, file)
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to write thumbnail file", err)
-		return
+		return err
 	}
 
-	path = fmt.Sprintf("http://%s/%s", r.Host, path)
-	video.ThumbnailURL = &path
-	err = cfg.db.UpdateVideo(video)
+	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
+	key := workspaceKey(video.OrganizationID, fmt.Sprintf("%s.%s", randomString, fileExtension))
+
+	slog.InfoContext(ctx, "saving thumbnail", "key", key)
+
+	err = cfg.thumbnailStore.Put(ctx, key, file, mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to update video", err)
-		return
+		return fmt.Errorf("couldn't write thumbnail file: %w", err)
 	}
 
-	// Respond with updated JSON of the video's metadata. Use the provided respondWithJSON function and pass it the updated database.Video struct to marshal.
-	respondWithJSON(w, http.StatusOK, video)
+	path := cfg.thumbnailURL(origin, key)
+	video.ThumbnailURL = &path
+	err = cfg.db.WithContext(ctx).UpdateVideo(video)
+	if err != nil {
+		return err
+	}
+	cfg.emitWebhookEvent(ctx, webhookEventThumbnailUpdated, *video)
+	return nil
 }
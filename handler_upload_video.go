@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
 	"github.com/google/uuid"
 )
 
@@ -19,7 +26,7 @@ import (
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Set an upload limit of 1 GB (1 << 30 bytes) using http.MaxBytesReader.
 	const maxMemory = 1 << 30
-	http.MaxBytesReader(w, r.Body, maxMemory)
+	r.Body = http.MaxBytesReader(w, r.Body, maxMemory)
 
 	// Extract the videoID from the URL path parameters and parse it as a UUID
 	videoIDString := r.PathValue("videoID")
@@ -29,36 +36,46 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Authenticate the user to get a userID
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	principal := requestPrincipal(r)
+	if !cfg.requireVerifiedUser(w, r, principal.UserID) {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	// Get the video metadata from the database, if the user is not allowed to edit it, return a http.StatusForbidden response
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-
-	// Get the video metadata from the database, if the user is not the video owner, return a http.StatusUnauthorized response
-	video, err := cfg.db.GetVideo(videoID)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+	if !authz.CanEditVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
 		return
 	}
 
+	// Count the body as it arrives so subscribers can follow the upload.
+	r.Body = io.NopCloser(&uploadProgressReader{
+		r:       r.Body,
+		broker:  cfg.progress,
+		videoID: video.ID,
+		total:   max(r.ContentLength, 0),
+	})
+
 	// Parse the uploaded video file from the form data
 
 	// Use (http.Request).FormFile with the key "video" to get a multipart.File in memory
 	videoFile, header, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		cfg.publishFailure(video.ID, errUploadFailed)
+		respondWithFormFileError(w, err)
 		return
 	}
 	// Remember to defer closing the file with (os.File).Close - we don't want any memory leaks
@@ -68,19 +85,22 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	// - Use mime.ParseMediaType and "video/mp4" as the MIME type
 	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
 	if err != nil {
+		cfg.publishFailure(video.ID, errUploadFailed)
 		respondWithError(w, http.StatusBadRequest, "Unable to parse media type", err)
 		return
 	}
 	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid media type", nil)
+		cfg.publishFailure(video.ID, errUploadFailed)
+		respondWithError(w, http.StatusUnsupportedMediaType, "Video must be an MP4 file", nil)
 		return
 	}
 
 	// Save the uploaded file to a temporary file on disk.
 
-	// Use os.CreateTemp to create a temporary file. I passed in an empty string for the directory to use the system default, and the name "tubely-upload.mp4" (but you can use whatever you want)
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	// Use os.CreateTemp to create a temporary file in the server's temp directory, which is removed on shutdown
+	tempFile, err := os.CreateTemp(cfg.tempDir, "upload-*.mp4")
 	if err != nil {
+		cfg.publishFailure(video.ID, err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to create temp file", err)
 		return
 	}
@@ -92,6 +112,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	// io.Copy the contents over from the wire to the temp file
 	_, err = io.Copy(tempFile, videoFile)
 	if err != nil {
+		cfg.publishFailure(video.ID, errUploadFailed)
 		respondWithError(w, http.StatusInternalServerError, "Unable to save video file", err)
 		return
 	}
@@ -99,39 +120,84 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	// Reset the tempFile's file pointer to the beginning with .Seek(0, io.SeekStart) - this will allow us to read the file again from the beginning
 	_, err = tempFile.Seek(0, io.SeekStart)
 	if err != nil {
+		cfg.publishFailure(video.ID, err)
 		respondWithError(w, http.StatusInternalServerError, "Unable to seek temp file", err)
 		return
 	}
 
-	// The file key. Use the same <random-32-byte-hex>.ext format as the key. e.g. 1a2b3c4d5e6f7890abcd1234ef567890.mp4
-	randomHex, err := uuid.NewRandom()
+	cfg.emitWebhookEvent(r.Context(), webhookEventVideoUploaded, video)
+	err = cfg.storeVideoFile(r.Context(), &video, tempFile.Name())
+	if errors.Is(err, errQuotaExceeded) {
+		respondWithErrorCode(w, http.StatusRequestEntityTooLarge, codeQuotaExceeded, "Organization storage quota exceeded", nil)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to generate random hex", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to store video", err)
 		return
 	}
+	cfg.metrics.ObserveUpload("video", mediaType, header.Size)
 
-	// Update handlerUploadVideo to create a processed version of the video. Upload the processed video to S3, and discard the original.
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to process video for fast start", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// errQuotaExceeded is returned by storeVideoFile when the video's
+// organization has no room left for it.
+var errQuotaExceeded = errors.New("organization storage quota exceeded")
+
+// storeVideoFile runs an MP4 file through the processing pipeline, puts the
+// result in the video store and points the video at it. The file itself is
+// left in place for the caller to remove. Uploads, reprocessing and imports
+// all go through here, and each publishes its progress as it goes. The
+// outcome is also sent to webhooks as video.ready or video.failed.
+func (cfg *apiConfig) storeVideoFile(ctx context.Context, video *database.Video, filePath string) (err error) {
+	defer func() {
+		if err != nil {
+			cfg.publishFailure(video.ID, err)
+			cfg.emitWebhookEvent(ctx, webhookEventVideoFailed, *video)
+			return
+		}
+		event := progress.Event{VideoID: video.ID, Stage: progress.StageReady}
+		signedVideo, signErr := cfg.dbVideoToSignedVideo(ctx, *video)
+		if signErr != nil {
+			slog.WarnContext(ctx, "Couldn't sign video link for progress", "video_id", video.ID, "error", signErr)
+		} else {
+			event.VideoURL = *signedVideo.VideoURL
+		}
+		cfg.progress.Publish(event)
+		cfg.emitWebhookEvent(ctx, webhookEventVideoReady, *video)
+	}()
+
+	// The file key. Use the same <random-32-byte-hex>.ext format as the key. e.g. 1a2b3c4d5e6f7890abcd1234ef567890.mp4
+	randomHex, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("couldn't generate key: %w", err)
+	}
+
+	// Create a processed version of the video. Upload the processed video to S3, and discard the original.
+	cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageProcessing, Percent: new(float64)})
+	processedFilePath, err := cfg.processVideoForFastStart(ctx, filePath, func(percent float64) {
+		cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageProcessing, Percent: &percent})
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't process video for fast start: %w", err)
+	}
 	defer os.Remove(processedFilePath)
 
-	// Open the processed file
 	processedFile, err := os.Open(processedFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to open processed file", err)
-		return
+		return err
 	}
 	defer processedFile.Close()
 
-	// Update the handlerUploadVideo to get the aspect ratio of the video file from the temporary file once it's saved to disk.
 	// Depending on the aspect ratio, add a "landscape", "portrait", or "other" prefix to the key before uploading it to S3.
-	aspectRatio, err := getVideoAspectRatio(processedFile.Name())
+	aspectRatio, err := cfg.getVideoAspectRatio(ctx, processedFile.Name())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to get video aspect ratio", err)
-		return
+		return fmt.Errorf("couldn't get video aspect ratio: %w", err)
 	}
 	prefix := "other"
 	switch aspectRatio {
@@ -141,87 +207,58 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		prefix = "portrait"
 	}
 
-	// fileKey := randomHex.String() + ".mp4" // without prefix
-	fileKey := prefix + "/" + randomHex.String() + ".mp4" // with prefix
+	// Organization videos are namespaced under orgs/<id>/ in the bucket.
+	fileKey := workspaceKey(video.OrganizationID, prefix+"/"+randomHex.String()+".mp4")
 
-	// Put the object into S3 using PutObject
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &fileKey,
-		Body:        processedFile,
-		ContentType: &mediaType,
-	})
+	processedInfo, err := processedFile.Stat()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to upload video to S3", err)
-		return
+		return err
+	}
+	if video.OrganizationID != nil {
+		ok, err := cfg.withinStorageQuota(ctx, *video.OrganizationID, processedInfo.Size()-video.SizeBytes)
+		if err != nil {
+			return fmt.Errorf("couldn't check storage quota: %w", err)
+		}
+		if !ok {
+			return errQuotaExceeded
+		}
 	}
 
-	// Store an actual URL again in the video_url column, but this time, use the cloudfront URL. Use your distribution's domain name, and then dynamically inject the S3 object's key.
-	// Set the distribution's domain name in your .env and grab it from the apiConfig's s3CfDistribution field.
-	videoURL := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, fileKey)
-	video.VideoURL = &videoURL
-	err = cfg.db.UpdateVideo(video)
+	// Put the object into the video store (S3)
+	cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageStoring})
+	err = cfg.videoStore.Put(ctx, fileKey, processedFile, "video/mp4")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to update video", err)
-		return
+		return fmt.Errorf("couldn't store video: %w", err)
 	}
 
-	// signedVideo, err := cfg.dbVideoToSignedVideo(video)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Unable to sign video", err)
-	// 	return
-	// }
-
-	respondWithJSON(w, http.StatusOK, video)
+	// Store the CloudFront URL (or the assets URL when videos are stored locally) in the video_url column.
+	videoURL := cfg.videoURL(fileKey)
+	video.VideoURL = &videoURL
+	video.SizeBytes = processedInfo.Size()
+	return cfg.db.WithContext(ctx).UpdateVideo(video)
 }
 
-// func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-// 	// Split the video.VideoURL on the comma to get the bucket and key:
-// 	bucketAndKey := strings.Split(*video.VideoURL, ",")
-
-// 	// Use generatePresignedURL to get a presigned URL for the video:
-// 	duration := 10 * time.Minute
-// 	presignedURL, err := generatePresignedURL(cfg.s3Client, bucketAndKey[0], bucketAndKey[1], duration)
-// 	if err != nil {
-// 		return database.Video{}, err
-// 	}
-
-// 	// Set the VideoURL field of the video to the presigned URL and return the updated video:
-// 	video.VideoURL = &presignedURL
-
-// 	// Return a database.Video with the VideoURL field set to a presigned URL and an error (to be returned from the handler):
-// 	return video, nil
-// }
-
-// func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-// 	// Use the SDK to create a s3.PresignClient with s3.NewPresignClient:
-// 	presignClient := s3.NewPresignClient(s3Client)
-
-// 	// Use the client's .PresignGetObject() method with s3.WithPresignExpires as a functional option:
-// 	presignedReq, err := presignClient.PresignGetObject(context.Background(), &s3.GetObjectInput{
-// 		Bucket: &bucket,
-// 		Key:    &key,
-// 	}, func(options *s3.PresignOptions) {
-// 		options.Expires = expireTime
-// 	})
-// 	if err != nil {
-// 		return "", err
-// 	}
-
-// 	// Return the .URL field of the v4.PresignedHTTPRequest created by .PresignGetObject()
-// 	return presignedReq.URL, nil
-// }
+// withinStorageQuota reports whether an organization can store delta more
+// bytes without exceeding its storage quota.
+func (cfg *apiConfig) withinStorageQuota(ctx context.Context, orgID uuid.UUID, delta int64) (bool, error) {
+	org, err := cfg.db.WithContext(ctx).GetOrganization(orgID)
+	if err != nil {
+		return false, err
+	}
+	if org == nil || org.MaxStorageBytes == 0 {
+		return true, nil
+	}
+	usage, err := cfg.db.WithContext(ctx).GetOrganizationUsage(orgID)
+	if err != nil {
+		return false, err
+	}
+	return usage.StorageBytes+delta <= org.MaxStorageBytes, nil
+}
 
 // Create a function getVideoAspectRatio(filePath string) (string, error) that takes a file path and returns the aspect ratio as a string.
-func getVideoAspectRatio(filePath string) (string, error) {
+func (cfg *apiConfig) getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
 	// It should use exec.Command to run the same ffprobe command as above. In this case, the command is ffprobe and the arguments are -v, error, -print_format, json, -show_streams, and the file path:
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-
-	// Set the resulting exec.Cmd's Stdout field to a pointer to a new bytes.Buffer:
-	cmd.Stdout = &bytes.Buffer{}
-
-	// .Run() the command:
-	err := cmd.Run()
+	stdout, err := cfg.runMediaCommand(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
 	if err != nil {
 		return "", err
 	}
@@ -233,7 +270,7 @@ func getVideoAspectRatio(filePath string) (string, error) {
 			Height int `json:"height"`
 		} `json:"streams"`
 	}{}
-	err = json.Unmarshal(cmd.Stdout.(*bytes.Buffer).Bytes(), &streams)
+	err = json.Unmarshal(stdout, &streams)
 	if err != nil {
 		return "", err
 	}
@@ -258,20 +295,115 @@ func getVideoAspectRatio(filePath string) (string, error) {
 }
 
 // Create a new function called processVideoForFastStart(filePath string) (string, error) that takes a file path as input and creates and returns a new path to a file with "fast start" encoding.
-func processVideoForFastStart(filePath string) (string, error) {
+// onProgress, if not nil, is called with how far through the file ffmpeg is.
+func (cfg *apiConfig) processVideoForFastStart(ctx context.Context, filePath string, onProgress func(percent float64)) (string, error) {
 	// Create a new string for the output file path. I just appended .processing to the input file (which should be the path to the temp file on disk)
 	outputPath := filePath + ".processing"
 
 	// Create a new exec.Cmd using exec.Command
 	// The command is ffmpeg and the arguments are -i, the input file path, -c, copy, -movflags, faststart, -f, mp4 and the output file path.
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-
-	// Run the command with .Run()
-	err := cmd.Run()
+	err := cfg.runFFmpeg(ctx, filePath, onProgress, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
 	if err != nil {
+		// ffmpeg may have written part of the output before failing or
+		// being killed.
+		os.Remove(outputPath)
 		return "", err
 	}
 
 	// Return the output file path
 	return outputPath, nil
 }
+
+// runFFmpeg runs ffmpeg on inputPath with the given output options. If
+// onProgress isn't nil, ffmpeg reports its progress on stdout and
+// onProgress is called with how far through the input it is, as a
+// percentage, whenever that moves on. Transcodes should all go through
+// here so their progress can be followed.
+func (cfg *apiConfig) runFFmpeg(ctx context.Context, inputPath string, onProgress func(percent float64), args ...string) error {
+	if onProgress == nil {
+		_, err := cfg.runMediaCommand(ctx, "ffmpeg", append([]string{"-i", inputPath}, args...)...)
+		return err
+	}
+
+	// Without the input's duration there's nothing to measure progress
+	// against, but the transcode can still go ahead.
+	duration, err := cfg.getVideoDuration(ctx, inputPath)
+	if err != nil {
+		slog.WarnContext(ctx, "Couldn't get video duration; progress won't be reported", "error", err)
+	}
+	progress := newFFmpegProgressWriter(duration, onProgress)
+	args = append([]string{"-i", inputPath, "-progress", "pipe:1", "-nostats"}, args...)
+	_, err = cfg.streamMediaCommand(ctx, progress, "ffmpeg", args...)
+	return err
+}
+
+// getVideoDuration returns how long a video plays for, or zero if ffprobe
+// doesn't know.
+func (cfg *apiConfig) getVideoDuration(ctx context.Context, filePath string) (time.Duration, error) {
+	stdout, err := cfg.runMediaCommand(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", filePath)
+	if err != nil {
+		return 0, err
+	}
+	probe := struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+	err = json.Unmarshal(stdout, &probe)
+	if err != nil {
+		return 0, err
+	}
+	if probe.Format.Duration == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", probe.Format.Duration, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// runMediaCommand runs ffmpeg or ffprobe and returns what it wrote to
+// stdout. Each run is logged, measured and traced; a failed run also logs
+// the tail of stderr, which is where ffmpeg explains what went wrong.
+func (cfg *apiConfig) runMediaCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return cfg.streamMediaCommand(ctx, nil, name, args...)
+}
+
+// streamMediaCommand is runMediaCommand, also copying stdout to w as it's
+// written if w isn't nil.
+func (cfg *apiConfig) streamMediaCommand(ctx context.Context, w io.Writer, name string, args ...string) (_ []byte, err error) {
+	const maxLoggedStderr = 2048
+
+	ctx, span := tracing.StartCommand(ctx, name, args)
+	defer func() { tracing.End(span, err) }()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	if w != nil {
+		cmd.Stdout = io.MultiWriter(stdout, w)
+	}
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	duration := time.Since(start)
+	cfg.metrics.ObserveMediaCommand(name, duration, err)
+	attrs := []any{
+		"command", name,
+		"args", args,
+		"duration_ms", float64(duration.Microseconds()) / 1000,
+	}
+	if err != nil {
+		tail := stderr.Bytes()
+		if len(tail) > maxLoggedStderr {
+			tail = tail[len(tail)-maxLoggedStderr:]
+		}
+		slog.ErrorContext(ctx, "Media command failed", append(attrs, "error", err, "stderr", string(tail))...)
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	slog.InfoContext(ctx, "Media command finished", attrs...)
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateUserPasswordTooLong(t *testing.T) {
+	srv := newTestServer(t, newTestConfig(t))
+
+	// 40 characters, but bcrypt counts the 80 bytes they encode to.
+	resp := sendTestRequest(t, srv, http.MethodPost, "/api/users", "", map[string]string{
+		"email":    "user@example.com",
+		"password": strings.Repeat("é", 40),
+	})
+	var problem struct {
+		Code   string `json:"code"`
+		Errors []struct {
+			Field string `json:"field"`
+			Code  string `json:"code"`
+		} `json:"errors"`
+	}
+	decodeTestResponse(t, resp, http.StatusUnprocessableEntity, &problem)
+	if problem.Code != codeValidationFailed || len(problem.Errors) != 1 || problem.Errors[0].Field != "password" {
+		t.Fatalf("got %+v, want a validation error for password", problem)
+	}
+}
+
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+
+	resp := sendTestRequest(t, srv, http.MethodPost, "/api/users", "", map[string]string{
+		"email":    "user@example.com",
+		"password": "otherpass",
+	})
+	if code := problemCode(t, resp, http.StatusConflict); code != codeEmailTaken {
+		t.Fatalf("got code %q, want %q", code, codeEmailTaken)
+	}
+}
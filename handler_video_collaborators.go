@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoAccess looks up the user's collaborator role on a video and their role
+// in the organization that owns it, if any.
+func (cfg *apiConfig) videoAccess(ctx context.Context, video database.Video, userID uuid.UUID) (authz.VideoAccess, error) {
+	access := authz.VideoAccess{}
+
+	collaborator, err := cfg.db.WithContext(ctx).GetVideoCollaborator(video.ID, userID)
+	if err != nil {
+		return authz.VideoAccess{}, err
+	}
+	if collaborator != nil {
+		access.Collaborator = collaborator.Role
+	}
+
+	if video.OrganizationID != nil {
+		member, err := cfg.db.WithContext(ctx).GetOrganizationMember(*video.OrganizationID, userID)
+		if err != nil {
+			return authz.VideoAccess{}, err
+		}
+		if member != nil {
+			access.Organization = member.Role
+		}
+	}
+	return access, nil
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	principal := requestPrincipal(r)
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanViewVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+
+	collaborators, err := cfg.db.WithContext(r.Context()).GetVideoCollaborators(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, collaborators)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsInvite(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email" validate:"required,email,max=254"`
+		Role  database.CollaboratorRole `json:"role" validate:"required,oneof=viewer editor owner"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	principal := requestPrincipal(r)
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanManageCollaborators(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't manage collaborators on this video", nil)
+		return
+	}
+
+	invitee, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if invitee.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No user with that email", nil)
+		return
+	}
+	if invitee.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The video's creator is already its owner", nil)
+		return
+	}
+
+	collaborator, err := cfg.db.WithContext(r.Context()).UpsertVideoCollaborator(database.CreateVideoCollaboratorParams{
+		VideoID: video.ID,
+		UserID:  invitee.ID,
+		Role:    params.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add collaborator", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, collaborator)
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsRemove(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	collaboratorID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	principal := requestPrincipal(r)
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	// Collaborators can always leave a video on their own.
+	if collaboratorID != principal.UserID && !authz.CanManageCollaborators(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't manage collaborators on this video", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).DeleteVideoCollaborator(video.ID, collaboratorID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove collaborator", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
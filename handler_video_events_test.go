@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// openTestEventStream subscribes to a video's events until ctx is done.
+func openTestEventStream(t *testing.T, ctx context.Context, srv *httptest.Server, token string, videoID uuid.UUID) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req := newTestRequest(t, srv, http.MethodGet, "/api/videos/"+videoID.String()+"/events", token, nil)
+	resp, err := srv.Client().Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and type %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readTestEvent reads the next event from a stream.
+func readTestEvent(t *testing.T, stream *bufio.Reader) progress.Event {
+	t.Helper()
+	var event progress.Event
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before an event: %v", err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		err = json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+}
+
+// waitForSubscribers waits until a video has n subscribers.
+func waitForSubscribers(t *testing.T, cfg *apiConfig, videoID uuid.UUID, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cfg.progress.Subscribers(videoID) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("video has %d subscribers, want %d", cfg.progress.Subscribers(videoID), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVideoEventsStream(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Uploading", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageUploading, Bytes: 100, TotalBytes: 400})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, stream := openTestEventStream(t, ctx, srv, session.Token, video.ID)
+	if event := readTestEvent(t, stream); event.Stage != progress.StageUploading || event.Bytes != 100 {
+		t.Fatalf("got %+v, want the upload's latest progress", event)
+	}
+	cfg.progress.Publish(progress.Event{VideoID: video.ID, Stage: progress.StageStoring})
+	if event := readTestEvent(t, stream); event.Stage != progress.StageStoring {
+		t.Fatalf("got %+v, want storing", event)
+	}
+
+	// The handler unsubscribes once the client goes away.
+	waitForSubscribers(t, cfg, video.ID, 1)
+	cancel()
+	waitForSubscribers(t, cfg, video.ID, 0)
+}
+
+func TestVideoEventsStreamEndsOnShutdown(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Idle", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stream := openTestEventStream(t, context.Background(), srv, session.Token, video.ID)
+	waitForSubscribers(t, cfg, video.ID, 1)
+	cfg.progress.Close()
+
+	// The stream ends instead of holding up shutdown.
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, stream)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("stream ended with %v, want a clean end", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after the broker was closed")
+	}
+}
@@ -1,46 +1,215 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
+	// The owner and workspace come from the caller and the request, never
+	// from the body.
 	type parameters struct {
-		database.CreateVideoParams
+		Title       string `json:"title" validate:"required,max=200"`
+		Description string `json:"description" validate:"max=5000"`
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	principal := requestPrincipal(r)
+
+	workspace, ok := cfg.requestWorkspace(w, r, principal)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	createParams := database.CreateVideoParams{
+		Title:       params.Title,
+		Description: params.Description,
+		UserID:      principal.UserID,
+	}
+
+	if workspace != nil {
+		if !authz.CanCreateOrganizationVideo(principal, workspace.Role) {
+			respondWithError(w, http.StatusForbidden, "You can't add videos to this organization", nil)
+			return
+		}
+		if workspace.MaxVideos > 0 {
+			usage, err := cfg.db.WithContext(r.Context()).GetOrganizationUsage(workspace.ID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get organization usage", err)
+				return
+			}
+			if usage.Videos >= workspace.MaxVideos {
+				respondWithErrorCode(w, http.StatusForbidden, codeQuotaExceeded, "Organization video quota exceeded", nil)
+				return
+			}
+		}
+		createParams.OrganizationID = &workspace.ID
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).CreateVideo(createParams)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	cfg.emitWebhookEvent(r.Context(), webhookEventVideoCreated, video)
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, signedVideo)
+}
+
+// handlerVideoMetaUpdate applies a partial update to a video's metadata.
+// Fields left out of the body are unchanged. The caller must send the ETag
+// they last saw in If-Match, so an edit based on a stale copy is refused
+// instead of silently overwriting someone else's.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string                   `json:"title" validate:"nonblank,max=200"`
+		Description *string                   `json:"description" validate:"max=5000"`
+		Visibility  *database.VideoVisibility `json:"visibility" validate:"nonblank,oneof=private unlisted public"`
+		Tags        *[]string                 `json:"tags" validate:"max=20"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	principal := requestPrincipal(r)
+
 	params := parameters{}
-	err = decoder.Decode(&params)
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	var tags []string
+	if params.Tags != nil {
+		var errs []fieldError
+		tags, errs = normalizeTags(*params.Tags)
+		if len(errs) > 0 {
+			respondWithValidationErrors(w, errs)
+			return
+		}
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respondWithError(w, http.StatusPreconditionRequired, "Send the video's ETag in If-Match", nil)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanEditVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
 		return
 	}
-	params.UserID = userID
+	if !etagMatches(ifMatch, videoETag(video)) {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithError(w, http.StatusPreconditionFailed, "The video was changed since you last loaded it", nil)
+		return
+	}
+
+	lastUpdatedAt := video.UpdatedAt
+	if params.Title != nil {
+		video.Title = strings.TrimSpace(*params.Title)
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		video.Visibility = *params.Visibility
+	}
+	if params.Tags != nil {
+		video.Tags = tags
+	}
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	updated, err := cfg.db.WithContext(r.Context()).UpdateVideoIfUnchanged(&video, lastUpdatedAt)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if !updated {
+		respondWithError(w, http.StatusPreconditionFailed, "The video was changed since you last loaded it", nil)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// videoETag identifies a version of a video's metadata. It changes whenever
+// the video is saved, since every save bumps updated_at.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%d"`, video.UpdatedAt.UnixMilli())
+}
+
+// etagMatches reports whether an If-Match header matches etag. If-Match uses
+// strong comparison, so weak validators never match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags trims and lowercases tags and drops duplicates, keeping the
+// order they were given in.
+func normalizeTags(tags []string) ([]string, []fieldError) {
+	const maxTagLength = 50
+
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	var errs []fieldError
+	for i, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		field := fmt.Sprintf("tags[%d]", i)
+		switch {
+		case tag == "":
+			errs = append(errs, fieldError{Field: field, Code: "blank", Message: "can't be blank"})
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			errs = append(errs, fieldError{Field: field, Code: "too_long", Message: fmt.Sprintf("must have at most %d characters", maxTagLength)})
+		case !seen[tag]:
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, errs
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -51,32 +220,39 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	principal := requestPrincipal(r)
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
-
-	video, err := cfg.db.GetVideo(videoID)
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanDeleteVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't delete this video", nil)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+
+	err = cfg.deleteVideoBlobs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete stored media", err)
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.db.WithContext(r.Context()).DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.emitWebhookEvent(r.Context(), webhookEventVideoDeleted, video)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -89,48 +265,126 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	// Videos that aren't private can be watched without signing in. A token
+	// without the videos:read scope sees only what an anonymous caller would.
+	principal, ok := authz.FromContext(r.Context())
+	if ok && !principal.HasScope(auth.ScopeVideosRead) {
+		principal = authz.Principal{}
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	access, err := cfg.videoAccess(r.Context(), video, principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video access", err)
+		return
+	}
+	if !authz.CanViewVideo(principal, video, access) {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
 		return
 	}
 
-	// signedVideo, err := cfg.dbVideoToSignedVideo(video)
-	// if err != nil {
-	// 	respondWithError(w, http.StatusInternalServerError, "Couldn't get presigned URL", err)
-	// 	return
-	// }
-
-	respondWithJSON(w, http.StatusOK, video)
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, signedVideo)
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	principal := requestPrincipal(r)
+
+	workspace, ok := cfg.requestWorkspace(w, r, principal)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	sharedVideos, err := cfg.db.WithContext(r.Context()).GetCollaboratorVideos(principal.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve shared videos", err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	var listed []database.CollaboratorVideo
+	if workspace != nil {
+		listed, err = cfg.workspaceVideos(r.Context(), principal, workspace, sharedVideos)
+	} else {
+		listed, err = cfg.personalVideos(r.Context(), principal, sharedVideos)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	// signedVideos := make([]database.Video, len(videos))
-	// for i, video := range videos {
-	// 	signedVideo, err := cfg.dbVideoToSignedVideo(video)
-	// 	if err != nil {
-	// 		respondWithError(w, http.StatusInternalServerError, "Couldn't get presigned URL", err)
-	// 		return
-	// 	}
-	// 	signedVideos[i] = signedVideo
-	// }
+	for i := range listed {
+		listed[i].Video, err = cfg.dbVideoToSignedVideo(r.Context(), listed[i].Video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video links", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, listed)
+}
+
+// personalVideos lists the caller's own personal videos and every video
+// shared with them directly.
+func (cfg *apiConfig) personalVideos(ctx context.Context, principal authz.Principal, sharedVideos []database.CollaboratorVideo) ([]database.CollaboratorVideo, error) {
+	videos, err := cfg.db.WithContext(ctx).GetVideos(principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]database.CollaboratorVideo, 0, len(videos)+len(sharedVideos))
+	for _, video := range videos {
+		listed = append(listed, database.CollaboratorVideo{
+			Video: video,
+			Role:  database.CollaboratorOwner,
+		})
+	}
+	listed = append(listed, sharedVideos...)
+	sort.SliceStable(listed, func(i, j int) bool {
+		return listed[i].CreatedAt.After(listed[j].CreatedAt)
+	})
+	return listed, nil
+}
+
+// workspaceVideos lists an organization's videos with the caller's effective
+// role on each: owner for their own videos or if they manage the
+// organization, otherwise their collaborator role, falling back to viewer.
+func (cfg *apiConfig) workspaceVideos(ctx context.Context, principal authz.Principal, workspace *database.OrganizationMembership, sharedVideos []database.CollaboratorVideo) ([]database.CollaboratorVideo, error) {
+	videos, err := cfg.db.WithContext(ctx).GetOrganizationVideos(workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedRoles := make(map[uuid.UUID]database.CollaboratorRole, len(sharedVideos))
+	for _, video := range sharedVideos {
+		sharedRoles[video.ID] = video.Role
+	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	listed := make([]database.CollaboratorVideo, 0, len(videos))
+	for _, video := range videos {
+		access := authz.VideoAccess{
+			Collaborator: sharedRoles[video.ID],
+			Organization: workspace.Role,
+		}
+		role := database.CollaboratorViewer
+		switch {
+		case authz.CanManageCollaborators(principal, video, access):
+			role = database.CollaboratorOwner
+		case authz.CanEditVideo(principal, video, access):
+			role = database.CollaboratorEditor
+		}
+		listed = append(listed, database.CollaboratorVideo{Video: video, Role: role})
+	}
+	return listed, nil
 }
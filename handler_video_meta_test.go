@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// patchTestVideo sends a PATCH of a video's metadata, with ifMatch in
+// If-Match unless it's empty.
+func patchTestVideo(t *testing.T, srv *httptest.Server, token string, videoID, ifMatch string, body any) *http.Response {
+	t.Helper()
+	req := newTestRequest(t, srv, http.MethodPatch, "/api/videos/"+videoID, token, body)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return doTestRequest(t, srv, req)
+}
+
+// getTestVideo fetches a video and returns it with its ETag.
+func getTestVideo(t *testing.T, srv *httptest.Server, token, videoID string) (database.Video, string) {
+	t.Helper()
+	resp := sendTestRequest(t, srv, http.MethodGet, "/api/videos/"+videoID, token, nil)
+	var video database.Video
+	decodeTestResponse(t, resp, http.StatusOK, &video)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("video has no ETag")
+	}
+	return video, etag
+}
+
+func TestVideoMetaUpdate(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	created, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Tpyo", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoID := created.ID.String()
+	before, etag := getTestVideo(t, srv, session.Token, videoID)
+
+	resp := patchTestVideo(t, srv, session.Token, videoID, "", map[string]any{"title": "Typo"})
+	decodeTestResponse(t, resp, http.StatusPreconditionRequired, nil)
+
+	resp = patchTestVideo(t, srv, session.Token, videoID, etag, map[string]any{
+		"title":      "Typo",
+		"visibility": "unlisted",
+		"tags":       []string{"Go", "go", "news"},
+	})
+	var updated database.Video
+	decodeTestResponse(t, resp, http.StatusOK, &updated)
+	newETag := resp.Header.Get("ETag")
+	if updated.Title != "Typo" || updated.Visibility != database.VisibilityUnlisted || len(updated.Tags) != 2 {
+		t.Fatalf("got %+v, want the new title, visibility and tags", updated)
+	}
+	if updated.Description != before.Description {
+		t.Fatalf("description changed to %q though it was left out", updated.Description)
+	}
+	if !updated.UpdatedAt.After(before.UpdatedAt) {
+		t.Fatalf("updated_at went from %v to %v, want it bumped", before.UpdatedAt, updated.UpdatedAt)
+	}
+	if newETag == "" || newETag == etag {
+		t.Fatalf("got ETag %q after the update, want a new one", newETag)
+	}
+	if _, got := getTestVideo(t, srv, session.Token, videoID); got != newETag {
+		t.Fatalf("GET returned ETag %q, want %q", got, newETag)
+	}
+
+	// An edit based on the copy from before the update is refused, and
+	// told the current ETag.
+	resp = patchTestVideo(t, srv, session.Token, videoID, etag, map[string]any{"title": "Stale"})
+	if got := resp.Header.Get("ETag"); got != newETag {
+		t.Errorf("412 response has ETag %q, want %q", got, newETag)
+	}
+	decodeTestResponse(t, resp, http.StatusPreconditionFailed, nil)
+	current, _ := getTestVideo(t, srv, session.Token, videoID)
+	if current.Title != "Typo" {
+		t.Fatalf("stale edit changed the title to %q", current.Title)
+	}
+}
+
+func TestVideoMetaUpdateConcurrentEditors(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+	created, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Draft", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoID := created.ID.String()
+	_, etag := getTestVideo(t, srv, session.Token, videoID)
+
+	// Both editors loaded the same version, so only one of their edits
+	// can be saved, however the requests interleave.
+	titles := []string{"First editor", "Second editor"}
+	requests := make([]*http.Request, len(titles))
+	for i, title := range titles {
+		requests[i] = newTestRequest(t, srv, http.MethodPatch, "/api/videos/"+videoID, session.Token, map[string]any{"title": title})
+		requests[i].Header.Set("If-Match", etag)
+	}
+	statuses := make([]int, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			if winner != -1 {
+				t.Fatalf("both edits were saved: %v", statuses)
+			}
+			winner = i
+		case http.StatusPreconditionFailed:
+		default:
+			t.Fatalf("got statuses %v, want one 200 and one 412", statuses)
+		}
+	}
+	if winner == -1 {
+		t.Fatalf("got statuses %v, want one 200 and one 412", statuses)
+	}
+	current, _ := getTestVideo(t, srv, session.Token, videoID)
+	if current.Title != titles[winner] {
+		t.Fatalf("got title %q, want the saved edit's %q", current.Title, titles[winner])
+	}
+}
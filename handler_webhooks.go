@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/authz"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// webhookDeliveriesShown is how many of a webhook's latest deliveries its
+// delivery log lists.
+const webhookDeliveriesShown = 100
+
+// webhookResponse is a webhook as returned by the API. The secret is only
+// included when the webhook is created.
+type webhookResponse struct {
+	database.Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// handlerWebhooksCreate registers a webhook in the request's workspace: a
+// personal one gets events for the caller's personal videos, and an
+// organization's gets events for all the organization's videos.
+func (cfg *apiConfig) handlerWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url" validate:"required,url,max=2000"`
+		Events []string `json:"events" validate:"required,max=20"`
+	}
+
+	principal := requestPrincipal(r)
+	workspace, ok := cfg.requestWorkspace(w, r, principal)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+	events, errs := normalizeWebhookEvents(params.Events)
+	if err, ok := cfg.checkWebhookURL(r.Context(), params.URL); !ok {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		respondWithValidationErrors(w, errs)
+		return
+	}
+
+	createParams := database.CreateWebhookParams{
+		UserID: principal.UserID,
+		URL:    params.URL,
+		Events: events,
+	}
+	if workspace != nil {
+		if !authz.CanManageOrganizationWebhooks(principal, workspace.Role) {
+			respondWithError(w, http.StatusForbidden, "You can't manage this organization's webhooks", nil)
+			return
+		}
+		createParams.OrganizationID = &workspace.ID
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate webhook secret", err)
+		return
+	}
+	createParams.Secret = secret
+
+	webhook, err := cfg.db.WithContext(r.Context()).CreateWebhook(createParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, webhookResponse{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// handlerWebhooksList lists the webhooks of the request's workspace.
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	principal := requestPrincipal(r)
+	workspace, ok := cfg.requestWorkspace(w, r, principal)
+	if !ok {
+		return
+	}
+
+	var webhooks []database.Webhook
+	var err error
+	if workspace != nil {
+		if !authz.CanManageOrganizationWebhooks(principal, workspace.Role) {
+			respondWithError(w, http.StatusForbidden, "You can't manage this organization's webhooks", nil)
+			return
+		}
+		webhooks, err = cfg.db.WithContext(r.Context()).GetOrganizationWebhooks(workspace.ID)
+	} else {
+		webhooks, err = cfg.db.WithContext(r.Context()).GetUserWebhooks(principal.UserID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	resp := []webhookResponse{}
+	for _, webhook := range webhooks {
+		resp = append(resp, webhookResponse{Webhook: webhook})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerWebhookGet(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookResponse{Webhook: *webhook})
+}
+
+// handlerWebhookUpdate changes a webhook's URL or events, or turns it off
+// and on. Fields left out of the body are unchanged.
+func (cfg *apiConfig) handlerWebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    *string   `json:"url" validate:"nonblank,url,max=2000"`
+		Events *[]string `json:"events" validate:"max=20"`
+		Active *bool     `json:"active"`
+	}
+
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	if !decodeJSON(w, r, &params) {
+		return
+	}
+
+	var errs []fieldError
+	if params.URL != nil {
+		if err, ok := cfg.checkWebhookURL(r.Context(), *params.URL); !ok {
+			errs = append(errs, err)
+		}
+		webhook.URL = *params.URL
+	}
+	if params.Events != nil {
+		if len(*params.Events) == 0 {
+			errs = append(errs, fieldError{Field: "events", Code: "blank", Message: "can't be blank"})
+		}
+		var eventErrs []fieldError
+		webhook.Events, eventErrs = normalizeWebhookEvents(*params.Events)
+		errs = append(errs, eventErrs...)
+	}
+	if len(errs) > 0 {
+		respondWithValidationErrors(w, errs)
+		return
+	}
+	if params.Active != nil {
+		webhook.Active = *params.Active
+	}
+
+	err := cfg.db.WithContext(r.Context()).UpdateWebhook(webhook)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookResponse{Webhook: *webhook})
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.db.WithContext(r.Context()).DeleteWebhook(webhook.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookDeliveriesList returns a webhook's delivery log, newest
+// first.
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveries, err := cfg.db.WithContext(r.Context()).GetWebhookDeliveries(webhook.ID, webhookDeliveriesShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveryGet(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, ok := cfg.requestWebhookDelivery(w, r, *webhook)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, delivery)
+}
+
+// handlerWebhookRedeliver sends a delivery's event to its webhook again, as
+// a new delivery with the same payload. The original stays in the log.
+func (cfg *apiConfig) handlerWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.requestWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, ok := cfg.requestWebhookDelivery(w, r, *webhook)
+	if !ok {
+		return
+	}
+	if !webhook.Active {
+		respondWithError(w, http.StatusConflict, "The webhook is disabled", nil)
+		return
+	}
+
+	redelivery, err := cfg.queueWebhookDelivery(r.Context(), database.CreateWebhookDeliveryParams{
+		WebhookID:    webhook.ID,
+		EventID:      delivery.EventID,
+		EventType:    delivery.EventType,
+		Payload:      delivery.Payload,
+		RedeliveryOf: &delivery.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue redelivery", err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, redelivery)
+}
+
+// requestWebhook loads the webhook in the request path. Webhooks the caller
+// can't manage are reported as not found.
+func (cfg *apiConfig) requestWebhook(w http.ResponseWriter, r *http.Request) (*database.Webhook, bool) {
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return nil, false
+	}
+	principal := requestPrincipal(r)
+
+	webhook, err := cfg.db.WithContext(r.Context()).GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return nil, false
+	}
+	if webhook == nil {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return nil, false
+	}
+	var role database.OrganizationRole
+	if webhook.OrganizationID != nil {
+		member, err := cfg.db.WithContext(r.Context()).GetOrganizationMember(*webhook.OrganizationID, principal.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization membership", err)
+			return nil, false
+		}
+		if member != nil {
+			role = member.Role
+		}
+	}
+	if !authz.CanManageWebhook(principal, *webhook, role) {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// requestWebhookDelivery loads the delivery in the request path, which must
+// belong to webhook.
+func (cfg *apiConfig) requestWebhookDelivery(w http.ResponseWriter, r *http.Request, webhook database.Webhook) (*database.WebhookDelivery, bool) {
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return nil, false
+	}
+	delivery, err := cfg.db.WithContext(r.Context()).GetWebhookDelivery(deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery", err)
+		return nil, false
+	}
+	if delivery == nil || delivery.WebhookID != webhook.ID {
+		respondWithError(w, http.StatusNotFound, "Delivery not found", nil)
+		return nil, false
+	}
+	return delivery, true
+}
+
+// checkWebhookURL requires HTTPS, so payloads and signatures aren't sent in
+// the clear, except in dev. The host must resolve, and only to addresses
+// deliveries may be sent to; the webhook client checks the address again
+// when it connects, in case the host's DNS has changed since.
+func (cfg *apiConfig) checkWebhookURL(ctx context.Context, rawURL string) (fieldError, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// The url rule reports it.
+		return fieldError{}, true
+	}
+	if u.Scheme != "https" && cfg.platform != "dev" {
+		return fieldError{Field: "url", Code: "insecure_url", Message: "must be an https URL"}, false
+	}
+	addrs, err := lookupWebhookHost(ctx, u.Hostname())
+	if err != nil {
+		return fieldError{Field: "url", Code: "unresolvable_host", Message: "must have a host that resolves"}, false
+	}
+	for _, addr := range addrs {
+		if !webhookAddressAllowed(addr) {
+			return fieldError{Field: "url", Code: "blocked_address", Message: "must be a public address, not a loopback, private or special-purpose one"}, false
+		}
+	}
+	return fieldError{}, true
+}
+
+// lookupWebhookHost returns the addresses of a webhook URL's host, which
+// may itself be an address.
+func lookupWebhookHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// normalizeWebhookEvents checks that each event is one webhooks can
+// subscribe to and drops duplicates, keeping the order they were given in.
+func normalizeWebhookEvents(events []string) ([]string, []fieldError) {
+	known := map[string]bool{}
+	for _, event := range webhookEvents {
+		known[event] = true
+	}
+
+	normalized := make([]string, 0, len(events))
+	seen := make(map[string]bool, len(events))
+	var errs []fieldError
+	for i, event := range events {
+		switch {
+		case !known[event]:
+			errs = append(errs, fieldError{
+				Field:   fmt.Sprintf("events[%d]", i),
+				Code:    "invalid_choice",
+				Message: "must be one of " + strings.Join(webhookEvents[:len(webhookEvents)-1], ", ") + " or " + webhookEvents[len(webhookEvents)-1],
+			})
+		case !seen[event]:
+			seen[event] = true
+			normalized = append(normalized, event)
+		}
+	}
+	return normalized, errs
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateWebhookRejectsInternalAddresses(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	for _, url := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.1.2.3/hook",
+		"http://100.64.0.1/hook",
+		"http://100.127.255.254/hook",
+		"http://198.18.0.1/hook",
+		"http://198.19.255.1/hook",
+		"http://192.0.2.1/hook",
+		"http://240.0.0.1/hook",
+		"http://255.255.255.255/hook",
+		"http://224.0.0.1/hook",
+		"http://239.255.255.250/hook",
+		"http://[64:ff9b::a00:5]/hook",
+		"http://[64:ff9b::7f00:1]/hook",
+		"http://[2002:a00:5::1]/hook",
+		"http://[ff02::1]/hook",
+		"http://[ff05::2]/hook",
+		"http://[fd00::1]/hook",
+		"http://[2001:db8::1]/hook",
+	} {
+		resp := sendTestRequest(t, srv, http.MethodPost, "/api/webhooks", session.Token, map[string]any{
+			"url":    url,
+			"events": []string{webhookEventVideoCreated},
+		})
+		var problem struct {
+			Errors []fieldError `json:"errors"`
+		}
+		decodeTestResponse(t, resp, http.StatusUnprocessableEntity, &problem)
+		if len(problem.Errors) != 1 || problem.Errors[0].Code != "blocked_address" {
+			t.Errorf("%s: got errors %+v, want blocked_address", url, problem.Errors)
+		}
+	}
+
+	for _, url := range []string{
+		"http://93.184.215.14/hook",
+		"http://100.63.255.1/hook",
+		"http://198.20.0.1/hook",
+		"http://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]/hook",
+	} {
+		resp := sendTestRequest(t, srv, http.MethodPost, "/api/webhooks", session.Token, map[string]any{
+			"url":    url,
+			"events": []string{webhookEventVideoCreated},
+		})
+		decodeTestResponse(t, resp, http.StatusCreated, nil)
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	// An endpoint that passed the check when it was registered, and whose
+	// host now resolves to loopback.
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback endpoint")
+	}))
+	defer endpoint.Close()
+
+	resp, err := newWebhookClient().Post(endpoint.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("delivery to a loopback endpoint succeeded")
+	}
+	if !errors.Is(err, errWebhookAddressBlocked) {
+		t.Fatalf("got error %v, want %v", err, errWebhookAddressBlocked)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+	"unicode"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader marks a response as a replay of the one the
+	// key's first request got.
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// idempotencyKeyTTL is how long a key's response is kept for retries.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyKeyLease is how long a key stays claimed by a request that
+	// hasn't finished, in case the server dies before it does.
+	idempotencyKeyLease = time.Hour
+	// maxIdempotentRequestBytes is the most body a request with a key may
+	// have; it's a little over the largest upload, so every request the
+	// handlers accept fits.
+	maxIdempotentRequestBytes = 1<<30 + 1<<20
+	// maxIdempotentResponseBytes is the largest response that's saved.
+	maxIdempotentResponseBytes = 1 << 20
+)
+
+const (
+	codeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+)
+
+// idempotent lets clients retry next safely by sending an Idempotency-Key
+// header. The first request with a key runs as usual, and its response is
+// kept for idempotencyKeyTTL; retries with the same key and the same
+// request get that response again without running next. Reusing a key for
+// a different request, or while its first request is still running, is a
+// conflict. Server errors aren't kept, so a retry runs next again. Keys are
+// per user, so next must run behind requireAuth. Replays are byte for byte,
+// so signed media links in them may have expired by then.
+func (cfg *apiConfig) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ASCII characters", nil)
+			return
+		}
+		userID := requestPrincipal(r).UserID
+		db := cfg.db.WithContext(r.Context())
+		body := http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes)
+
+		existing, err := db.GetIdempotencyKey(userID, key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get idempotency key", err)
+			return
+		}
+		if existing != nil {
+			if !existing.Completed() {
+				respondWithErrorCode(w, http.StatusConflict, codeIdempotencyKeyInUse, "A request with this Idempotency-Key is still in progress", nil)
+				return
+			}
+			fingerprint := newRequestFingerprint(r)
+			defer fingerprint.Close()
+			_, err = io.Copy(fingerprint, body)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Couldn't read request body", err)
+				return
+			}
+			if fingerprint.Sum() != existing.Fingerprint {
+				respondWithErrorCode(w, http.StatusConflict, codeIdempotencyKeyReused, "This Idempotency-Key was used for a different request", nil)
+				return
+			}
+			replayResponse(w, *existing)
+			return
+		}
+
+		claimed, err := db.ClaimIdempotencyKey(userID, key, time.Now().Add(idempotencyKeyLease))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't claim idempotency key", err)
+			return
+		}
+		if !claimed {
+			respondWithErrorCode(w, http.StatusConflict, codeIdempotencyKeyInUse, "A request with this Idempotency-Key is still in progress", nil)
+			return
+		}
+		// From here the key must be completed or released, even if next
+		// panics, or retries would be refused until the lease runs out.
+		ctx := context.WithoutCancel(r.Context())
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			err := cfg.db.WithContext(ctx).ReleaseIdempotencyKey(userID, key)
+			if err != nil {
+				slog.ErrorContext(ctx, "Couldn't release idempotency key", "error", err)
+			}
+		}()
+
+		// The body is fingerprinted as next reads it, so uploads still
+		// stream. The response is held back until the rest of the body has
+		// been read, since writing it would make the server discard what's
+		// left unread.
+		fingerprint := newRequestFingerprint(r)
+		defer fingerprint.Close()
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(body, fingerprint), body}
+		resp := &bufferedResponse{ResponseWriter: w, header: http.Header{}}
+		next(resp, r)
+		_, drainErr := io.Copy(fingerprint, body)
+
+		if drainErr == nil && resp.status < 500 && resp.body.Len() <= maxIdempotentResponseBytes {
+			err = cfg.db.WithContext(ctx).CompleteIdempotencyKey(database.IdempotencyKey{
+				UserID:          userID,
+				Key:             key,
+				Fingerprint:     fingerprint.Sum(),
+				ResponseStatus:  resp.status,
+				ResponseHeaders: resp.header,
+				ResponseBody:    resp.body.Bytes(),
+				ExpiresAt:       time.Now().Add(idempotencyKeyTTL),
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "Couldn't save idempotent response", "error", err)
+			} else {
+				saved = true
+			}
+		}
+		resp.flush()
+	}
+}
+
+// validIdempotencyKey reports whether a key is short and printable ASCII,
+// so it's safe to store and log.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func replayResponse(w http.ResponseWriter, k database.IdempotencyKey) {
+	for name, values := range k.ResponseHeaders {
+		w.Header()[name] = values
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(k.ResponseStatus)
+	w.Write(k.ResponseBody)
+}
+
+// bufferedResponse holds a handler's response until flush is called.
+type bufferedResponse struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Unwrap lets logResponseError reach the response recorder beneath.
+func (b *bufferedResponse) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+func (b *bufferedResponse) flush() {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	for name, values := range b.header {
+		b.ResponseWriter.Header()[name] = values
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.body.Bytes())
+}
+
+// requestFingerprint hashes what a request asks for: its method, path,
+// workspace and body. Multipart bodies are hashed part by part rather than
+// byte for byte, since a client retrying an upload usually picks a new
+// boundary.
+type requestFingerprint struct {
+	// request identifies everything but the body.
+	request []byte
+	body    hash.Hash
+	// parts is fed the body when it's multipart; partsDone delivers the
+	// parts' hash, or nil if the body turned out not to parse.
+	parts     *io.PipeWriter
+	partsDone chan []byte
+}
+
+func newRequestFingerprint(r *http.Request) *requestFingerprint {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	request := sha256.New()
+	for _, s := range []string{r.Method, r.URL.Path, r.Header.Get(workspaceHeader), mediaType} {
+		request.Write([]byte(s))
+		request.Write([]byte{0})
+	}
+	f := &requestFingerprint{request: request.Sum(nil), body: sha256.New()}
+
+	if mediaType == "multipart/form-data" && params["boundary"] != "" {
+		pr, pw := io.Pipe()
+		f.parts = pw
+		f.partsDone = make(chan []byte, 1)
+		go func() {
+			sum, err := hashMultipart(multipart.NewReader(pr, params["boundary"]))
+			if err != nil {
+				pr.CloseWithError(err)
+				f.partsDone <- nil
+				return
+			}
+			// Whatever follows the last part doesn't count.
+			io.Copy(io.Discard, pr)
+			f.partsDone <- sum
+		}()
+	}
+	return f
+}
+
+func (f *requestFingerprint) Write(p []byte) (int, error) {
+	f.body.Write(p)
+	if f.parts != nil {
+		_, err := f.parts.Write(p)
+		if err != nil {
+			// The body isn't valid multipart; the raw hash will do.
+			f.parts = nil
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops hashing multipart parts, if Sum hasn't already.
+func (f *requestFingerprint) Close() error {
+	if f.parts != nil {
+		return f.parts.Close()
+	}
+	return nil
+}
+
+// Sum returns the fingerprint once the whole body has been written.
+func (f *requestFingerprint) Sum() string {
+	body := f.body.Sum(nil)
+	if f.partsDone != nil {
+		if f.parts != nil {
+			f.parts.Close()
+		}
+		if parts := <-f.partsDone; parts != nil {
+			body = parts
+		}
+	}
+	return hex.EncodeToString(f.request) + "." + hex.EncodeToString(body)
+}
+
+func hashMultipart(reader *multipart.Reader) ([]byte, error) {
+	h := sha256.New()
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return h.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range []string{part.FormName(), part.FileName(), part.Header.Get("Content-Type")} {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+		_, err = io.Copy(h, part)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte{0})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// createTestVideoIdempotently sends POST /api/videos with an Idempotency-Key
+// and returns the response status, body and whether it was a replay.
+func createTestVideoIdempotently(t *testing.T, srv *httptest.Server, token, key, title string) (int, []byte, bool) {
+	t.Helper()
+	req := newTestRequest(t, srv, http.MethodPost, "/api/videos", token, map[string]any{"title": title})
+	req.Header.Set(idempotencyKeyHeader, key)
+	resp := doTestRequest(t, srv, req)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body, resp.Header.Get(idempotencyReplayedHeader) == "true"
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	status, body, replayed := createTestVideoIdempotently(t, srv, session.Token, "key-1", "First")
+	if status != http.StatusCreated || replayed {
+		t.Fatalf("first request: got status %d, replayed %v, want %d and not replayed", status, replayed, http.StatusCreated)
+	}
+	stored, err := cfg.db.GetIdempotencyKey(user.ID, "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.ResponseStatus != http.StatusCreated || !bytes.Equal(stored.ResponseBody, body) {
+		t.Fatalf("got stored key %+v, want the first response", stored)
+	}
+
+	retryStatus, retryBody, replayed := createTestVideoIdempotently(t, srv, session.Token, "key-1", "First")
+	if retryStatus != status || !bytes.Equal(retryBody, body) || !replayed {
+		t.Fatalf("retry: got status %d, replayed %v, body %s, want the first response replayed", retryStatus, replayed, retryBody)
+	}
+	videos, err := cfg.db.GetVideos(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 {
+		t.Fatalf("got %d videos, want the retry not to create another", len(videos))
+	}
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	status, _, _ := createTestVideoIdempotently(t, srv, session.Token, "key-1", "First")
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	req := newTestRequest(t, srv, http.MethodPost, "/api/videos", session.Token, map[string]any{"title": "Second"})
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	resp := doTestRequest(t, srv, req)
+	if code := problemCode(t, resp, http.StatusConflict); code != codeIdempotencyKeyReused {
+		t.Fatalf("got code %q, want %q", code, codeIdempotencyKeyReused)
+	}
+}
+
+func TestIdempotencyKeysArePerUser(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	createTestUser(t, cfg, "alice@example.com", "alicepass")
+	createTestUser(t, cfg, "bob@example.com", "bobpass")
+	alice := loginTestUser(t, srv, "alice@example.com", "alicepass")
+	bob := loginTestUser(t, srv, "bob@example.com", "bobpass")
+
+	_, aliceBody, _ := createTestVideoIdempotently(t, srv, alice.Token, "key-1", "Same")
+	status, bobBody, replayed := createTestVideoIdempotently(t, srv, bob.Token, "key-1", "Same")
+	if status != http.StatusCreated || replayed || bytes.Equal(aliceBody, bobBody) {
+		t.Fatalf("got status %d, replayed %v, body %s, want Bob's own video", status, replayed, bobBody)
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	cfg := newTestConfig(t)
+	srv := newTestServer(t, cfg)
+	user := createTestUser(t, cfg, "user@example.com", "userpass")
+	session := loginTestUser(t, srv, "user@example.com", "userpass")
+
+	_, body, _ := createTestVideoIdempotently(t, srv, session.Token, "key-1", "First")
+	stored, err := cfg.db.GetIdempotencyKey(user.ID, "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil {
+		t.Fatal("key wasn't stored")
+	}
+	if stored.ExpiresAt.Before(time.Now().Add(idempotencyKeyTTL - time.Minute)) {
+		t.Fatalf("key expires at %v, want it kept for %v", stored.ExpiresAt, idempotencyKeyTTL)
+	}
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	err = cfg.db.CompleteIdempotencyKey(*stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the key has expired, a retry runs again instead of being replayed.
+	status, retryBody, replayed := createTestVideoIdempotently(t, srv, session.Token, "key-1", "First")
+	if status != http.StatusCreated || replayed || bytes.Equal(retryBody, body) {
+		t.Fatalf("got status %d, replayed %v, body %s, want a new video", status, replayed, retryBody)
+	}
+	videos, err := cfg.db.GetVideos(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("got %d videos, want 2", len(videos))
+	}
+}
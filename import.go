@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindVideoImport = "video_import"
+
+	// importSliceDuration is how long one import job runs before handing
+	// the rest of the directory to a follow-up job, so no job outlives its
+	// lease.
+	importSliceDuration = 10 * time.Minute
+)
+
+type videoImportJob struct {
+	ImportID uuid.UUID `json:"import_id"`
+}
+
+// importSidecar is the optional <name>.json next to a video. Anything left
+// out keeps the default: the file name as the title, and a private video.
+type importSidecar struct {
+	Title       *string                   `json:"title"`
+	Description *string                   `json:"description"`
+	Visibility  *database.VideoVisibility `json:"visibility"`
+	Tags        []string                  `json:"tags"`
+	// Thumbnail names the thumbnail, relative to the sidecar, when it
+	// isn't <name>.png or <name>.jpg.
+	Thumbnail string `json:"thumbnail"`
+}
+
+// importSource is a video file and the files that go with it, matched by
+// name: for videos/intro.mp4, videos/intro.json and videos/intro.png.
+type importSource struct {
+	// path is the video's path within the import directory.
+	path      string
+	video     string
+	sidecar   string
+	thumbnail string
+}
+
+// thumbnailTypes maps the thumbnail extensions an import picks up to their
+// media types.
+var thumbnailTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// findImportSources walks dir for MP4 files and their sidecars and
+// thumbnails, in path order. Hidden files and directories are skipped.
+func findImportSources(dir string) ([]importSource, error) {
+	videos := map[string]*importSource{}
+	sidecars := map[string]string{}
+	thumbnails := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(rel))
+		stem := strings.TrimSuffix(rel, filepath.Ext(rel))
+		switch {
+		case ext == ".mp4":
+			videos[stem] = &importSource{path: filepath.ToSlash(rel), video: p}
+		case ext == ".json":
+			sidecars[stem] = p
+		case thumbnailTypes[ext] != "":
+			thumbnails[stem] = p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]importSource, 0, len(videos))
+	for stem, source := range videos {
+		source.sidecar = sidecars[stem]
+		source.thumbnail = thumbnails[stem]
+		sources = append(sources, *source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].path < sources[j].path })
+	return sources, nil
+}
+
+// importOutcome is what happened to one file.
+type importOutcome string
+
+const (
+	importImported importOutcome = "imported"
+	importSkipped  importOutcome = "skipped"
+	importFailed   importOutcome = "failed"
+	// importCounted means an earlier slice of the same import already
+	// dealt with the file.
+	importCounted importOutcome = "counted"
+)
+
+// importProgress reports one file of an import as it's done.
+type importProgress struct {
+	Index   int
+	Total   int
+	Path    string
+	Outcome importOutcome
+	VideoID uuid.UUID
+	Err     error
+}
+
+// errImportSliceDone means an import stopped at its deadline with files
+// left to do.
+var errImportSliceDone = errors.New("import slice done")
+
+// runImport imports every video in imp.Dir into imp.UserID's workspace,
+// saving the import's progress after each file. Each file is recorded by its
+// contents, so running an import again, or a new import of the same files,
+// picks up where an interrupted one stopped and skips what's done. With a
+// non-zero deadline, it returns errImportSliceDone if files are left when
+// the deadline passes.
+func (cfg *apiConfig) runImport(ctx context.Context, imp *database.Import, deadline time.Time, progress func(importProgress)) error {
+	db := cfg.db.WithContext(ctx)
+	sources, err := findImportSources(imp.Dir)
+	if err != nil {
+		return fmt.Errorf("couldn't read %s: %w", imp.Dir, err)
+	}
+	imp.Status = database.ImportStatusRunning
+	imp.Total = len(sources)
+	err = db.UpdateImport(imp)
+	if err != nil {
+		return err
+	}
+
+	failed := map[string]bool{}
+	for _, failure := range imp.Failures {
+		failed[failure.Path] = true
+	}
+	// Each slice handles at least one file, however slow, so it always
+	// makes progress.
+	handled := false
+	for i, source := range sources {
+		if failed[source.path] {
+			continue
+		}
+		if handled && !deadline.IsZero() && time.Now().After(deadline) {
+			return errImportSliceDone
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		outcome, videoID, fileErr := cfg.importFile(ctx, imp, source)
+		switch outcome {
+		case importCounted:
+			continue
+		}
+		handled = true
+		switch outcome {
+		case importImported:
+			imp.Imported++
+		case importSkipped:
+			imp.Skipped++
+		case importFailed:
+			// An interrupted file isn't a failure; it's resumed next time.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			imp.Failed++
+			imp.Failures = append(imp.Failures, database.ImportFailure{Path: source.path, Error: fileErr.Error()})
+			slog.WarnContext(ctx, "Couldn't import video", "import_id", imp.ID, "path", source.path, "error", fileErr)
+		}
+		err = db.UpdateImport(imp)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(importProgress{
+				Index:   i + 1,
+				Total:   len(sources),
+				Path:    source.path,
+				Outcome: outcome,
+				VideoID: videoID,
+				Err:     fileErr,
+			})
+		}
+	}
+	return nil
+}
+
+// finishImport records how an import ended.
+func (cfg *apiConfig) finishImport(ctx context.Context, imp *database.Import, err error) error {
+	now := time.Now().UTC()
+	imp.FinishedAt = &now
+	imp.Status = database.ImportStatusSucceeded
+	if err != nil {
+		message := err.Error()
+		imp.Error = &message
+		imp.Status = database.ImportStatusFailed
+	} else if imp.Failed > 0 {
+		imp.Status = database.ImportStatusFailed
+	}
+	return cfg.db.WithContext(context.WithoutCancel(ctx)).UpdateImport(imp)
+}
+
+// importFile brings in one video. A file whose import was interrupted is
+// finished on the video made the first time; one already imported, by this
+// or an earlier import, is skipped.
+func (cfg *apiConfig) importFile(ctx context.Context, imp *database.Import, source importSource) (importOutcome, uuid.UUID, error) {
+	db := cfg.db.WithContext(ctx)
+	sidecar, err := readImportSidecar(source)
+	if err != nil {
+		return importFailed, uuid.Nil, err
+	}
+	if sidecar.Thumbnail != "" {
+		source.thumbnail = filepath.Join(filepath.Dir(source.sidecar), sidecar.Thumbnail)
+	}
+	sum, err := fileSHA256(source.video)
+	if err != nil {
+		return importFailed, uuid.Nil, err
+	}
+	record, err := db.GetImportedFile(imp.UserID, sum)
+	if err != nil {
+		return importFailed, uuid.Nil, err
+	}
+
+	var video database.Video
+	if record != nil {
+		video, err = db.GetVideo(record.VideoID)
+		if err != nil {
+			return importFailed, uuid.Nil, err
+		}
+		// A video deleted since is imported afresh.
+		if video.ID != uuid.Nil && record.CompletedAt != nil {
+			if record.ImportID == imp.ID {
+				return importCounted, video.ID, nil
+			}
+			// Claimed for this import, so a later slice of it doesn't
+			// count the file again.
+			record.ImportID = imp.ID
+			err = db.SaveImportedFile(*record)
+			if err != nil {
+				return importFailed, video.ID, err
+			}
+			return importSkipped, video.ID, nil
+		}
+	}
+
+	if video.ID == uuid.Nil {
+		video, err = cfg.createImportedVideo(ctx, imp.UserID, source, sidecar)
+		if err != nil {
+			return importFailed, uuid.Nil, err
+		}
+		record = &database.ImportedFile{
+			UserID:  imp.UserID,
+			SHA256:  sum,
+			VideoID: video.ID,
+		}
+	}
+	record.ImportID = imp.ID
+	record.Path = source.path
+	err = db.SaveImportedFile(*record)
+	if err != nil {
+		return importFailed, video.ID, err
+	}
+
+	if video.VideoURL == nil {
+		err = cfg.storeImportedVideo(ctx, &video, source.video)
+		if err != nil {
+			return importFailed, video.ID, err
+		}
+	}
+	if source.thumbnail != "" && video.ThumbnailURL == nil {
+		err = cfg.storeImportedThumbnail(ctx, &video, source.thumbnail)
+		if err != nil {
+			return importFailed, video.ID, err
+		}
+	}
+
+	now := time.Now().UTC()
+	record.CompletedAt = &now
+	err = db.SaveImportedFile(*record)
+	if err != nil {
+		return importFailed, video.ID, err
+	}
+	return importImported, video.ID, nil
+}
+
+func readImportSidecar(source importSource) (importSidecar, error) {
+	sidecar := importSidecar{}
+	if source.sidecar == "" {
+		return sidecar, nil
+	}
+	data, err := os.ReadFile(source.sidecar)
+	if err != nil {
+		return sidecar, err
+	}
+	err = json.Unmarshal(data, &sidecar)
+	if err != nil {
+		return sidecar, fmt.Errorf("couldn't parse %s: %w", filepath.Base(source.sidecar), err)
+	}
+	// The thumbnail is published, so it mustn't name a file outside the
+	// import directory.
+	if sidecar.Thumbnail != "" && !filepath.IsLocal(sidecar.Thumbnail) {
+		return sidecar, fmt.Errorf("%s: thumbnail %q isn't a path within its directory", filepath.Base(source.sidecar), sidecar.Thumbnail)
+	}
+	return sidecar, nil
+}
+
+// createImportedVideo makes the video row for a file, with the details from
+// its sidecar.
+func (cfg *apiConfig) createImportedVideo(ctx context.Context, userID uuid.UUID, source importSource, sidecar importSidecar) (database.Video, error) {
+	name := filepath.Base(source.video)
+	params := database.CreateVideoParams{
+		Title:  strings.TrimSuffix(name, filepath.Ext(name)),
+		UserID: userID,
+	}
+	if sidecar.Title != nil {
+		params.Title = *sidecar.Title
+	}
+	if sidecar.Description != nil {
+		params.Description = *sidecar.Description
+	}
+	if sidecar.Visibility != nil && !sidecar.Visibility.Valid() {
+		return database.Video{}, fmt.Errorf("unknown visibility %q", *sidecar.Visibility)
+	}
+	tags, errs := normalizeTags(sidecar.Tags)
+	if len(errs) > 0 {
+		return database.Video{}, fmt.Errorf("%s %s", errs[0].Field, errs[0].Message)
+	}
+
+	db := cfg.db.WithContext(ctx)
+	video, err := db.CreateVideo(params)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if sidecar.Visibility != nil || len(tags) > 0 {
+		if sidecar.Visibility != nil {
+			video.Visibility = *sidecar.Visibility
+		}
+		video.Tags = tags
+		err = db.UpdateVideo(&video)
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+	cfg.emitWebhookEvent(ctx, webhookEventVideoCreated, video)
+	return video, nil
+}
+
+// storeImportedVideo runs a file through the upload pipeline from a copy in
+// the temp directory, since processing writes next to its input and the
+// import directory may not be writable.
+func (cfg *apiConfig) storeImportedVideo(ctx context.Context, video *database.Video, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tempFile, err := os.CreateTemp(cfg.tempDir, "import-*.mp4")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	_, err = io.Copy(tempFile, src)
+	if err != nil {
+		return err
+	}
+	cfg.emitWebhookEvent(ctx, webhookEventVideoUploaded, *video)
+	return cfg.storeVideoFile(ctx, video, tempFile.Name())
+}
+
+func (cfg *apiConfig) storeImportedThumbnail(ctx context.Context, video *database.Video, path string) error {
+	mediaType := thumbnailTypes[strings.ToLower(filepath.Ext(path))]
+	if mediaType == "" {
+		return fmt.Errorf("thumbnail %s must be a JPEG or PNG image", filepath.Base(path))
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return cfg.storeThumbnail(ctx, video, file, mediaType, cfg.publicURL)
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// runVideoImport runs an import queued through the admin API, a slice at a
+// time; each slice that runs out of time queues the next.
+func (cfg *apiConfig) runVideoImport(ctx context.Context, job database.Job) error {
+	params := videoImportJob{}
+	err := json.Unmarshal(job.Payload, &params)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	imp, err := cfg.db.WithContext(ctx).GetImport(params.ImportID)
+	if err != nil {
+		return err
+	}
+	if imp == nil || imp.FinishedAt != nil {
+		return nil
+	}
+
+	err = cfg.runImport(ctx, imp, time.Now().Add(importSliceDuration), nil)
+	if errors.Is(err, errImportSliceDone) {
+		return cfg.enqueueJob(ctx, jobKindVideoImport, params, time.Now(), importJobAttempts)
+	}
+	if err != nil && (job.Attempts < job.MaxAttempts || ctx.Err() != nil) {
+		// Retried, or released at shutdown; either way it resumes.
+		return err
+	}
+	finishErr := cfg.finishImport(ctx, imp, err)
+	if finishErr != nil {
+		return finishErr
+	}
+	return err
+}
+
+// importJobAttempts is how many times an import job is tried before the
+// import is marked failed.
+const importJobAttempts = 3
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadImportSidecarConfinesThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	sidecar := filepath.Join(dir, "intro.json")
+	source := importSource{path: "intro.mp4", video: filepath.Join(dir, "intro.mp4"), sidecar: sidecar}
+
+	tests := []struct {
+		thumbnail string
+		ok        bool
+	}{
+		{"cover.png", true},
+		{"covers/intro.png", true},
+		{"../secret.png", false},
+		{"../../../../home/x/secret.png", false},
+		{"covers/../../secret.png", false},
+		{"/etc/secret.png", false},
+	}
+	for _, tt := range tests {
+		err := os.WriteFile(sidecar, []byte(`{"thumbnail": "`+tt.thumbnail+`"}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readImportSidecar(source)
+		if tt.ok && (err != nil || got.Thumbnail != tt.thumbnail) {
+			t.Errorf("%q: got %q, %v, want it accepted", tt.thumbnail, got.Thumbnail, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%q: accepted a thumbnail outside the import directory", tt.thumbnail)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,8 +21,69 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMFAChallenge is issued after a correct password when the
+	// account has two-factor authentication enabled. It can only be
+	// exchanged for an access token alongside a valid second factor.
+	TokenTypeMFAChallenge TokenType = "tubely-mfa-challenge"
 )
 
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Scope limits what an access token may be used for. Tokens issued at login
+// carry no scopes and may be used for anything the user's role allows.
+type Scope string
+
+const (
+	ScopeVideosRead  Scope = "videos:read"
+	ScopeVideosWrite Scope = "videos:write"
+	ScopeOrgs        Scope = "orgs"
+	ScopeWebhooks    Scope = "webhooks"
+	ScopeAccount     Scope = "account"
+	ScopeAdmin       Scope = "admin"
+)
+
+// Scopes lists every scope a token can be limited to.
+var Scopes = []Scope{ScopeVideosRead, ScopeVideosWrite, ScopeOrgs, ScopeWebhooks, ScopeAccount, ScopeAdmin}
+
+// Valid reports whether s is a known scope.
+func (s Scope) Valid() bool {
+	return slices.Contains(Scopes, s)
+}
+
+// Claims are the claims carried in a Tubely access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role Role `json:"role,omitempty"`
+	// Scope is a space-separated list of scopes, as in OAuth 2.0.
+	Scope string `json:"scope,omitempty"`
+	// Version is the user's token version when the token was issued. The
+	// token stops being accepted once the user's sessions are ended.
+	Version int `json:"ver,omitempty"`
+}
+
+// AccessToken is the validated content of an access token.
+type AccessToken struct {
+	UserID uuid.UUID
+	Role   Role
+	Scopes []Scope
+	// Version is the user's token version the token was issued at.
+	Version int
+}
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -34,33 +98,118 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// MakeJWT issues an access token for a user at their current token
+// version.
 func MakeJWT(
 	userID uuid.UUID,
+	role Role,
+	version int,
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
+	return MakeScopedJWT(userID, role, version, nil, tokenSecret, expiresIn)
+}
+
+// MakeScopedJWT issues an access token that may only be used for the given
+// scopes. No scopes means no restriction.
+func MakeScopedJWT(
+	userID uuid.UUID,
+	role Role,
+	version int,
+	scopes []Scope,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	scopeNames := make([]string, len(scopes))
+	for i, scope := range scopes {
+		scopeNames[i] = string(scope)
+	}
 	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Role:    role,
+		Scope:   strings.Join(scopeNames, " "),
+		Version: version,
 	})
 	return token.SignedString(signingKey)
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+	accessToken, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return accessToken.UserID, nil
+}
+
+// ValidateAccessToken validates an access token and returns the user and role
+// it was issued for. Tokens issued before roles existed are treated as RoleUser.
+func ValidateAccessToken(tokenString, tokenSecret string) (AccessToken, error) {
+	claimsStruct := Claims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
 		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if issuer != string(TokenTypeAccess) {
+		return AccessToken{}, errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(userIDString)
+	if err != nil {
+		return AccessToken{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	role := claimsStruct.Role
+	if role == "" {
+		role = RoleUser
+	}
+	if !role.Valid() {
+		return AccessToken{}, fmt.Errorf("invalid role: %s", role)
+	}
+	var scopes []Scope
+	for _, scope := range strings.Fields(claimsStruct.Scope) {
+		scopes = append(scopes, Scope(scope))
+	}
+	return AccessToken{UserID: id, Role: role, Scopes: scopes, Version: claimsStruct.Version}, nil
+}
+
+// MakeChallengeJWT issues the short-lived token that carries a login from the
+// password step to the second-factor step.
+func MakeChallengeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeMFAChallenge),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+	return token.SignedString([]byte(tokenSecret))
+}
+
+func ValidateChallengeJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&jwt.RegisteredClaims{},
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -69,10 +218,14 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(TokenTypeMFAChallenge) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
+	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
@@ -102,6 +255,23 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// MakeOneTimeToken returns a random token for single-use links sent by email.
+func MakeOneTimeToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// HashToken returns the SHA-256 digest of a token. Tokens that only ever need
+// to be compared are stored hashed so a database leak doesn't expose them.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
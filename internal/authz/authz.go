@@ -0,0 +1,173 @@
+package authz
+
+import (
+	"context"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated caller a policy is evaluated for.
+type Principal struct {
+	UserID uuid.UUID
+	Role   auth.Role
+	// Scopes restricts what the caller's token may be used for. Nil means
+	// unrestricted.
+	Scopes []auth.Scope
+}
+
+// HasScope reports whether the caller's token may be used for scope.
+func (p Principal) HasScope(scope auth.Scope) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// HasRole reports whether p has at least the given role. Admins have every
+// role and moderators also have the user role.
+func (p Principal) HasRole(role auth.Role) bool {
+	switch role {
+	case auth.RoleAdmin:
+		return p.IsAdmin()
+	case auth.RoleModerator:
+		return p.IsModerator()
+	}
+	return role == auth.RoleUser
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the authenticated principal.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+func (p Principal) IsAdmin() bool {
+	return p.Role == auth.RoleAdmin
+}
+
+func (p Principal) IsModerator() bool {
+	return p.Role == auth.RoleModerator || p.IsAdmin()
+}
+
+// VideoAccess is what a caller has been granted on a video besides being its
+// creator: a collaborator role on the video itself and a role in the
+// organization that owns it. Either is empty when not granted.
+type VideoAccess struct {
+	Collaborator database.CollaboratorRole
+	Organization database.OrganizationRole
+}
+
+func (a VideoAccess) orgManager() bool {
+	return a.Organization == database.OrganizationRoleAdmin || a.Organization == database.OrganizationRoleOwner
+}
+
+// CanViewVideo reports whether p may watch a video. Anyone may watch videos
+// that aren't private, including anonymous callers with a zero Principal.
+func CanViewVideo(p Principal, video database.Video, access VideoAccess) bool {
+	if video.Visibility == database.VisibilityUnlisted || video.Visibility == database.VisibilityPublic {
+		return true
+	}
+	if p.UserID == uuid.Nil {
+		return false
+	}
+	if video.UserID == p.UserID || p.IsModerator() {
+		return true
+	}
+	return access.Collaborator.Valid() || access.Organization.Valid()
+}
+
+// CanEditVideo reports whether p may change a video's metadata or media.
+func CanEditVideo(p Principal, video database.Video, access VideoAccess) bool {
+	if video.UserID == p.UserID || p.IsAdmin() || access.orgManager() {
+		return true
+	}
+	return access.Collaborator == database.CollaboratorEditor || access.Collaborator == database.CollaboratorOwner
+}
+
+// CanDeleteVideo reports whether p may delete a video. Moderators can take
+// down any video but can't edit it.
+func CanDeleteVideo(p Principal, video database.Video, access VideoAccess) bool {
+	if video.UserID == p.UserID || p.IsModerator() || access.orgManager() {
+		return true
+	}
+	return access.Collaborator == database.CollaboratorOwner
+}
+
+func CanManageCollaborators(p Principal, video database.Video, access VideoAccess) bool {
+	if video.UserID == p.UserID || p.IsAdmin() || access.orgManager() {
+		return true
+	}
+	return access.Collaborator == database.CollaboratorOwner
+}
+
+// CanCreateOrganizationVideo reports whether p may add videos to an
+// organization they have the given role in.
+func CanCreateOrganizationVideo(p Principal, role database.OrganizationRole) bool {
+	return role.Valid()
+}
+
+func CanViewOrganization(p Principal, role database.OrganizationRole) bool {
+	return role.Valid() || p.IsAdmin()
+}
+
+func CanManageOrganizationMembers(p Principal, role database.OrganizationRole) bool {
+	return role == database.OrganizationRoleAdmin || role == database.OrganizationRoleOwner || p.IsAdmin()
+}
+
+// CanGrantOrganizationRole reports whether p may give someone the target role.
+// Only owners can create other owners.
+func CanGrantOrganizationRole(p Principal, role, target database.OrganizationRole) bool {
+	if target == database.OrganizationRoleOwner {
+		return role == database.OrganizationRoleOwner || p.IsAdmin()
+	}
+	return CanManageOrganizationMembers(p, role)
+}
+
+// CanManageOrganizationWebhooks reports whether p may register webhooks for
+// an organization, which are sent events for all its videos.
+func CanManageOrganizationWebhooks(p Principal, role database.OrganizationRole) bool {
+	return CanManageOrganizationMembers(p, role)
+}
+
+// CanManageWebhook reports whether p may see, change or delete a webhook
+// and its deliveries. role is p's role in the webhook's organization, if it
+// has one.
+func CanManageWebhook(p Principal, webhook database.Webhook, role database.OrganizationRole) bool {
+	if webhook.OrganizationID != nil {
+		return CanManageOrganizationWebhooks(p, role)
+	}
+	return webhook.UserID == p.UserID || p.IsAdmin()
+}
+
+// CanSetOrganizationQuota reports whether p may change an organization's
+// quotas. Quotas are a platform limit, so only admins set them.
+func CanSetOrganizationQuota(p Principal) bool {
+	return p.IsAdmin()
+}
+
+func CanListAllVideos(p Principal) bool {
+	return p.IsModerator()
+}
+
+func CanManageUsers(p Principal) bool {
+	return p.IsAdmin()
+}
+
+// CanImportVideos reports whether p may bulk import videos from the
+// server's disk into any user's workspace.
+func CanImportVideos(p Principal) bool {
+	return p.IsAdmin()
+}
+
+// CanDisableUser reports whether p may disable target. Admins can't lock
+// themselves out.
+func CanDisableUser(p Principal, target database.User) bool {
+	return p.IsAdmin() && target.ID != p.UserID
+}
@@ -0,0 +1,256 @@
+// Package config loads the server's configuration from defaults, an
+// optional YAML or TOML file, environment variables and command-line flags,
+// each overriding the one before, and checks it all at once.
+//
+// Every setting has a key, the dotted path of its config tags, such as
+// storage.s3.bucket. That's its name in the file and as a flag
+// (-storage.s3.bucket). Most also have an environment variable, named by
+// the env tag.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+)
+
+type Config struct {
+	// Platform is dev, which enables the reset endpoint and mock SSO, or
+	// anything else.
+	Platform  string          `config:"platform" env:"PLATFORM" usage:"deployment platform; dev enables dev-only endpoints"`
+	Server    ServerConfig    `config:"server"`
+	Database  DatabaseConfig  `config:"database"`
+	Auth      AuthConfig      `config:"auth"`
+	Storage   StorageConfig   `config:"storage"`
+	Import    ImportConfig    `config:"import"`
+	Mail      MailConfig      `config:"mail"`
+	RateLimit RateLimitConfig `config:"rate_limit"`
+	OIDC      OIDCConfig      `config:"oidc"`
+	Log       LogConfig       `config:"log"`
+	Tracing   TracingConfig   `config:"tracing"`
+}
+
+type ServerConfig struct {
+	Port string `config:"port" env:"PORT" usage:"port to listen on"`
+	// PublicURL is the base URL used in links sent by email. It defaults
+	// to http://localhost:<port>.
+	PublicURL    string `config:"public_url" env:"PUBLIC_URL" usage:"base URL used in links sent by email"`
+	FilepathRoot string `config:"filepath_root" env:"FILEPATH_ROOT" usage:"directory the web app is served from"`
+	// TrustProxyHeaders makes rate limiting key on X-Forwarded-For; set it
+	// behind a reverse proxy.
+	TrustProxyHeaders bool          `config:"trust_proxy_headers" env:"TRUST_PROXY_HEADERS" usage:"rate limit by X-Forwarded-For"`
+	ShutdownTimeout   time.Duration `config:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long requests and jobs get to finish on shutdown"`
+}
+
+type DatabaseConfig struct {
+	Path string `config:"path" env:"DB_PATH" usage:"SQLite database file"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `config:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"key that signs access tokens"`
+	// AdminEmail's account is promoted to the admin role on startup.
+	AdminEmail string `config:"admin_email" env:"ADMIN_EMAIL" usage:"account promoted to admin on startup"`
+}
+
+// Video store backends.
+const (
+	VideoStoreS3    = "s3"
+	VideoStoreLocal = "local"
+)
+
+type StorageConfig struct {
+	AssetsRoot string `config:"assets_root" env:"ASSETS_ROOT" usage:"directory thumbnails, and local videos, are kept and served from"`
+	// ExportsRoot holds data export archives. It must not be served.
+	ExportsRoot string `config:"exports_root" env:"EXPORTS_ROOT" usage:"directory data export archives are kept in"`
+	// Videos is where videos are kept: s3, or local, under AssetsRoot.
+	Videos string   `config:"videos" env:"VIDEO_STORE" usage:"video store: s3 or local"`
+	S3     S3Config `config:"s3"`
+}
+
+// S3Config is only used, and required, when videos are kept in S3.
+type S3Config struct {
+	Bucket string `config:"bucket" env:"S3_BUCKET" usage:"S3 bucket videos are kept in"`
+	Region string `config:"region" env:"S3_REGION" usage:"region of the S3 bucket"`
+	// CloudFrontURL is the distribution videos are served from.
+	CloudFrontURL string `config:"cloudfront_url" env:"S3_CF_DISTRO" usage:"CloudFront distribution URL videos are served from"`
+}
+
+// ImportConfig limits what the admin import endpoint can read. The import
+// command can read any directory.
+type ImportConfig struct {
+	// Root is the directory imports through the API are confined to; they
+	// are off when it's empty.
+	Root string `config:"root" env:"IMPORT_ROOT" usage:"directory the admin import endpoint may import from; off when empty"`
+}
+
+// Mail backends.
+const (
+	MailerLog  = "log"
+	MailerFile = "file"
+	MailerSMTP = "smtp"
+)
+
+type MailConfig struct {
+	// Backend is log, which logs messages, file, which writes them to Dir,
+	// or smtp.
+	Backend string     `config:"backend" env:"MAILER" usage:"mail backend: log, file or smtp"`
+	From    string     `config:"from" env:"MAIL_FROM" usage:"sender of outgoing email"`
+	Dir     string     `config:"dir" env:"MAIL_DIR" usage:"directory the file mailer writes to"`
+	SMTP    SMTPConfig `config:"smtp"`
+}
+
+// SMTPConfig is only used when the mail backend is smtp.
+type SMTPConfig struct {
+	Host     string `config:"host" env:"SMTP_HOST" usage:"SMTP relay host"`
+	Port     int    `config:"port" env:"SMTP_PORT" usage:"SMTP relay port"`
+	Username string `config:"username" env:"SMTP_USERNAME" usage:"SMTP username"`
+	Password string `config:"password" env:"SMTP_PASSWORD" secret:"true" usage:"SMTP password"`
+}
+
+// Rate limit stores.
+const (
+	RateLimitMemory = "memory"
+	RateLimitSQLite = "sqlite"
+)
+
+type RateLimitConfig struct {
+	Store string `config:"store" env:"RATE_LIMIT_STORE" usage:"rate limit store: memory or sqlite"`
+	// DBPath defaults to the main database.
+	DBPath string `config:"db_path" env:"RATE_LIMIT_DB_PATH" usage:"SQLite file for the sqlite rate limit store"`
+}
+
+// OIDCMockIssuer serves a mock OpenID provider from the server itself, in
+// dev only.
+const OIDCMockIssuer = "mock"
+
+// OIDCConfig configures single sign-on, which is off unless Issuer is set.
+type OIDCConfig struct {
+	Issuer       string `config:"issuer" env:"OIDC_ISSUER" usage:"OpenID Connect issuer URL, or mock in dev"`
+	ClientID     string `config:"client_id" env:"OIDC_CLIENT_ID" usage:"OpenID Connect client ID"`
+	ClientSecret string `config:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" usage:"OpenID Connect client secret"`
+	// RedirectURL defaults to <public_url>/api/auth/oidc/callback.
+	RedirectURL string `config:"redirect_url" env:"OIDC_REDIRECT_URL" usage:"OpenID Connect redirect URL"`
+}
+
+type LogConfig struct {
+	Level string `config:"level" env:"LOG_LEVEL" usage:"log level: debug, info, warn or error"`
+}
+
+type TracingConfig struct {
+	Exporter string `config:"exporter" env:"OTEL_TRACES_EXPORTER" usage:"trace exporter: none, otlp, console or file"`
+	File     string `config:"file" env:"OTEL_TRACES_FILE" usage:"file the file trace exporter writes to"`
+}
+
+// Default returns the configuration used for anything not set elsewhere.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            "8091",
+			FilepathRoot:    "./app",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Storage: StorageConfig{
+			AssetsRoot:  "./assets",
+			ExportsRoot: "./exports",
+			Videos:      VideoStoreS3,
+		},
+		Mail: MailConfig{
+			Backend: MailerLog,
+			From:    "Tubely <no-reply@localhost>",
+			Dir:     "./mail",
+			SMTP:    SMTPConfig{Port: 587},
+		},
+		RateLimit: RateLimitConfig{Store: RateLimitMemory},
+		Log:       LogConfig{Level: "info"},
+		Tracing:   TracingConfig{Exporter: tracing.ExporterNone},
+	}
+}
+
+// fillDerived sets defaults that depend on other settings.
+func (c *Config) fillDerived() {
+	if c.Server.PublicURL == "" {
+		c.Server.PublicURL = "http://localhost:" + c.Server.Port
+	}
+	c.Server.PublicURL = strings.TrimRight(c.Server.PublicURL, "/")
+	if c.RateLimit.DBPath == "" {
+		c.RateLimit.DBPath = c.Database.Path
+	}
+	if c.OIDC.Issuer != "" && c.OIDC.RedirectURL == "" {
+		c.OIDC.RedirectURL = c.Server.PublicURL + "/api/auth/oidc/callback"
+	}
+}
+
+// validate reports every problem with the configuration. It runs before
+// fillDerived, so settings that are derived when empty may be empty.
+func (c *Config) validate() Errors {
+	var errs Errors
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, &Error{Key: key, Env: envFor(key), Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	required := func(value, key string) {
+		check(value != "", key, "is required")
+	}
+	oneOf := func(value, key string, choices ...string) {
+		for _, choice := range choices {
+			if value == choice {
+				return
+			}
+		}
+		check(false, key, "must be one of %v, not %q", choices, value)
+	}
+
+	required(c.Platform, "platform")
+	required(c.Database.Path, "database.path")
+	required(c.Auth.JWTSecret, "auth.jwt_secret")
+	required(c.Server.FilepathRoot, "server.filepath_root")
+	required(c.Storage.AssetsRoot, "storage.assets_root")
+	required(c.Storage.ExportsRoot, "storage.exports_root")
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port", "must be a port number, not %q", c.Server.Port)
+	if c.Server.PublicURL != "" {
+		u, err := url.Parse(c.Server.PublicURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "server.public_url", "must be an http or https URL, not %q", c.Server.PublicURL)
+	}
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	oneOf(c.Storage.Videos, "storage.videos", VideoStoreS3, VideoStoreLocal)
+	if c.Storage.Videos == VideoStoreS3 {
+		required(c.Storage.S3.Bucket, "storage.s3.bucket")
+		required(c.Storage.S3.Region, "storage.s3.region")
+		required(c.Storage.S3.CloudFrontURL, "storage.s3.cloudfront_url")
+	}
+
+	oneOf(c.Mail.Backend, "mail.backend", MailerLog, MailerFile, MailerSMTP)
+	switch c.Mail.Backend {
+	case MailerFile:
+		required(c.Mail.Dir, "mail.dir")
+	case MailerSMTP:
+		required(c.Mail.SMTP.Host, "mail.smtp.host")
+		check(c.Mail.SMTP.Port > 0 && c.Mail.SMTP.Port < 65536, "mail.smtp.port", "must be a port number, not %d", c.Mail.SMTP.Port)
+	}
+
+	oneOf(c.RateLimit.Store, "rate_limit.store", RateLimitMemory, RateLimitSQLite)
+
+	if c.OIDC.Issuer == OIDCMockIssuer {
+		check(c.Platform == "dev", "oidc.issuer", "may only be mock in dev")
+	} else if c.OIDC.Issuer != "" {
+		required(c.OIDC.ClientID, "oidc.client_id")
+	}
+
+	_, err = logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level", "must be debug, info, warn or error, not %q", c.Log.Level)
+
+	oneOf(c.Tracing.Exporter, "tracing.exporter", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterConsole, tracing.ExporterFile)
+	if c.Tracing.Exporter == tracing.ExporterFile {
+		required(c.Tracing.File, "tracing.file")
+	}
+	return errs
+}
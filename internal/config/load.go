@@ -0,0 +1,348 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the configuration file when the -config flag isn't given.
+const FileEnv = "CONFIG_FILE"
+
+// Error is a problem with one setting.
+type Error struct {
+	Key     string
+	Env     string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Env != "" {
+		return fmt.Sprintf("%s (%s) %s", e.Key, e.Env, e.Message)
+	}
+	return fmt.Sprintf("%s %s", e.Key, e.Message)
+}
+
+// Errors lists every problem found while loading the configuration, so
+// they can all be fixed at once.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// setting is one leaf of Config, found by walking its config tags.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	index  []int
+}
+
+// settings lists every setting of Config in declaration order.
+var settings = func() []setting {
+	var all []setting
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Tag.Get("config")
+			if name == "" {
+				continue
+			}
+			fieldIndex := append(append([]int(nil), index...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, prefix+name+".", fieldIndex)
+				continue
+			}
+			all = append(all, setting{
+				key:    prefix + name,
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				index:  fieldIndex,
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	return all
+}()
+
+func lookupSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+func envFor(key string) string {
+	s, _ := lookupSetting(key)
+	return s.env
+}
+
+// set parses value into the setting's field of c.
+func (s setting) set(c *Config, value string) error {
+	field := reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s, not %q", value)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, not %q", value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a whole number, not %q", value)
+		}
+		field.SetInt(int64(n))
+	default:
+		panic(fmt.Sprintf("config: unsupported type %s for %s", field.Type(), s.key))
+	}
+	return nil
+}
+
+// get formats the setting's field of c as it would be written.
+func (s setting) get(c *Config) string {
+	field := reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+	if d, ok := field.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// Loader loads the configuration once its flags have been parsed.
+type Loader struct {
+	file  string
+	flags map[string]string
+	// LookupEnv reads environment variables; it's os.LookupEnv unless
+	// replaced.
+	LookupEnv func(string) (string, bool)
+}
+
+// NewLoader registers -config and a flag for every setting on fs. Flag
+// values are only checked by Load, so every problem is reported together.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		flags:     map[string]string{},
+		LookupEnv: os.LookupEnv,
+	}
+	fs.StringVar(&l.file, "config", "", "configuration file, .yaml, .yml or .toml (env "+FileEnv+")")
+	for _, s := range settings {
+		usage := s.usage
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		fs.Func(s.key, usage, func(value string) error {
+			l.flags[s.key] = value
+			return nil
+		})
+	}
+	return l
+}
+
+// IsFlag reports whether NewLoader registers a flag named name.
+func IsFlag(name string) bool {
+	if name == "config" {
+		return true
+	}
+	_, ok := lookupSetting(name)
+	return ok
+}
+
+// Load builds the configuration from the defaults, then the file, then the
+// environment, then flags. An empty environment variable counts as unset.
+// If anything is wrong, it returns Errors listing every problem.
+func (l *Loader) Load() (*Config, error) {
+	c := Default()
+	var errs Errors
+	setFrom := func(s setting, value, source string) {
+		err := s.set(&c, value)
+		if err != nil {
+			errs = append(errs, &Error{Key: s.key, Env: s.env, Message: source + " " + err.Error()})
+		}
+	}
+
+	file := l.file
+	if file == "" {
+		file, _ = l.LookupEnv(FileEnv)
+	}
+	if file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return nil, Errors{&Error{Key: "config", Env: FileEnv, Message: err.Error()}}
+		}
+		for _, key := range sortedKeys(values) {
+			s, ok := lookupSetting(key)
+			if !ok {
+				errs = append(errs, &Error{Key: key, Message: "in " + file + " is not a setting"})
+				continue
+			}
+			setFrom(s, values[key], "in "+file)
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if value, ok := l.LookupEnv(s.env); ok && value != "" {
+			setFrom(s, value, "from the environment")
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := l.flags[s.key]; ok {
+			setFrom(s, value, "flag")
+		}
+	}
+
+	// A setting that couldn't be parsed kept its earlier value, so
+	// anything validation says about it would only confuse.
+	unparsed := map[string]bool{}
+	for _, err := range errs {
+		unparsed[err.(*Error).Key] = true
+	}
+	for _, err := range c.validate() {
+		if !unparsed[err.(*Error).Key] {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	c.fillDerived()
+	return &c, nil
+}
+
+// readFile reads a YAML or TOML file, chosen by its extension, into a map
+// from setting keys to values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read configuration file: %w", err)
+	}
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("configuration file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", path, err)
+	}
+	values := map[string]string{}
+	flatten(doc, "", values)
+	return values, nil
+}
+
+// flatten turns nested sections into dotted keys. Scalars are formatted as
+// they'd appear in an environment variable, so every source is parsed the
+// same way.
+func flatten(doc map[string]any, prefix string, values map[string]string) {
+	for name, value := range doc {
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(v, prefix+name+".", values)
+		case nil:
+		default:
+			values[prefix+name] = fmt.Sprint(v)
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// redacted replaces secrets that are set.
+const redacted = "REDACTED"
+
+// WriteYAML writes the configuration as a YAML file Load would accept, with
+// secrets redacted.
+func (c *Config) WriteYAML(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range settings {
+		value := s.get(c)
+		if s.secret && value != "" {
+			value = redacted
+		}
+		parent := root
+		parts := strings.Split(s.key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			parent = section(parent, part)
+		}
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]},
+			scalar(c, s, value),
+		)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(root)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// section returns the mapping named name in parent, adding it if need be.
+func section(parent *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == name {
+			return parent.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, child)
+	return child
+}
+
+// scalar makes a node for a value, quoting strings so ones that look like
+// numbers or booleans stay strings.
+func scalar(c *Config, s setting, value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	switch reflect.ValueOf(c).Elem().FieldByIndex(s.index).Kind() {
+	case reflect.Bool:
+		node.Tag = "!!bool"
+	case reflect.Int:
+		node.Tag = "!!int"
+	default:
+		node.Tag = "!!str"
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
@@ -0,0 +1,304 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// baseEnv is the least environment a configuration loads with.
+var baseEnv = map[string]string{
+	"PLATFORM":    "dev",
+	"DB_PATH":     "tubely.db",
+	"JWT_SECRET":  "jwt-secret",
+	"VIDEO_STORE": VideoStoreLocal,
+}
+
+// load loads the configuration from env on top of baseEnv, and from the
+// command line args.
+func load(t *testing.T, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("tubely", flag.ContinueOnError)
+	l := NewLoader(fs)
+	l.LookupEnv = func(name string) (string, bool) {
+		if value, ok := env[name]; ok {
+			return value, true
+		}
+		value, ok := baseEnv[name]
+		return value, ok
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.Load()
+}
+
+// writeConfigFile writes a configuration file named name and returns its
+// path.
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// errorKeys returns the keys err reports problems with.
+func errorKeys(t *testing.T, err error) []string {
+	t.Helper()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want config.Errors", err)
+	}
+	var keys []string
+	for _, err := range errs {
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("got error %v, want a *config.Error", err)
+		}
+		keys = append(keys, e.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"tubely.yaml", `
+server:
+  port: 9000
+  shutdown_timeout: 10s
+  trust_proxy_headers: true
+mail:
+  backend: smtp
+  smtp:
+    host: smtp.example.com
+    port: 2525
+`},
+		{"tubely.yml", `
+server: {port: "9000", shutdown_timeout: 10s, trust_proxy_headers: true}
+mail: {backend: smtp, smtp: {host: smtp.example.com, port: 2525}}
+`},
+		{"tubely.toml", `
+[server]
+port = 9000
+shutdown_timeout = "10s"
+trust_proxy_headers = true
+
+[mail]
+backend = "smtp"
+
+[mail.smtp]
+host = "smtp.example.com"
+port = 2525
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.name, tt.contents)
+			c, err := load(t, nil, "-config", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.Port != "9000" || c.Server.ShutdownTimeout != 10*time.Second || !c.Server.TrustProxyHeaders {
+				t.Errorf("got server %+v, want it from the file", c.Server)
+			}
+			if c.Mail.Backend != MailerSMTP || c.Mail.SMTP.Host != "smtp.example.com" || c.Mail.SMTP.Port != 2525 {
+				t.Errorf("got mail %+v, want it from the file", c.Mail)
+			}
+			// Settings the file leaves out keep their defaults, and derived
+			// ones follow what the file set.
+			if c.Storage.AssetsRoot != Default().Storage.AssetsRoot || c.Server.PublicURL != "http://localhost:9000" {
+				t.Errorf("got assets root %q and public URL %q, want the defaults", c.Storage.AssetsRoot, c.Server.PublicURL)
+			}
+		})
+	}
+
+	// The file can also be named by the environment.
+	path := writeConfigFile(t, "tubely.yaml", "server:\n  port: 9000\n")
+	c, err := load(t, map[string]string{FileEnv: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != "9000" {
+		t.Errorf("got port %q from %s, want 9000", c.Server.Port, FileEnv)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "tubely.yaml", "server:\n  port: 9001\n")
+	tests := []struct {
+		name string
+		file bool
+		env  bool
+		flag bool
+		want string
+	}{
+		{"default", false, false, false, "8091"},
+		{"file over default", true, false, false, "9001"},
+		{"env over file", true, true, false, "9002"},
+		{"flag over env", true, true, true, "9003"},
+		{"flag over file", true, false, true, "9003"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			var args []string
+			if tt.file {
+				args = append(args, "-config", path)
+			}
+			if tt.env {
+				env["PORT"] = "9002"
+			}
+			if tt.flag {
+				args = append(args, "-server.port", "9003")
+			}
+			c, err := load(t, env, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.Port != tt.want {
+				t.Fatalf("got port %q, want %q", c.Server.Port, tt.want)
+			}
+		})
+	}
+
+	// An empty environment variable counts as unset.
+	c, err := load(t, map[string]string{"PORT": ""}, "-config", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != "9001" {
+		t.Fatalf("got port %q with PORT empty, want the file's", c.Server.Port)
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	path := writeConfigFile(t, "tubely.yaml", `
+server:
+  shutdown_timeout: soon
+  listen: ":8080"
+mail:
+  backend: pigeon
+`)
+	_, err := load(t, map[string]string{"JWT_SECRET": "", "SMTP_PORT": "lots"},
+		"-config", path, "-server.port", "http", "-log.level", "loud")
+	got := errorKeys(t, err)
+	want := []string{
+		"auth.jwt_secret",
+		"log.level",
+		"mail.backend",
+		"mail.smtp.port",
+		"server.listen",
+		"server.port",
+		"server.shutdown_timeout",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got errors for %v, want %v", got, want)
+	}
+	// Values that don't parse say where they came from, and aren't
+	// reported again by validation.
+	for _, msg := range []string{
+		"server.shutdown_timeout (SHUTDOWN_TIMEOUT) in " + path + " must be a duration",
+		"mail.smtp.port (SMTP_PORT) from the environment must be a whole number",
+		`server.port (PORT) must be a port number, not "http"`,
+		"server.listen in " + path + " is not a setting",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error %q doesn't mention %q", err, msg)
+		}
+	}
+
+	// A file that can't be read is reported on its own.
+	_, err = load(t, nil, "-config", filepath.Join(t.TempDir(), "missing.yaml"))
+	if got := errorKeys(t, err); !slices.Equal(got, []string{"config"}) {
+		t.Fatalf("missing file: got errors for %v, want config", got)
+	}
+}
+
+func TestLoadS3OnlyRequiredForS3Videos(t *testing.T) {
+	_, err := load(t, map[string]string{"VIDEO_STORE": VideoStoreS3})
+	want := []string{"storage.s3.bucket", "storage.s3.cloudfront_url", "storage.s3.region"}
+	if got := errorKeys(t, err); !slices.Equal(got, want) {
+		t.Fatalf("got errors for %v, want %v", got, want)
+	}
+
+	c, err := load(t, map[string]string{
+		"VIDEO_STORE":  VideoStoreS3,
+		"S3_BUCKET":    "tubely",
+		"S3_REGION":    "us-east-1",
+		"S3_CF_DISTRO": "https://cdn.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Storage.S3.Bucket != "tubely" {
+		t.Fatalf("got bucket %q, want tubely", c.Storage.S3.Bucket)
+	}
+
+	// Local videos don't need S3 settings.
+	_, err = load(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteYAMLRedactsSecrets(t *testing.T) {
+	c, err := load(t, map[string]string{
+		"JWT_SECRET":    "jwt-secret-value",
+		"SMTP_PASSWORD": "smtp-password-value",
+		"ADMIN_EMAIL":   "admin@example.com",
+		"PORT":          "9000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = c.WriteYAML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"jwt-secret-value", "smtp-password-value"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("written configuration has the secret %q:\n%s", secret, out)
+		}
+	}
+	for _, line := range []string{
+		`jwt_secret: "` + redacted + `"`,
+		`password: "` + redacted + `"`,
+		// Secrets that aren't set are left empty, not redacted.
+		`client_secret: ""`,
+		`admin_email: "admin@example.com"`,
+		`port: "9000"`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("written configuration doesn't have %s:\n%s", line, out)
+		}
+	}
+
+	// What's written loads back to the same configuration, but for the
+	// secrets.
+	path := writeConfigFile(t, "tubely.yaml", out)
+	loaded, err := load(t, nil, "-config", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Auth.JWTSecret = c.Auth.JWTSecret
+	loaded.Mail.SMTP.Password = c.Mail.SMTP.Password
+	if *loaded != *c {
+		t.Fatalf("loaded %+v, want %+v", *loaded, *c)
+	}
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryHook is called after every statement the client runs, with the
+// context the client was bound to by WithContext.
+type QueryHook func(ctx context.Context, query Query)
+
+// Query describes a statement that ran. Name is the Client method that ran
+// it, such as "GetVideo", so it is safe to use as a metric label. Err is nil
+// when a single-row query found no rows.
+type Query struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// pool is the database handle shared by every copy of a Client.
+type pool struct {
+	db *sql.DB
+
+	mu    sync.RWMutex
+	hooks []QueryHook
+}
+
+// conn runs statements on the pool under a context, timing each one and
+// reporting it to the hooks.
+type conn struct {
+	*pool
+	ctx context.Context
+}
+
+type tx struct {
+	conn conn
+	tx   *sql.Tx
+}
+
+// WithContext returns a copy of the client whose statements carry ctx, so
+// they can be traced as part of the request or job that ran them.
+// Cancelling ctx doesn't interrupt statements; a write that has started
+// always finishes.
+func (c Client) WithContext(ctx context.Context) Client {
+	c.db.ctx = context.WithoutCancel(ctx)
+	return c
+}
+
+// OnQuery registers a hook called after every statement.
+func (c Client) OnQuery(hook QueryHook) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.hooks = append(c.db.hooks, hook)
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := c.db.ExecContext(c.ctx, query, args...)
+	c.observe(start, err)
+	return result, err
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	c.observe(start, err)
+	return rows, err
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := c.db.QueryRowContext(c.ctx, query, args...)
+	c.observe(start, row.Err())
+	return row
+}
+
+func (c conn) Begin() (*tx, error) {
+	t, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{conn: c, tx: t}, nil
+}
+
+func (t *tx) Exec(query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.tx.ExecContext(t.conn.ctx, query, args...)
+	t.conn.observe(start, err)
+	return result, err
+}
+
+func (t *tx) QueryRow(query string, args ...any) *sql.Row {
+	start := time.Now()
+	row := t.tx.QueryRowContext(t.conn.ctx, query, args...)
+	t.conn.observe(start, row.Err())
+	return row
+}
+
+func (t *tx) Commit() error {
+	start := time.Now()
+	err := t.tx.Commit()
+	t.conn.observe(start, err)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (c conn) observe(start time.Time, err error) {
+	duration := time.Since(start)
+	c.mu.RLock()
+	hooks := c.hooks
+	c.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	query := Query{
+		Name:     callerName(),
+		Start:    start,
+		Duration: duration,
+		Err:      err,
+	}
+	for _, hook := range hooks {
+		hook(c.ctx, query)
+	}
+}
+
+// callerName finds the outermost function in this package on the stack,
+// which is the Client method the caller used.
+func callerName() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	name := "unknown"
+	for {
+		frame, more := frames.Next()
+		pkg, fn := splitFuncName(frame.Function)
+		if pkg != packagePath {
+			break
+		}
+		name = fn
+		if !more {
+			break
+		}
+	}
+	return name
+}
+
+// packagePath is this package's import path, as it appears in stack frames.
+var packagePath = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	pkg, _ := splitFuncName(runtime.FuncForPC(pc).Name())
+	return pkg
+}()
+
+// splitFuncName splits a frame's function name, such as
+// "example.com/app/internal/database.Client.GetVideo", into its package path
+// and the method or function name.
+func splitFuncName(full string) (string, string) {
+	slash := strings.LastIndex(full, "/")
+	dot := strings.Index(full[slash+1:], ".")
+	if dot < 0 {
+		return full, ""
+	}
+	pkg := full[:slash+1+dot]
+	parts := strings.Split(full[slash+1+dot+1:], ".")
+	// Skip closures, named like GetVideo.func1.
+	for len(parts) > 1 && strings.HasPrefix(parts[len(parts)-1], "func") {
+		parts = parts[:len(parts)-1]
+	}
+	return pkg, parts[len(parts)-1]
+}
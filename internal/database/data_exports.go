@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportStatusPending DataExportStatus = "pending"
+	DataExportStatusReady   DataExportStatus = "ready"
+	DataExportStatusFailed  DataExportStatus = "failed"
+	DataExportStatusExpired DataExportStatus = "expired"
+)
+
+// DataExport is a user's request for a copy of their data. The archive is
+// built in the background and kept in the export store until ExpiresAt.
+type DataExport struct {
+	ID          uuid.UUID        `json:"id"`
+	UserID      uuid.UUID        `json:"user_id"`
+	Status      DataExportStatus `json:"status"`
+	StorageKey  *string          `json:"-"`
+	SizeBytes   int64            `json:"size_bytes"`
+	Error       *string          `json:"error,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+}
+
+const dataExportColumns = `
+		id,
+		user_id,
+		status,
+		storage_key,
+		size_bytes,
+		error,
+		created_at,
+		completed_at,
+		expires_at`
+
+func scanDataExport(row rowScanner) (DataExport, error) {
+	var export DataExport
+	err := row.Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.StorageKey,
+		&export.SizeBytes,
+		&export.Error,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	return export, err
+}
+
+func (c Client) CreateDataExport(userID uuid.UUID) (DataExport, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO data_exports (id, user_id, status, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, id.String(), userID.String(), DataExportStatusPending)
+	if err != nil {
+		return DataExport{}, err
+	}
+	export, err := c.GetDataExport(id)
+	if err != nil {
+		return DataExport{}, err
+	}
+	return *export, nil
+}
+
+func (c Client) GetDataExport(id uuid.UUID) (*DataExport, error) {
+	query := `
+		SELECT` + dataExportColumns + `
+		FROM data_exports
+		WHERE id = ?
+	`
+	export, err := scanDataExport(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (c Client) GetUserDataExports(userID uuid.UUID) ([]DataExport, error) {
+	query := `
+		SELECT` + dataExportColumns + `
+		FROM data_exports
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (c Client) CompleteDataExport(id uuid.UUID, storageKey string, sizeBytes int64, expiresAt time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, storage_key = ?, size_bytes = ?, completed_at = CURRENT_TIMESTAMP, expires_at = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DataExportStatusReady, storageKey, sizeBytes, expiresAt.UTC(), id.String())
+	return err
+}
+
+func (c Client) FailDataExport(id uuid.UUID, message string) error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, error = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DataExportStatusFailed, message, id.String())
+	return err
+}
+
+func (c Client) ExpireDataExport(id uuid.UUID) error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, storage_key = NULL
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DataExportStatusExpired, id.String())
+	return err
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -8,7 +9,7 @@ import (
 )
 
 type Client struct {
-	db *sql.DB
+	db conn
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +17,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{conn{pool: &pool{db: db}, ctx: context.Background()}}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -25,6 +26,42 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+// Close closes the database once running statements finish.
+func (c Client) Close() error {
+	return c.db.db.Close()
+}
+
+// Ping checks that the database can be written to: it takes and releases
+// the write lock, so it fails if another process holds the file locked
+// until ctx is done.
+func (c Client) Ping(ctx context.Context) error {
+	conn, err := c.db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+// Version reports the version of the SQLite library in use.
+func (c Client) Version(ctx context.Context) (string, error) {
+	var version string
+	err := c.db.db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version)
+	return version, err
+}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist yet. It's safe to run while the server is using the database.
+func (c Client) Backup(ctx context.Context, path string) error {
+	_, err := c.db.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -72,18 +109,461 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	videoCollaboratorTable := `
+	CREATE TABLE IF NOT EXISTS video_collaborators (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoCollaboratorTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		max_videos INTEGER NOT NULL DEFAULT 0,
+		max_storage_bytes INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	organizationMemberTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (organization_id, user_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationMemberTable)
+	if err != nil {
+		return err
+	}
+
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+
+	userTOTPTable := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		enabled_at TIMESTAMP,
+		last_counter INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTOTPTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code_hash TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		PRIMARY KEY(user_id, code_hash),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	oidcLoginStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state_hash TEXT PRIMARY KEY,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcLoginStateTable)
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		kind TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 1,
+		run_at TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP,
+		last_error TEXT,
+		finished_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS jobs_due ON jobs(status, run_at);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	dataExportTable := `
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		storage_key TEXT,
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		created_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(dataExportTable)
+	if err != nil {
+		return err
+	}
+
+	importTable := `
+	CREATE TABLE IF NOT EXISTS imports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		dir TEXT NOT NULL,
+		status TEXT NOT NULL,
+		total INTEGER NOT NULL DEFAULT 0,
+		imported INTEGER NOT NULL DEFAULT 0,
+		skipped INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		failures TEXT NOT NULL DEFAULT '[]',
+		error TEXT,
+		finished_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(importTable)
+	if err != nil {
+		return err
+	}
+
+	importedFileTable := `
+	CREATE TABLE IF NOT EXISTS imported_files (
+		user_id TEXT NOT NULL,
+		sha256 TEXT NOT NULL,
+		import_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		completed_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, sha256),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(importedFileTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		organization_id TEXT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	webhookDeliveryTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		webhook_id TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER,
+		error TEXT,
+		duration_ms INTEGER,
+		last_attempt_at TIMESTAMP,
+		delivered_at TIMESTAMP,
+		redelivery_of TEXT,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created_at);
+	`
+	_, err = c.db.Exec(webhookDeliveryTable)
+	if err != nil {
+		return err
+	}
+
+	idempotencyKeyTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL DEFAULT '',
+		response_status INTEGER NOT NULL DEFAULT 0,
+		response_headers TEXT NOT NULL DEFAULT '{}',
+		response_body BLOB NOT NULL DEFAULT x'',
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(idempotencyKeyTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "disabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	// Accounts that predate email verification are treated as verified.
+	hasVerifiedAt, err := c.hasColumn("users", "verified_at")
+	if err != nil {
+		return err
+	}
+	if !hasVerifiedAt {
+		_, err = c.db.Exec("ALTER TABLE users ADD COLUMN verified_at TIMESTAMP")
+		if err != nil {
+			return err
+		}
+		_, err = c.db.Exec("UPDATE users SET verified_at = created_at")
+		if err != nil {
+			return err
+		}
+	}
+	err = c.addColumnIfMissing("users", "token_version", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("user_tokens", "new_email", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "organization_id", "TEXT REFERENCES organizations(id)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "size_bytes", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// New videos are private, but videos that predate visibility were
+	// readable by anyone, with public media, so they stay public.
+	hasVisibility, err := c.hasColumn("videos", "visibility")
+	if err != nil {
+		return err
+	}
+	if !hasVisibility {
+		_, err = c.db.Exec("ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'")
+		if err != nil {
+			return err
+		}
+		_, err = c.db.Exec("UPDATE videos SET visibility = 'public'")
+		if err != nil {
+			return err
+		}
+	}
+	err = c.addColumnIfMissing("videos", "tags", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("jobs", "request_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("jobs", "traceparent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	// Deliveries used to keep the start of the endpoint's response.
+	err = c.dropColumnIfExists("webhook_deliveries", "response_body")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so the table's current columns are checked first.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	exists, err := c.hasColumn(table, column)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// dropColumnIfExists removes a column from an existing table.
+func (c *Client) dropColumnIfExists(table, column string) error {
+	exists, err := c.hasColumn(table, column)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column))
+	return err
+}
+
+func (c *Client) hasColumn(table, column string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM video_collaborators"); err != nil {
+		return fmt.Errorf("failed to reset table video_collaborators: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_totp"); err != nil {
+		return fmt.Errorf("failed to reset table user_totp: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM data_exports"); err != nil {
+		return fmt.Errorf("failed to reset table data_exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		return fmt.Errorf("failed to reset table idempotency_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM imported_files"); err != nil {
+		return fmt.Errorf("failed to reset table imported_files: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM imports"); err != nil {
+		return fmt.Errorf("failed to reset table imports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_login_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_login_states: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey is a client-chosen key for a request that must happen at
+// most once, with the response it got so retries can be given the same one.
+// Until the request finishes, ResponseStatus is zero and Fingerprint is
+// empty.
+type IdempotencyKey struct {
+	UserID uuid.UUID
+	Key    string
+	// Fingerprint identifies what the request asked for, so reusing the key
+	// for a different request can be refused.
+	Fingerprint     string
+	ResponseStatus  int
+	ResponseHeaders map[string][]string
+	ResponseBody    []byte
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+}
+
+// Completed reports whether the request the key was used for has finished.
+func (k IdempotencyKey) Completed() bool {
+	return k.ResponseStatus != 0
+}
+
+// GetIdempotencyKey returns a user's key, or nil if it's unused or expired.
+func (c Client) GetIdempotencyKey(userID uuid.UUID, key string) (*IdempotencyKey, error) {
+	query := `
+		SELECT user_id, key, fingerprint, response_status, response_headers, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ? AND expires_at > ?
+	`
+	var k IdempotencyKey
+	var headers string
+	err := c.db.QueryRow(query, userID.String(), key, time.Now().UTC()).Scan(
+		&k.UserID,
+		&k.Key,
+		&k.Fingerprint,
+		&k.ResponseStatus,
+		&headers,
+		&k.ResponseBody,
+		&k.CreatedAt,
+		&k.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(headers), &k.ResponseHeaders)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ClaimIdempotencyKey reserves a key for a request that's starting, until
+// expiresAt. It returns false if the key is already in use, clearing out
+// expired keys while it's at it.
+func (c Client) ClaimIdempotencyKey(userID uuid.UUID, key string, expiresAt time.Time) (bool, error) {
+	_, err := c.db.Exec("DELETE FROM idempotency_keys WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, created_at, expires_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`
+	result, err := c.db.Exec(query, userID.String(), key, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// CompleteIdempotencyKey saves the fingerprint and response of the request a
+// claimed key was used for, and keeps them until k.ExpiresAt.
+func (c Client) CompleteIdempotencyKey(k IdempotencyKey) error {
+	headers, err := json.Marshal(k.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE idempotency_keys
+		SET fingerprint = ?, response_status = ?, response_headers = ?, response_body = ?, expires_at = ?
+		WHERE user_id = ? AND key = ?
+	`
+	_, err = c.db.Exec(query, k.Fingerprint, k.ResponseStatus, string(headers), k.ResponseBody, k.ExpiresAt.UTC(),
+		k.UserID.String(), k.Key)
+	return err
+}
+
+// ReleaseIdempotencyKey frees a claimed key, so the request can be retried.
+func (c Client) ReleaseIdempotencyKey(userID uuid.UUID, key string) error {
+	_, err := c.db.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", userID.String(), key)
+	return err
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ImportStatus string
+
+const (
+	ImportStatusQueued    ImportStatus = "queued"
+	ImportStatusRunning   ImportStatus = "running"
+	ImportStatusSucceeded ImportStatus = "succeeded"
+	ImportStatusFailed    ImportStatus = "failed"
+)
+
+// Import is a bulk import of a directory of videos into one user's
+// workspace. Its counts are saved as each file is processed, so it doubles
+// as a progress report.
+type Import struct {
+	ID         uuid.UUID       `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Dir        string          `json:"dir"`
+	Status     ImportStatus    `json:"status"`
+	Total      int             `json:"total"`
+	Imported   int             `json:"imported"`
+	Skipped    int             `json:"skipped"`
+	Failed     int             `json:"failed"`
+	Failures   []ImportFailure `json:"failures"`
+	Error      *string         `json:"error"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+// ImportFailure is a file an import couldn't bring in, by its path within
+// the import's directory.
+type ImportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+const importColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		dir,
+		status,
+		total,
+		imported,
+		skipped,
+		failed,
+		failures,
+		error,
+		finished_at`
+
+func scanImport(row rowScanner) (Import, error) {
+	var imp Import
+	var failures string
+	err := row.Scan(
+		&imp.ID,
+		&imp.CreatedAt,
+		&imp.UpdatedAt,
+		&imp.UserID,
+		&imp.Dir,
+		&imp.Status,
+		&imp.Total,
+		&imp.Imported,
+		&imp.Skipped,
+		&imp.Failed,
+		&failures,
+		&imp.Error,
+		&imp.FinishedAt,
+	)
+	if err != nil {
+		return Import{}, err
+	}
+	err = json.Unmarshal([]byte(failures), &imp.Failures)
+	if err != nil {
+		return Import{}, err
+	}
+	return imp, nil
+}
+
+func (c Client) CreateImport(userID uuid.UUID, dir string) (Import, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO imports (id, created_at, updated_at, user_id, dir, status)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), userID.String(), dir, ImportStatusQueued)
+	if err != nil {
+		return Import{}, err
+	}
+	imp, err := c.GetImport(id)
+	if err != nil {
+		return Import{}, err
+	}
+	return *imp, nil
+}
+
+func (c Client) GetImport(id uuid.UUID) (*Import, error) {
+	query := `
+		SELECT` + importColumns + `
+		FROM imports
+		WHERE id = ?
+	`
+	imp, err := scanImport(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &imp, nil
+}
+
+func (c Client) GetImports() ([]Import, error) {
+	query := `
+		SELECT` + importColumns + `
+		FROM imports
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []Import{}
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
+
+// UpdateImport saves an import's status, counts, failures and finish time,
+// and sets its UpdatedAt to now.
+func (c Client) UpdateImport(imp *Import) error {
+	failures := imp.Failures
+	if failures == nil {
+		failures = []ImportFailure{}
+	}
+	failuresJSON, err := json.Marshal(failures)
+	if err != nil {
+		return err
+	}
+	imp.UpdatedAt = time.Now().UTC()
+	query := `
+		UPDATE imports
+		SET status = ?, total = ?, imported = ?, skipped = ?, failed = ?, failures = ?,
+		    error = ?, finished_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err = c.db.Exec(query, imp.Status, imp.Total, imp.Imported, imp.Skipped, imp.Failed,
+		string(failuresJSON), imp.Error, imp.FinishedAt, imp.UpdatedAt, imp.ID.String())
+	return err
+}
+
+// ImportedFile records that a file was imported for a user, keyed by the
+// SHA-256 of its contents, so importing it again resumes or skips it instead
+// of making a duplicate. CompletedAt is nil until the video's media is
+// stored.
+type ImportedFile struct {
+	UserID uuid.UUID
+	SHA256 string
+	// ImportID is the import that last dealt with the file.
+	ImportID    uuid.UUID
+	Path        string
+	VideoID     uuid.UUID
+	CompletedAt *time.Time
+}
+
+func (c Client) GetImportedFile(userID uuid.UUID, sha256 string) (*ImportedFile, error) {
+	query := `
+		SELECT user_id, sha256, import_id, path, video_id, completed_at
+		FROM imported_files
+		WHERE user_id = ? AND sha256 = ?
+	`
+	var file ImportedFile
+	err := c.db.QueryRow(query, userID.String(), sha256).Scan(
+		&file.UserID,
+		&file.SHA256,
+		&file.ImportID,
+		&file.Path,
+		&file.VideoID,
+		&file.CompletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SaveImportedFile records an imported file, replacing any earlier record
+// of the same contents for the same user.
+func (c Client) SaveImportedFile(file ImportedFile) error {
+	query := `
+		INSERT INTO imported_files (user_id, sha256, import_id, path, video_id, completed_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, sha256) DO UPDATE SET
+			import_id = excluded.import_id,
+			path = excluded.path,
+			video_id = excluded.video_id,
+			completed_at = excluded.completed_at,
+			updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, file.UserID.String(), file.SHA256, file.ImportID.String(),
+		file.Path, file.VideoID.String(), file.CompletedAt)
+	return err
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusSucceeded JobStatus = "succeeded"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+// Job is a unit of background work. Jobs are claimed with a lease; a job
+// whose lease runs out while still running is assumed abandoned by a crashed
+// worker and becomes claimable again.
+type Job struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      JobStatus       `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until"`
+	LastError   *string         `json:"last_error"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+	// RequestID is the ID of the request that queued the job, if any, so
+	// the job's logs can be traced back to it.
+	RequestID string `json:"request_id,omitempty"`
+	// TraceParent is the W3C trace context of the request that queued the
+	// job, if it was traced, so the job's spans can be linked to it.
+	TraceParent string `json:"-"`
+}
+
+type CreateJobParams struct {
+	Kind        string
+	Payload     json.RawMessage
+	MaxAttempts int
+	RunAt       time.Time
+	RequestID   string
+	TraceParent string
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		kind,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		locked_until,
+		last_error,
+		finished_at,
+		request_id,
+		traceparent`
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	var payload string
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.FinishedAt,
+		&job.RequestID,
+		&job.TraceParent,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	job.Payload = json.RawMessage(payload)
+	return job, nil
+}
+
+// CreateJob queues a job. It runs as soon as a worker is free, or at RunAt if
+// that's later.
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	if params.MaxAttempts <= 0 {
+		params.MaxAttempts = 1
+	}
+	if params.RunAt.IsZero() {
+		params.RunAt = time.Now()
+	}
+	if params.Payload == nil {
+		params.Payload = json.RawMessage("{}")
+	}
+
+	id := uuid.New()
+	query := `
+		INSERT INTO jobs (id, created_at, updated_at, kind, payload, status, max_attempts, run_at, request_id, traceparent)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), params.Kind, string(params.Payload), JobStatusQueued, params.MaxAttempts, params.RunAt.UTC(), params.RequestID, params.TraceParent)
+	if err != nil {
+		return Job{}, err
+	}
+	job, err := c.GetJob(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return *job, nil
+}
+
+func (c Client) GetJob(id uuid.UUID) (*Job, error) {
+	query := `
+		SELECT` + jobColumns + `
+		FROM jobs
+		WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimJob takes the next due job of one of the given kinds and leases it
+// for the given duration. It returns nil if nothing is due.
+func (c Client) ClaimJob(kinds []string, lease time.Duration) (*Job, error) {
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+	kindsJSON, err := json.Marshal(kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	query := `
+		UPDATE jobs
+		SET status = ?, attempts = attempts + 1, locked_until = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind IN (SELECT value FROM json_each(?))
+			AND (
+				(status = ? AND run_at <= ?)
+				OR (status = ? AND locked_until <= ?)
+			)
+			ORDER BY run_at
+			LIMIT 1
+		)
+		RETURNING` + jobColumns
+	job, err := scanJob(c.db.QueryRow(
+		query,
+		JobStatusRunning, now.Add(lease),
+		string(kindsJSON),
+		JobStatusQueued, now,
+		JobStatusRunning, now,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+		UPDATE jobs
+		SET status = ?, locked_until = NULL, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusSucceeded, id.String())
+	return err
+}
+
+// FailJob records a failed attempt. The job is queued again at retryAt, or
+// marked failed for good if retryAt is nil.
+func (c Client) FailJob(id uuid.UUID, message string, retryAt *time.Time) error {
+	if retryAt == nil {
+		query := `
+			UPDATE jobs
+			SET status = ?, last_error = ?, locked_until = NULL, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`
+		_, err := c.db.Exec(query, JobStatusFailed, message, id.String())
+		return err
+	}
+	query := `
+		UPDATE jobs
+		SET status = ?, last_error = ?, run_at = ?, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, message, retryAt.UTC(), id.String())
+	return err
+}
+
+// ReleaseJob hands a running job back to the queue without counting the
+// attempt, for when a worker stops before finishing it.
+func (c Client) ReleaseJob(id uuid.UUID) error {
+	query := `
+		UPDATE jobs
+		SET status = ?, attempts = MAX(attempts - 1, 0), locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ?
+	`
+	_, err := c.db.Exec(query, JobStatusQueued, id.String(), JobStatusRunning)
+	return err
+}
+
+// JobCount is how many jobs of a kind are in a state. State is a job status,
+// or "scheduled" for queued jobs that aren't due yet.
+type JobCount struct {
+	Kind  string
+	State string
+	Count int64
+}
+
+// CountActiveJobs counts the queued, scheduled and running jobs of each
+// kind.
+func (c Client) CountActiveJobs() ([]JobCount, error) {
+	query := `
+		SELECT kind, CASE WHEN status = ? AND run_at > ? THEN 'scheduled' ELSE status END AS state, COUNT(*)
+		FROM jobs
+		WHERE status IN (?, ?)
+		GROUP BY kind, state
+	`
+	rows, err := c.db.Query(query, JobStatusQueued, time.Now().UTC(), JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []JobCount{}
+	for rows.Next() {
+		var count JobCount
+		err := rows.Scan(&count.Kind, &count.State, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrganizationRole string
+
+const (
+	OrganizationRoleMember OrganizationRole = "member"
+	OrganizationRoleAdmin  OrganizationRole = "admin"
+	OrganizationRoleOwner  OrganizationRole = "owner"
+)
+
+func (r OrganizationRole) Valid() bool {
+	switch r {
+	case OrganizationRoleMember, OrganizationRoleAdmin, OrganizationRoleOwner:
+		return true
+	}
+	return false
+}
+
+// Organization is a workspace whose videos are shared by its members. A zero
+// quota means unlimited.
+type Organization struct {
+	ID              uuid.UUID `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Name            string    `json:"name"`
+	MaxVideos       int64     `json:"max_videos"`
+	MaxStorageBytes int64     `json:"max_storage_bytes"`
+}
+
+type OrganizationMembership struct {
+	Organization
+	Role OrganizationRole `json:"role"`
+}
+
+type OrganizationMember struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email     string    `json:"email"`
+	CreateOrganizationMemberParams
+}
+
+type CreateOrganizationMemberParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	UserID         uuid.UUID        `json:"user_id"`
+	Role           OrganizationRole `json:"role"`
+}
+
+type OrganizationUsage struct {
+	Videos       int64 `json:"videos"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// CreateOrganization creates an organization with ownerID as its owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO organizations (id, created_at, updated_at, name)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO organization_members (organization_id, user_id, role, created_at, updated_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, ownerID, OrganizationRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+
+	org, err := c.GetOrganization(id)
+	if err != nil {
+		return Organization{}, err
+	}
+	if org == nil {
+		return Organization{}, errors.New("organization not found after insert")
+	}
+	return *org, nil
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (*Organization, error) {
+	query := `
+	SELECT id, created_at, updated_at, name, max_videos, max_storage_bytes
+	FROM organizations
+	WHERE id = ?
+	`
+	var org Organization
+	err := c.db.QueryRow(query, id).Scan(
+		&org.ID,
+		&org.CreatedAt,
+		&org.UpdatedAt,
+		&org.Name,
+		&org.MaxVideos,
+		&org.MaxStorageBytes,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetUserOrganizations returns the organizations a user belongs to, with their
+// role in each.
+func (c Client) GetUserOrganizations(userID uuid.UUID) ([]OrganizationMembership, error) {
+	query := `
+	SELECT o.id, o.created_at, o.updated_at, o.name, o.max_videos, o.max_storage_bytes, m.role
+	FROM organizations o
+	JOIN organization_members m ON m.organization_id = o.id
+	WHERE m.user_id = ?
+	ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []OrganizationMembership{}
+	for rows.Next() {
+		var org OrganizationMembership
+		if err := rows.Scan(
+			&org.ID,
+			&org.CreatedAt,
+			&org.UpdatedAt,
+			&org.Name,
+			&org.MaxVideos,
+			&org.MaxStorageBytes,
+			&org.Role,
+		); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (c Client) SetOrganizationQuota(id uuid.UUID, maxVideos, maxStorageBytes int64) error {
+	query := `
+	UPDATE organizations
+	SET max_videos = ?, max_storage_bytes = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, maxVideos, maxStorageBytes, id)
+	return err
+}
+
+func (c Client) GetOrganizationUsage(id uuid.UUID) (OrganizationUsage, error) {
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(size_bytes), 0)
+	FROM videos
+	WHERE organization_id = ?
+	`
+	var usage OrganizationUsage
+	err := c.db.QueryRow(query, id).Scan(&usage.Videos, &usage.StorageBytes)
+	return usage, err
+}
+
+// UpsertOrganizationMember adds a member to an organization, or changes their
+// role if they already belong to it.
+func (c Client) UpsertOrganizationMember(params CreateOrganizationMemberParams) (OrganizationMember, error) {
+	query := `
+	INSERT INTO organization_members (
+		organization_id,
+		user_id,
+		role,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (organization_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, params.OrganizationID, params.UserID, params.Role)
+	if err != nil {
+		return OrganizationMember{}, err
+	}
+
+	member, err := c.GetOrganizationMember(params.OrganizationID, params.UserID)
+	if err != nil {
+		return OrganizationMember{}, err
+	}
+	if member == nil {
+		return OrganizationMember{}, errors.New("member not found after insert")
+	}
+	return *member, nil
+}
+
+func (c Client) GetOrganizationMember(orgID, userID uuid.UUID) (*OrganizationMember, error) {
+	query := `
+	SELECT m.organization_id, m.user_id, m.role, m.created_at, m.updated_at, u.email
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.organization_id = ? AND m.user_id = ?
+	`
+	var member OrganizationMember
+	err := c.db.QueryRow(query, orgID, userID).Scan(
+		&member.OrganizationID,
+		&member.UserID,
+		&member.Role,
+		&member.CreatedAt,
+		&member.UpdatedAt,
+		&member.Email,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+	SELECT m.organization_id, m.user_id, m.role, m.created_at, m.updated_at, u.email
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.organization_id = ?
+	ORDER BY m.created_at
+	`
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		if err := rows.Scan(
+			&member.OrganizationID,
+			&member.UserID,
+			&member.Role,
+			&member.CreatedAt,
+			&member.UpdatedAt,
+			&member.Email,
+		); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (c Client) CountOrganizationOwners(orgID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM organization_members
+	WHERE organization_id = ? AND role = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, orgID, OrganizationRoleOwner).Scan(&count)
+	return count, err
+}
+
+func (c Client) DeleteOrganizationMember(orgID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID, userID)
+	return err
+}
+
+// DeleteOrganization removes an organization with its members, videos and
+// webhooks.
+// Stored media must be cleaned up by the caller first.
+func (c Client) DeleteOrganization(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM video_collaborators WHERE video_id IN (SELECT id FROM videos WHERE organization_id = ?1)",
+		"DELETE FROM videos WHERE organization_id = ?1",
+		"DELETE FROM organization_members WHERE organization_id = ?1",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE organization_id = ?1)",
+		"DELETE FROM webhooks WHERE organization_id = ?1",
+		"DELETE FROM organizations WHERE id = ?1",
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
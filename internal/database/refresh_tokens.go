@@ -81,3 +81,30 @@ func (c Client) DeleteRefreshToken(token string) error {
 	_, err := c.db.Exec(query, token)
 	return err
 }
+
+// GetUserRefreshTokens lists every refresh token issued to a user, newest
+// first, including revoked and expired ones.
+func (c Client) GetUserRefreshTokens(userID uuid.UUID) ([]RefreshToken, error) {
+	query := `
+		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		FROM refresh_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []RefreshToken{}
+	for rows.Next() {
+		var rt RefreshToken
+		err := rows.Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &rt.UserID, &rt.ExpiresAt, &rt.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, rt)
+	}
+	return tokens, rows.Err()
+}
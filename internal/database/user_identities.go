@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links an account at an external identity provider, named by
+// its issuer and subject, to a Tubely user.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState is what the server remembers about an SSO login between
+// redirecting to the provider and handling its callback.
+type OIDCLoginState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+func (c Client) GetUserIdentity(issuer, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT issuer, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+	var identity UserIdentity
+	var userID string
+	err := c.db.QueryRow(query, issuer, subject).Scan(
+		&identity.Issuer,
+		&identity.Subject,
+		&userID,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	identity.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (c Client) GetUserIdentities(userID uuid.UUID) ([]UserIdentity, error) {
+	query := `
+		SELECT issuer, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+	for rows.Next() {
+		var identity UserIdentity
+		err := rows.Scan(
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.UserID,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (c Client) CreateUserIdentity(identity UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, identity.Issuer, identity.Subject, identity.UserID.String(), identity.Email)
+	return err
+}
+
+// CreateOIDCLoginState stores a pending SSO login, clearing out abandoned
+// ones while it's at it.
+func (c Client) CreateOIDCLoginState(state OIDCLoginState) error {
+	_, err := c.db.Exec("DELETE FROM oidc_login_states WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, created_at, expires_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err = c.db.Exec(query, state.StateHash, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCLoginState removes and returns a pending SSO login. It returns
+// nil if the state is unknown, expired or was already used.
+func (c Client) ConsumeOIDCLoginState(stateHash string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = ? AND expires_at > ?
+		RETURNING state_hash, nonce, code_verifier, expires_at
+	`
+	var state OIDCLoginState
+	err := c.db.QueryRow(query, stateHash, time.Now().UTC()).Scan(
+		&state.StateHash,
+		&state.Nonce,
+		&state.CodeVerifier,
+		&state.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &state, nil
+}
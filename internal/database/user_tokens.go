@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenPurpose is what a single-use user token can be redeemed for.
+type TokenPurpose string
+
+const (
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	TokenPurposePasswordReset     TokenPurpose = "password_reset"
+	TokenPurposeEmailChange       TokenPurpose = "email_change"
+)
+
+// UserToken is a single-use, expiring token sent to a user by email. Only a
+// hash of the token is stored.
+type UserToken struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreateUserTokenParams
+}
+
+type CreateUserTokenParams struct {
+	TokenHash string       `json:"-"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Purpose   TokenPurpose `json:"purpose"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	// NewEmail is the address an email change token switches the account
+	// to. It is empty for other purposes.
+	NewEmail string `json:"-"`
+}
+
+// CreateUserToken stores a new token, discarding any unused tokens the user
+// already has for the same purpose so only the latest link works.
+func (c Client) CreateUserToken(params CreateUserTokenParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM user_tokens
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`, params.UserID.String(), params.Purpose)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO user_tokens (token_hash, user_id, purpose, created_at, expires_at, new_email)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, NULLIF(?, ''))
+	`, params.TokenHash, params.UserID.String(), params.Purpose, params.ExpiresAt, params.NewEmail)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConsumeUserToken marks a token as used and returns the user it belongs to.
+// It returns uuid.Nil if the token doesn't exist, has the wrong purpose, has
+// expired or was already used.
+func (c Client) ConsumeUserToken(tokenHash string, purpose TokenPurpose) (uuid.UUID, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`
+	var userID string
+	err := c.db.QueryRow(query, tokenHash, purpose, time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return uuid.Parse(userID)
+}
+
+// ConsumeEmailChangeToken marks an email change token as used and returns the
+// user it belongs to and the address they asked to switch to. It returns
+// uuid.Nil if the token is invalid, expired or already used.
+func (c Client) ConsumeEmailChangeToken(tokenHash string) (uuid.UUID, string, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id, new_email
+	`
+	var userID, newEmail string
+	err := c.db.QueryRow(query, tokenHash, TokenPurposeEmailChange, time.Now().UTC()).Scan(&userID, &newEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, "", nil
+		}
+		return uuid.Nil, "", err
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return id, newEmail, nil
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTOTP is a user's authenticator secret. It is pending until the user
+// proves they can generate codes from it, and only enforced once EnabledAt is
+// set.
+type UserTOTP struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	Secret      string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EnabledAt   *time.Time `json:"enabled_at"`
+	LastCounter uint64     `json:"-"`
+}
+
+// SetPendingTOTP stores a new secret for the user, replacing any previous
+// enrollment that was never confirmed. It fails if 2FA is already enabled.
+func (c Client) SetPendingTOTP(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = excluded.created_at,
+			last_counter = 0
+		WHERE user_totp.enabled_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), secret)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+func (c Client) GetUserTOTP(userID uuid.UUID) (*UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, created_at, enabled_at, last_counter
+		FROM user_totp
+		WHERE user_id = ?
+	`
+	var totp UserTOTP
+	var id string
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&id,
+		&totp.Secret,
+		&totp.CreatedAt,
+		&totp.EnabledAt,
+		&totp.LastCounter,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	totp.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// UseTOTPCounter records that a code from the given time step was accepted.
+// It returns false if that step, or a later one, was already used, so a code
+// can't be replayed.
+func (c Client) UseTOTPCounter(userID uuid.UUID, counter uint64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_counter = ?
+		WHERE user_id = ? AND last_counter < ?
+	`
+	result, err := c.db.Exec(query, counter, userID.String(), counter)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// EnableTOTP turns on 2FA for the user and replaces their recovery codes.
+func (c Client) EnableTOTP(userID uuid.UUID, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_totp
+		SET enabled_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+	`, userID.String())
+	if err != nil {
+		return err
+	}
+	err = replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the user's secret and recovery codes.
+func (c Client) DisableTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates the user's recovery codes and stores new
+// ones.
+func (c Client) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *tx, userID uuid.UUID, codeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID.String())
+	if err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode marks one of the user's recovery codes as used. It
+// returns false if the code doesn't exist or was already used.
+func (c Client) ConsumeRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c Client) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`
+	var count int
+	err := c.db.QueryRow(query, userID.String()).Scan(&count)
+	return count, err
+}
@@ -6,12 +6,24 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrEmailTaken is returned when another account already has the email
+// address.
+var ErrEmailTaken = errors.New("email address is already in use")
+
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	// TokenVersion is carried in the user's access tokens. Ending the
+	// user's sessions increments it, so tokens issued before are turned
+	// away.
+	TokenVersion int `json:"-"`
 	CreateUserParams
 }
 
@@ -20,12 +32,48 @@ type CreateUserParams struct {
 	Password string `json:"password"`
 }
 
+// userColumns is the column list scanned by scanUser. Queries select it from
+// the users table aliased as u.
+const userColumns = `
+		u.id,
+		u.created_at,
+		u.updated_at,
+		u.email,
+		u.password,
+		u.role,
+		u.disabled_at,
+		u.verified_at,
+		u.token_version`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(
+		&id,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+		&user.DisabledAt,
+		&user.VerifiedAt,
+		&user.TokenVersion,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
 func (c Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
-		FROM users
+		SELECT` + userColumns + `
+		FROM users u
+		ORDER BY u.created_at
 	`
 
 	rows, err := c.db.Query(query)
@@ -36,64 +84,49 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE email = ?
+		SELECT` + userColumns + `
+		FROM users u
+		WHERE u.email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
+// GetUserByRefreshToken returns the user a refresh token was issued to, or
+// nil if the token doesn't exist, has been revoked or has expired.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT` + userColumns + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
 
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, token, time.Now().UTC()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
 
 	return &user, nil
 }
@@ -108,6 +141,9 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
 	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	if isUniqueViolation(err) {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -117,31 +153,163 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE id = ?
+		SELECT` + userColumns + `
+		FROM users u
+		WHERE u.id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
-	if err != nil {
-		return nil, err
-	}
 	return &user, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling also ends
+// the account's sessions, so they stay ended if it's re-enabled.
+func (c Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
+	if disabled {
+		query = `
+			UPDATE users
+			SET disabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND disabled_at IS NULL
+		`
+	}
+	_, err := c.db.Exec(query, id.String())
+	if err != nil {
+		return err
+	}
+	if !disabled {
+		return nil
+	}
+	return c.EndUserSessions(id)
+}
+
+// EndUserSessions signs a user out everywhere: it revokes their refresh
+// tokens and increments their token version, so the access tokens they hold
+// stop working too.
+func (c Client) EndUserSessions(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE users
+		SET token_version = token_version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) MarkUserVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND verified_at IS NULL
+	`
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+func (c Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hashedPassword, id.String())
+	return err
+}
+
+// UpdateUserEmail switches the user to an address they have just proven they
+// own, so it also counts as verified.
+func (c Client) UpdateUserEmail(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET email = ?, verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, email, id.String())
+	if isUniqueViolation(err) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is SQLite refusing a row that
+// duplicates another's value in a UNIQUE column.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// DeleteUser removes a user along with everything that belongs only to them:
+// sessions, tokens, 2FA settings, linked identities, organization
+// memberships, collaborator grants and personal videos. Videos they created
+// in an organization's workspace stay with the organization. Stored media
+// must be cleaned up by the caller first.
+func (c Client) DeleteUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM video_collaborators
+		WHERE user_id = ?1
+		OR video_id IN (SELECT id FROM videos WHERE user_id = ?1 AND organization_id IS NULL)`,
+		"DELETE FROM videos WHERE user_id = ?1 AND organization_id IS NULL",
+		"DELETE FROM organization_members WHERE user_id = ?1",
+		"DELETE FROM refresh_tokens WHERE user_id = ?1",
+		"DELETE FROM user_tokens WHERE user_id = ?1",
+		"DELETE FROM recovery_codes WHERE user_id = ?1",
+		"DELETE FROM user_totp WHERE user_id = ?1",
+		"DELETE FROM user_identities WHERE user_id = ?1",
+		"DELETE FROM data_exports WHERE user_id = ?1",
+		"DELETE FROM imported_files WHERE user_id = ?1",
+		"DELETE FROM imports WHERE user_id = ?1",
+		"DELETE FROM idempotency_keys WHERE user_id = ?1",
+		`DELETE FROM webhook_deliveries
+		WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?1 AND organization_id IS NULL)`,
+		"DELETE FROM webhooks WHERE user_id = ?1 AND organization_id IS NULL",
+		"DELETE FROM users WHERE id = ?1",
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, id.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
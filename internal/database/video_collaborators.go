@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CollaboratorRole string
+
+const (
+	CollaboratorViewer CollaboratorRole = "viewer"
+	CollaboratorEditor CollaboratorRole = "editor"
+	CollaboratorOwner  CollaboratorRole = "owner"
+)
+
+func (r CollaboratorRole) Valid() bool {
+	switch r {
+	case CollaboratorViewer, CollaboratorEditor, CollaboratorOwner:
+		return true
+	}
+	return false
+}
+
+type VideoCollaborator struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Email     string    `json:"email"`
+	CreateVideoCollaboratorParams
+}
+
+type CreateVideoCollaboratorParams struct {
+	VideoID uuid.UUID        `json:"video_id"`
+	UserID  uuid.UUID        `json:"user_id"`
+	Role    CollaboratorRole `json:"role"`
+}
+
+// CollaboratorVideo is a video shared with a user, along with their role on it.
+type CollaboratorVideo struct {
+	Video
+	Role CollaboratorRole `json:"role"`
+}
+
+// UpsertVideoCollaborator adds a collaborator to a video, or changes their
+// role if they're already on it.
+func (c Client) UpsertVideoCollaborator(params CreateVideoCollaboratorParams) (VideoCollaborator, error) {
+	query := `
+	INSERT INTO video_collaborators (
+		video_id,
+		user_id,
+		role,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id, user_id) DO UPDATE SET
+		role = excluded.role,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.UserID, params.Role)
+	if err != nil {
+		return VideoCollaborator{}, err
+	}
+
+	collaborator, err := c.GetVideoCollaborator(params.VideoID, params.UserID)
+	if err != nil {
+		return VideoCollaborator{}, err
+	}
+	if collaborator == nil {
+		return VideoCollaborator{}, errors.New("collaborator not found after insert")
+	}
+	return *collaborator, nil
+}
+
+func (c Client) GetVideoCollaborator(videoID, userID uuid.UUID) (*VideoCollaborator, error) {
+	query := `
+	SELECT
+		vc.video_id,
+		vc.user_id,
+		vc.role,
+		vc.created_at,
+		vc.updated_at,
+		u.email
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ? AND vc.user_id = ?
+	`
+
+	var collaborator VideoCollaborator
+	err := c.db.QueryRow(query, videoID, userID).Scan(
+		&collaborator.VideoID,
+		&collaborator.UserID,
+		&collaborator.Role,
+		&collaborator.CreatedAt,
+		&collaborator.UpdatedAt,
+		&collaborator.Email,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &collaborator, nil
+}
+
+func (c Client) GetVideoCollaborators(videoID uuid.UUID) ([]VideoCollaborator, error) {
+	query := `
+	SELECT
+		vc.video_id,
+		vc.user_id,
+		vc.role,
+		vc.created_at,
+		vc.updated_at,
+		u.email
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ?
+	ORDER BY vc.created_at
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []VideoCollaborator{}
+	for rows.Next() {
+		var collaborator VideoCollaborator
+		if err := rows.Scan(
+			&collaborator.VideoID,
+			&collaborator.UserID,
+			&collaborator.Role,
+			&collaborator.CreatedAt,
+			&collaborator.UpdatedAt,
+			&collaborator.Email,
+		); err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+
+	return collaborators, nil
+}
+
+// GetCollaboratorVideos returns the videos shared with a user, excluding the
+// ones they created.
+func (c Client) GetCollaboratorVideos(userID uuid.UUID) ([]CollaboratorVideo, error) {
+	query := `
+	SELECT` + videoColumns + `,
+		vc.role
+	FROM videos v
+	JOIN video_collaborators vc ON vc.video_id = v.id
+	WHERE vc.user_id = ?
+	ORDER BY v.created_at DESC
+	`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []CollaboratorVideo{}
+	for rows.Next() {
+		var role CollaboratorRole
+		video, err := scanVideo(rows, &role)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, CollaboratorVideo{Video: video, Role: role})
+	}
+
+	return videos, rows.Err()
+}
+
+func (c Client) DeleteVideoCollaborator(videoID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM video_collaborators
+	WHERE video_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, videoID, userID)
+	return err
+}
@@ -2,44 +2,98 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// VideoVisibility controls who besides the people a video is shared with
+// can watch it. Unlisted videos can be watched by anyone with the link;
+// public ones can also be listed and found.
+type VideoVisibility string
+
+const (
+	VisibilityPrivate  VideoVisibility = "private"
+	VisibilityUnlisted VideoVisibility = "unlisted"
+	VisibilityPublic   VideoVisibility = "public"
+)
+
+func (v VideoVisibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID           uuid.UUID       `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	ThumbnailURL *string         `json:"thumbnail_url"`
+	VideoURL     *string         `json:"video_url"`
+	SizeBytes    int64           `json:"size_bytes"`
+	Visibility   VideoVisibility `json:"visibility"`
+	Tags         []string        `json:"tags"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description"`
+	UserID         uuid.UUID  `json:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+// videoColumns is the column list scanned by scanVideo. Queries select it from
+// the videos table aliased as v.
+const videoColumns = `
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.organization_id,
+		v.size_bytes,
+		v.visibility,
+		v.tags`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	rows, err := c.db.Query(query, userID)
+func scanVideo(row rowScanner, extra ...any) (Video, error) {
+	var video Video
+	var tags string
+	dest := []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.OrganizationID,
+		&video.SizeBytes,
+		&video.Visibility,
+		&tags,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return Video{}, err
+	}
+	err = json.Unmarshal([]byte(tags), &video.Tags)
+	return video, err
+}
+
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +101,56 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+// GetVideos returns the videos in a user's personal workspace.
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE v.user_id = ? AND v.organization_id IS NULL
+	ORDER BY v.created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetVideosCreatedBy returns every video a user created, in their personal
+// workspace or an organization's.
+func (c Client) GetVideosCreatedBy(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE v.user_id = ?
+	ORDER BY v.created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+func (c Client) GetOrganizationVideos(orgID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE v.organization_id = ?
+	ORDER BY v.created_at DESC
+	`
+	return c.queryVideos(query, orgID)
+}
+
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos v
+	ORDER BY v.created_at DESC
+	`
+	return c.queryVideos(query)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +162,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		organization_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.OrganizationID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +176,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE id = ?
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE v.id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +192,104 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// IsPublicVideoMedia reports whether the file served at urlPath, such as
+// /assets/abc.png, is the thumbnail or media of a public video.
+func (c Client) IsPublicVideoMedia(urlPath string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM videos v
+		WHERE v.visibility = ?1
+		AND (substr(v.thumbnail_url, -length(?2)) = ?2 OR substr(v.video_url, -length(?2)) = ?2)
+	)
+	`
+	var public bool
+	err := c.db.QueryRow(query, VisibilityPublic, urlPath).Scan(&public)
+	return public, err
+}
+
+// UpdateVideo saves every field of a video and sets its UpdatedAt to now.
+func (c Client) UpdateVideo(video *Video) error {
+	_, err := c.updateVideo(video, "")
+	return err
+}
+
+// UpdateVideoIfUnchanged is UpdateVideo, but only if the video's updated_at
+// still matches updatedAt, to the millisecond. It reports false without
+// saving anything if the video was changed or deleted in the meantime.
+func (c Client) UpdateVideoIfUnchanged(video *Video, updatedAt time.Time) (bool, error) {
+	return c.updateVideo(video, "AND strftime('%Y-%m-%d %H:%M:%f', updated_at) = strftime('%Y-%m-%d %H:%M:%f', ?)", updatedAt.UTC())
+}
+
+func (c Client) updateVideo(video *Video, condition string, conditionArgs ...any) (bool, error) {
+	tags := video.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return false, err
+	}
+	updatedAt := time.Now().UTC()
+
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
-	`
+		user_id = ?,
+		organization_id = ?,
+		size_bytes = ?,
+		visibility = ?,
+		tags = ?
+	WHERE id = ? ` + condition
 
-	_, err := c.db.Exec(
-		query,
+	args := []any{
+		updatedAt,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.OrganizationID,
+		video.SizeBytes,
+		video.Visibility,
+		string(tagsJSON),
 		video.ID,
-	)
-	return err
+	}
+	result, err := c.db.Exec(query, append(args, conditionArgs...)...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	video.UpdatedAt = updatedAt
+	video.Tags = tags
+	return true, nil
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
-	query := `
-	DELETE FROM videos
-	WHERE id = ?
-	`
-	_, err := c.db.Exec(query, id)
-	return err
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_collaborators WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM videos WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is an endpoint that's sent video events as they happen. A
+// personal webhook gets events for its user's personal videos; an
+// organization's gets events for the organization's videos. UserID is
+// whoever registered it.
+type Webhook struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	UserID         uuid.UUID  `json:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	URL            string     `json:"url"`
+	// Secret signs deliveries. It's only shown when the webhook is created.
+	Secret string   `json:"-"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type.
+func (w Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateWebhookParams struct {
+	UserID         uuid.UUID
+	OrganizationID *uuid.UUID
+	URL            string
+	Secret         string
+	Events         []string
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		organization_id,
+		url,
+		secret,
+		events,
+		active`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+		&webhook.UserID,
+		&webhook.OrganizationID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+		&webhook.Active,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	err = json.Unmarshal([]byte(events), &webhook.Events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	events, err := json.Marshal(params.Events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	query := `
+		INSERT INTO webhooks (id, created_at, updated_at, user_id, organization_id, url, secret, events, active)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 1)
+	`
+	_, err = c.db.Exec(query, id.String(), params.UserID.String(), params.OrganizationID, params.URL, params.Secret, string(events))
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook, err := c.GetWebhook(id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return *webhook, nil
+}
+
+func (c Client) GetWebhook(id uuid.UUID) (*Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM webhooks
+		WHERE id = ?
+	`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (c Client) queryWebhooks(query string, args ...any) ([]Webhook, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetUserWebhooks returns a user's personal webhooks.
+func (c Client) GetUserWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM webhooks
+		WHERE user_id = ? AND organization_id IS NULL
+		ORDER BY created_at
+	`
+	return c.queryWebhooks(query, userID.String())
+}
+
+func (c Client) GetOrganizationWebhooks(orgID uuid.UUID) ([]Webhook, error) {
+	query := `
+		SELECT` + webhookColumns + `
+		FROM webhooks
+		WHERE organization_id = ?
+		ORDER BY created_at
+	`
+	return c.queryWebhooks(query, orgID.String())
+}
+
+// GetVideoWebhooks returns the active webhooks that get a video's events:
+// its organization's, or its creator's personal ones if it's a personal
+// video.
+func (c Client) GetVideoWebhooks(video Video) ([]Webhook, error) {
+	if video.OrganizationID != nil {
+		query := `
+			SELECT` + webhookColumns + `
+			FROM webhooks
+			WHERE organization_id = ? AND active = 1
+		`
+		return c.queryWebhooks(query, video.OrganizationID.String())
+	}
+	query := `
+		SELECT` + webhookColumns + `
+		FROM webhooks
+		WHERE user_id = ? AND organization_id IS NULL AND active = 1
+	`
+	return c.queryWebhooks(query, video.UserID.String())
+}
+
+// UpdateWebhook saves a webhook's URL, events and whether it's active, and
+// sets its UpdatedAt to now.
+func (c Client) UpdateWebhook(webhook *Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	webhook.UpdatedAt = time.Now().UTC()
+	query := `
+		UPDATE webhooks
+		SET url = ?, events = ?, active = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err = c.db.Exec(query, webhook.URL, string(events), webhook.Active, webhook.UpdatedAt, webhook.ID.String())
+	return err
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM webhook_deliveries WHERE webhook_id = ?1",
+		"DELETE FROM webhooks WHERE id = ?1",
+	}
+	for _, statement := range statements {
+		_, err = tx.Exec(statement, id.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusSucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent, or being sent, to a webhook, with the
+// outcome of its latest attempt. A redelivery is a new delivery of the same
+// event, so the log keeps both.
+type WebhookDelivery struct {
+	ID        uuid.UUID             `json:"id"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	WebhookID uuid.UUID             `json:"webhook_id"`
+	EventID   uuid.UUID             `json:"event_id"`
+	EventType string                `json:"event_type"`
+	Payload   json.RawMessage       `json:"payload"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	Attempts  int                   `json:"attempts"`
+	// ResponseStatus is from the latest attempt that got a response.
+	ResponseStatus *int       `json:"response_status"`
+	Error          *string    `json:"error"`
+	DurationMS     *int64     `json:"duration_ms"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	RedeliveryOf   *uuid.UUID `json:"redelivery_of"`
+}
+
+type CreateWebhookDeliveryParams struct {
+	WebhookID    uuid.UUID
+	EventID      uuid.UUID
+	EventType    string
+	Payload      json.RawMessage
+	RedeliveryOf *uuid.UUID
+}
+
+const webhookDeliveryColumns = `
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event_id,
+		event_type,
+		payload,
+		status,
+		attempts,
+		response_status,
+		error,
+		duration_ms,
+		last_attempt_at,
+		delivered_at,
+		redelivery_of`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload string
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.ResponseStatus,
+		&delivery.Error,
+		&delivery.DurationMS,
+		&delivery.LastAttemptAt,
+		&delivery.DeliveredAt,
+		&delivery.RedeliveryOf,
+	)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	return delivery, nil
+}
+
+func (c Client) CreateWebhookDelivery(params CreateWebhookDeliveryParams) (WebhookDelivery, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO webhook_deliveries (id, created_at, updated_at, webhook_id, event_id, event_type, payload, status, redelivery_of)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), params.WebhookID.String(), params.EventID.String(), params.EventType,
+		string(params.Payload), WebhookDeliveryStatusPending, params.RedeliveryOf)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	delivery, err := c.GetWebhookDelivery(id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return *delivery, nil
+}
+
+func (c Client) GetWebhookDelivery(id uuid.UUID) (*WebhookDelivery, error) {
+	query := `
+		SELECT` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE id = ?
+	`
+	delivery, err := scanWebhookDelivery(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// GetWebhookDeliveries returns a webhook's most recent deliveries, newest
+// first.
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, webhookID.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// UpdateWebhookDelivery saves the outcome of a delivery attempt and sets
+// its UpdatedAt to now.
+func (c Client) UpdateWebhookDelivery(delivery *WebhookDelivery) error {
+	delivery.UpdatedAt = time.Now().UTC()
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_status = ?, error = ?,
+		    duration_ms = ?, last_attempt_at = ?, delivered_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.Error, delivery.DurationMS, delivery.LastAttemptAt, delivery.DeliveredAt, delivery.UpdatedAt,
+		delivery.ID.String())
+	return err
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// WritableDir checks that files can be created in dir, by writing and
+// removing a small temporary file.
+func WritableDir(dir string) Check {
+	return func(ctx context.Context) (string, error) {
+		file, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return dir, err
+		}
+		defer os.Remove(file.Name())
+		_, err = file.WriteString("ok")
+		if err != nil {
+			file.Close()
+			return dir, err
+		}
+		return dir, file.Close()
+	}
+}
+
+// Command checks that an executable such as ffmpeg or ffprobe is on the
+// PATH and runs, reporting its version. It runs name -version, which both
+// print as "<name> version <version> ..." on the first line.
+func Command(name string) Check {
+	return func(ctx context.Context) (string, error) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", err
+		}
+		cmd := exec.CommandContext(ctx, path, "-version")
+		out, err := cmd.Output()
+		if err != nil {
+			return path, fmt.Errorf("%s -version: %w", name, err)
+		}
+		firstLine, _, _ := strings.Cut(string(out), "\n")
+		version, ok := strings.CutPrefix(strings.TrimSpace(firstLine), name+" version ")
+		if !ok {
+			return path, nil
+		}
+		if fields := strings.Fields(version); len(fields) > 0 {
+			version = fields[0]
+		}
+		return fmt.Sprintf("%s %s", path, version), nil
+	}
+}
@@ -0,0 +1,289 @@
+// Package jobs runs background work queued in the database. Jobs survive
+// restarts: a job is leased while it runs, and one whose worker disappeared
+// is picked up again once the lease runs out.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Store is the queue the runner works from. database.Client implements it.
+type Store interface {
+	ClaimJob(kinds []string, lease time.Duration) (*database.Job, error)
+	CompleteJob(id uuid.UUID) error
+	FailJob(id uuid.UUID, message string, retryAt *time.Time) error
+	ReleaseJob(id uuid.UUID) error
+}
+
+// Handler does the work for one job. Returning an error retries the job
+// with backoff until it runs out of attempts, unless the error is wrapped
+// with Permanent.
+type Handler func(ctx context.Context, job database.Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as one retrying won't fix.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type Runner struct {
+	store    Store
+	handlers map[string]Handler
+	kinds    []string
+	wake     chan struct{}
+
+	running  atomic.Bool
+	busy     atomic.Int32
+	lastPoll atomic.Int64
+
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+
+	// Workers is how many jobs run at once.
+	Workers int
+	// Lease is how long a job may run before another worker may take it.
+	Lease time.Duration
+	// PollInterval is how often idle workers check for due jobs.
+	PollInterval time.Duration
+	// RetryBase is the delay before the first retry; it doubles with each
+	// further attempt up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+}
+
+func NewRunner(store Store) *Runner {
+	return &Runner{
+		store:        store,
+		handlers:     map[string]Handler{},
+		wake:         make(chan struct{}, 1),
+		stopping:     make(chan struct{}),
+		done:         make(chan struct{}),
+		Workers:      2,
+		Lease:        15 * time.Minute,
+		PollInterval: time.Second,
+		RetryBase:    30 * time.Second,
+		RetryMax:     time.Hour,
+	}
+}
+
+// Register sets the handler for a kind of job. It must be called before Run.
+func (r *Runner) Register(kind string, handler Handler) {
+	if _, ok := r.handlers[kind]; !ok {
+		r.kinds = append(r.kinds, kind)
+	}
+	r.handlers[kind] = handler
+}
+
+// Status describes what the runner is doing.
+type Status struct {
+	// Running is true between Run being called and it returning.
+	Running bool
+	Workers int
+	// Busy is how many workers are running a job.
+	Busy int
+	// LastPoll is when a worker last checked the queue successfully, or
+	// zero if none has yet.
+	LastPoll time.Time
+}
+
+// Status reports what the runner is doing, for health checks.
+func (r *Runner) Status() Status {
+	status := Status{
+		Running: r.running.Load(),
+		Workers: r.Workers,
+		Busy:    int(r.busy.Load()),
+	}
+	if nanos := r.lastPoll.Load(); nanos != 0 {
+		status.LastPoll = time.Unix(0, nanos)
+	}
+	return status
+}
+
+// Notify tells idle workers that a job was queued, so it starts without
+// waiting for the next poll.
+func (r *Runner) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run works through due jobs until ctx is cancelled or Shutdown is called,
+// then waits for running jobs to return. A job interrupted by cancellation
+// goes back to the queue without using up an attempt. Run must only be
+// called once.
+func (r *Runner) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+
+	r.running.Store(true)
+	defer close(r.done)
+	defer r.running.Store(false)
+	wg := sync.WaitGroup{}
+	for i := 0; i < r.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Runner) work(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		// Drain every due job before going back to sleep.
+		for ctx.Err() == nil && !r.stopped() {
+			job, err := r.store.ClaimJob(r.kinds, r.Lease)
+			if err != nil {
+				slog.ErrorContext(ctx, "Couldn't claim job", "error", err)
+				break
+			}
+			r.lastPoll.Store(time.Now().UnixNano())
+			if job == nil {
+				break
+			}
+			r.busy.Add(1)
+			r.runJob(ctx, *job)
+			r.busy.Add(-1)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopping:
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Shutdown stops workers taking new jobs and waits for running ones to
+// finish. If ctx is done first, the running jobs are cancelled, which puts
+// them back on the queue without using up an attempt, and Shutdown returns
+// ctx's error once they've been released.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stopping) })
+	if !r.running.Load() {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+	}
+	r.mu.Lock()
+	cancel := r.cancel
+	r.mu.Unlock()
+	cancel()
+	<-r.done
+	return ctx.Err()
+}
+
+func (r *Runner) stopped() bool {
+	select {
+	case <-r.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// runJob runs a claimed job and records the outcome. Everything logged while
+// it runs carries the job's ID and kind, and the ID of the request that
+// queued it.
+func (r *Runner) runJob(ctx context.Context, job database.Job) {
+	attrs := []slog.Attr{
+		slog.String("job_id", job.ID.String()),
+		slog.String("job_kind", job.Kind),
+		slog.Int("attempt", job.Attempts),
+	}
+	if job.RequestID != "" {
+		attrs = append(attrs, slog.String(logging.KeyRequestID, job.RequestID))
+	}
+	ctx = logging.NewContext(ctx, attrs...)
+
+	start := time.Now()
+	err := r.call(ctx, job)
+	duration := slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000)
+	if err == nil {
+		slog.LogAttrs(ctx, slog.LevelInfo, "Job succeeded", duration)
+		err = r.store.CompleteJob(job.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Couldn't mark job done", "error", err)
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		slog.InfoContext(ctx, "Job interrupted, returning it to the queue")
+		err = r.store.ReleaseJob(job.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Couldn't release job", "error", err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	var permanent permanentError
+	if job.Attempts < job.MaxAttempts && !errors.As(err, &permanent) {
+		t := time.Now().Add(r.backoff(job.Attempts))
+		retryAt = &t
+		slog.LogAttrs(ctx, slog.LevelWarn, "Job failed, will retry", duration, slog.Time("retry_at", t), slog.String("error", err.Error()))
+	} else {
+		slog.LogAttrs(ctx, slog.LevelError, "Job failed, giving up", duration, slog.String("error", err.Error()))
+	}
+	err = r.store.FailJob(job.ID, err.Error(), retryAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Couldn't record job failure", "error", err)
+	}
+}
+
+// call runs the job's handler, turning a panic into an error so one bad job
+// can't take down the worker.
+func (r *Runner) call(ctx context.Context, job database.Job) (err error) {
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (r *Runner) backoff(attempts int) time.Duration {
+	d := r.RetryBase * time.Duration(math.Pow(2, float64(attempts-1)))
+	if d <= 0 || d > r.RetryMax {
+		return r.RetryMax
+	}
+	return d
+}
@@ -0,0 +1,113 @@
+// Package logging sets up structured JSON logging and carries request-scoped
+// attributes, such as the request ID and the caller's user ID, in a context
+// so every line logged with that context includes them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Attribute keys shared by every log line that has them.
+const (
+	KeyRequestID = "request_id"
+	KeyUserID    = "user_id"
+	KeyTraceID   = "trace_id"
+)
+
+// New returns a logger that writes JSON lines to w at level and above,
+// adding the attributes carried by the context of each call.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(handler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// ParseLevel parses debug, info, warn or error. An empty string is info.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(strings.ToLower(s)))
+	if err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// fields are the attributes carried by a context. They're shared by pointer
+// so attributes added deep in a request, like the user ID once the caller
+// is authenticated, also show up in the access log written further out.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (f *fields) snapshot() []slog.Attr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+type fieldsKey struct{}
+
+// NewContext returns a copy of ctx carrying attrs in addition to any it
+// already carries. Attributes added later with Add on the returned context
+// don't affect ctx.
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	f := &fields{}
+	if parent, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		f.attrs = parent.snapshot()
+	}
+	f.attrs = append(f.attrs, attrs...)
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// Add adds attrs to those carried by ctx, where every holder of ctx or a
+// context derived from it sees them. It does nothing if ctx carries none.
+func Add(ctx context.Context, attrs ...slog.Attr) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attrs = append(f.attrs, attrs...)
+}
+
+// RequestID returns the request ID carried by ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return ""
+	}
+	for _, attr := range f.snapshot() {
+		if attr.Key == KeyRequestID {
+			return attr.Value.String()
+		}
+	}
+	return ""
+}
+
+// handler adds the attributes carried by a record's context.
+type handler struct {
+	slog.Handler
+}
+
+func (h handler) Handle(ctx context.Context, record slog.Record) error {
+	if f, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		record.AddAttrs(f.snapshot()...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// JobCounter reports how many jobs are waiting or running. database.Client
+// implements it.
+type JobCounter interface {
+	CountActiveJobs() ([]database.JobCount, error)
+}
+
+// WatchJobQueue reports the job queue depth, read from counter each time
+// metrics are scraped.
+func (m *Metrics) WatchJobQueue(counter JobCounter) {
+	m.registry.MustRegister(jobQueueCollector{counter: counter, metrics: m})
+}
+
+var jobQueueDepth = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "job_queue_depth"),
+	"Background jobs waiting, scheduled for later or running, by kind and state.",
+	[]string{"kind", "state"}, nil,
+)
+
+type jobQueueCollector struct {
+	counter JobCounter
+	metrics *Metrics
+}
+
+func (c jobQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobQueueDepth
+}
+
+func (c jobQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.counter.CountActiveJobs()
+	if err != nil {
+		c.metrics.jobQueueScrapeErrs.Inc()
+		return
+	}
+	for _, count := range counts {
+		ch <- prometheus.MustNewConstMetric(jobQueueDepth, prometheus.GaugeValue, float64(count.Count), count.Kind, count.State)
+	}
+}
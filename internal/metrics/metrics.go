@@ -0,0 +1,158 @@
+// Package metrics collects the server's Prometheus metrics and serves them
+// for scraping.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tubely"
+
+// Metrics holds every collector the server reports. Its zero value is not
+// usable; create one with New.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests       *prometheus.CounterVec
+	httpDuration       *prometheus.HistogramVec
+	uploads            *prometheus.CounterVec
+	uploadBytes        *prometheus.CounterVec
+	mediaDuration      *prometheus.HistogramVec
+	mediaFailures      *prometheus.CounterVec
+	storageDuration    *prometheus.HistogramVec
+	storageErrors      *prometheus.CounterVec
+	dbQueryDuration    *prometheus.HistogramVec
+	dbQueryErrors      *prometheus.CounterVec
+	jobQueueScrapeErrs prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to serve HTTP requests, by method and route pattern.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"method", "route"}),
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploads_total",
+			Help:      "Media files uploaded, by kind (video or thumbnail) and media type.",
+		}, []string{"kind", "media_type"}),
+		uploadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upload_bytes_total",
+			Help:      "Bytes of media uploaded, by kind (video or thumbnail) and media type.",
+		}, []string{"kind", "media_type"}),
+		mediaDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "media_command_duration_seconds",
+			Help:      "Time taken by ffmpeg and ffprobe runs, by command.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"command"}),
+		mediaFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "media_command_failures_total",
+			Help:      "ffmpeg and ffprobe runs that failed, by command.",
+		}, []string{"command"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Time taken by blob store operations, by backend and operation.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"backend", "operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_errors_total",
+			Help:      "Blob store operations that failed, by backend and operation.",
+		}, []string{"backend", "operation"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by database statements, by the database method that ran them.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+		}, []string{"query"}),
+		dbQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_query_errors_total",
+			Help:      "Database statements that failed, by the database method that ran them.",
+		}, []string{"query"}),
+		jobQueueScrapeErrs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_queue_scrape_errors_total",
+			Help:      "Failures to read the job queue depth while collecting metrics.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.uploads,
+		m.uploadBytes,
+		m.mediaDuration,
+		m.mediaFailures,
+		m.storageDuration,
+		m.storageErrors,
+		m.dbQueryDuration,
+		m.dbQueryErrors,
+		m.jobQueueScrapeErrs,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveHTTPRequest records a served request. route should be the mux
+// pattern that matched, not the path, to keep the number of series bounded.
+func (m *Metrics) ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveUpload records an accepted media upload of size bytes.
+func (m *Metrics) ObserveUpload(kind, mediaType string, size int64) {
+	m.uploads.WithLabelValues(kind, mediaType).Inc()
+	m.uploadBytes.WithLabelValues(kind, mediaType).Add(float64(size))
+}
+
+// ObserveMediaCommand records an ffmpeg or ffprobe run.
+func (m *Metrics) ObserveMediaCommand(command string, duration time.Duration, err error) {
+	m.mediaDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		m.mediaFailures.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveStorage records a blob store operation.
+func (m *Metrics) ObserveStorage(backend, operation string, duration time.Duration, err error) {
+	m.storageDuration.WithLabelValues(backend, operation).Observe(duration.Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// ObserveQuery records a database statement. It is a database.QueryHook.
+func (m *Metrics) ObserveQuery(ctx context.Context, query database.Query) {
+	m.dbQueryDuration.WithLabelValues(query.Name).Observe(query.Duration.Seconds())
+	if query.Err != nil {
+		m.dbQueryErrors.WithLabelValues(query.Name).Inc()
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// InstrumentStore wraps a blob store so the latency and failures of each
+// operation are recorded under backend. A Get that finds nothing isn't
+// counted as a failure.
+func (m *Metrics) InstrumentStore(backend string, store storage.Store) storage.Store {
+	return instrumentedStore{store: store, backend: backend, metrics: m}
+}
+
+type instrumentedStore struct {
+	store   storage.Store
+	backend string
+	metrics *Metrics
+}
+
+func (s instrumentedStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	start := time.Now()
+	err := s.store.Put(ctx, key, body, contentType)
+	s.metrics.ObserveStorage(s.backend, "put", time.Since(start), err)
+	return err
+}
+
+func (s instrumentedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := s.store.Get(ctx, key)
+	observed := err
+	if errors.Is(err, storage.ErrNotFound) {
+		observed = nil
+	}
+	s.metrics.ObserveStorage(s.backend, "get", time.Since(start), observed)
+	return body, err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, key)
+	s.metrics.ObserveStorage(s.backend, "delete", time.Since(start), err)
+	return err
+}
+
+func (s instrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.store.Ping(ctx)
+	s.metrics.ObserveStorage(s.backend, "ping", time.Since(start), err)
+	return err
+}
+
+func (s instrumentedStore) List(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	keys, err := s.store.List(ctx)
+	s.metrics.ObserveStorage(s.backend, "list", time.Since(start), err)
+	return keys, err
+}
@@ -0,0 +1,159 @@
+// Package progress passes per-video progress events, such as upload bytes
+// received and how far ffmpeg has got, from the code doing the work to
+// anyone watching. Events live only in this process; nothing is stored.
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Stage string
+
+const (
+	// StageUploading counts bytes of the request body received.
+	StageUploading Stage = "uploading"
+	// StageProcessing is ffmpeg working through the media.
+	StageProcessing Stage = "processing"
+	// StageStoring is the processed file being put in the video store.
+	StageStoring Stage = "storing"
+	StageReady   Stage = "ready"
+	StageFailed  Stage = "failed"
+)
+
+// Done reports whether nothing more will happen until the next upload or
+// reprocessing starts.
+func (s Stage) Done() bool {
+	return s == StageReady || s == StageFailed
+}
+
+// Event is one step of a video's progress.
+type Event struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Stage   Stage     `json:"stage"`
+	Time    time.Time `json:"time"`
+	// Bytes and TotalBytes count the upload; TotalBytes is zero when the
+	// client didn't send a Content-Length.
+	Bytes      int64 `json:"bytes,omitempty"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+	// Percent is how far through the stage the video is, when that's known.
+	Percent *float64 `json:"percent,omitempty"`
+	// VideoURL is set once the video is ready.
+	VideoURL string `json:"video_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow subscriber can fall behind by
+// before older ones are dropped in favour of newer ones.
+const subscriberBuffer = 16
+
+// Broker fans events out to the subscribers of each video. It remembers the
+// latest event of work still in progress, so subscribers who join halfway
+// see where things are at once. The zero value isn't usable; call New.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[uuid.UUID]map[chan Event]struct{}
+	latest map[uuid.UUID]Event
+	closed bool
+}
+
+func New() *Broker {
+	return &Broker{
+		subs:   map[uuid.UUID]map[chan Event]struct{}{},
+		latest: map[uuid.UUID]Event{},
+	}
+}
+
+// Subscribe returns a channel of the video's events, starting with the
+// latest if work is in progress. The channel is closed when the broker is.
+// Call unsubscribe when done.
+func (b *Broker) Subscribe(videoID uuid.UUID) (events <-chan Event, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if event, ok := b.latest[videoID]; ok {
+		ch <- event
+	}
+	if b.subs[videoID] == nil {
+		b.subs[videoID] = map[chan Event]struct{}{}
+	}
+	b.subs[videoID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[videoID][ch]; !ok {
+			return
+		}
+		delete(b.subs[videoID], ch)
+		if len(b.subs[videoID]) == 0 {
+			delete(b.subs, videoID)
+		}
+	}
+}
+
+// Subscribers reports how many subscribers the video has.
+func (b *Broker) Subscribers(videoID uuid.UUID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[videoID])
+}
+
+// Publish sends an event to the video's subscribers, filling in its time if
+// it's zero. It never blocks: a subscriber that's fallen behind loses its
+// oldest unread event.
+func (b *Broker) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if event.Stage.Done() {
+		delete(b.latest, event.VideoID)
+	} else {
+		b.latest[event.VideoID] = event
+	}
+	for ch := range b.subs[event.VideoID] {
+		for {
+			select {
+			case ch <- event:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// Close closes every subscriber's channel, ending their streams, and drops
+// later events. It's called at shutdown so open streams don't hold it up.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	b.subs = nil
+	b.latest = nil
+}
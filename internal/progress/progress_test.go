@@ -0,0 +1,125 @@
+package progress
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// receive returns the next event on events, failing if there isn't one
+// waiting.
+func receive(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("channel was closed")
+		}
+		return event
+	default:
+		t.Fatal("no event was waiting")
+		return Event{}
+	}
+}
+
+// expectNone fails if an event is waiting on events.
+func expectNone(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Fatalf("got unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestSubscribeReplaysLatest(t *testing.T) {
+	b := New()
+	videoID := uuid.New()
+	percent := 40.0
+	b.Publish(Event{VideoID: videoID, Stage: StageUploading, Bytes: 10})
+	b.Publish(Event{VideoID: videoID, Stage: StageProcessing, Percent: &percent})
+
+	// A late subscriber gets only where things are now.
+	events, unsubscribe := b.Subscribe(videoID)
+	defer unsubscribe()
+	if event := receive(t, events); event.Stage != StageProcessing || *event.Percent != 40 || event.Time.IsZero() {
+		t.Fatalf("got %+v, want the latest event with its time", event)
+	}
+	expectNone(t, events)
+
+	b.Publish(Event{VideoID: videoID, Stage: StageReady, VideoURL: "https://cdn.example.com/v.mp4"})
+	if event := receive(t, events); event.Stage != StageReady {
+		t.Fatalf("got %+v, want ready", event)
+	}
+
+	// Once the work is done there's nothing to catch up on.
+	late, unsubscribeLate := b.Subscribe(videoID)
+	defer unsubscribeLate()
+	expectNone(t, late)
+
+	// Other videos' events go to their own subscribers.
+	b.Publish(Event{VideoID: uuid.New(), Stage: StageUploading})
+	expectNone(t, events)
+}
+
+func TestPublishDropsOldestForSlowSubscriber(t *testing.T) {
+	b := New()
+	videoID := uuid.New()
+	events, unsubscribe := b.Subscribe(videoID)
+	defer unsubscribe()
+
+	const published = subscriberBuffer + 5
+	for i := 1; i <= published; i++ {
+		b.Publish(Event{VideoID: videoID, Stage: StageUploading, Bytes: int64(i)})
+	}
+	for i := published - subscriberBuffer + 1; i <= published; i++ {
+		if event := receive(t, events); event.Bytes != int64(i) {
+			t.Fatalf("got bytes %d, want %d", event.Bytes, i)
+		}
+	}
+	expectNone(t, events)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New()
+	videoID := uuid.New()
+	events, unsubscribe := b.Subscribe(videoID)
+	other, unsubscribeOther := b.Subscribe(videoID)
+	defer unsubscribeOther()
+	if n := b.Subscribers(videoID); n != 2 {
+		t.Fatalf("got %d subscribers, want 2", n)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if n := b.Subscribers(videoID); n != 1 {
+		t.Fatalf("got %d subscribers after unsubscribing, want 1", n)
+	}
+	b.Publish(Event{VideoID: videoID, Stage: StageUploading})
+	expectNone(t, events)
+	receive(t, other)
+}
+
+func TestClose(t *testing.T) {
+	b := New()
+	videoID := uuid.New()
+	events, unsubscribe := b.Subscribe(videoID)
+	b.Publish(Event{VideoID: videoID, Stage: StageUploading})
+
+	b.Close()
+	// Events already sent can still be read, then the channel is closed.
+	receive(t, events)
+	if _, ok := <-events; ok {
+		t.Fatal("channel wasn't closed")
+	}
+	unsubscribe()
+
+	// Later events are dropped, and new subscribers get a closed channel.
+	b.Publish(Event{VideoID: videoID, Stage: StageUploading})
+	late, unsubscribeLate := b.Subscribe(videoID)
+	defer unsubscribeLate()
+	if _, ok := <-late; ok {
+		t.Fatal("subscribing after Close returned an open channel")
+	}
+	b.Close()
+}
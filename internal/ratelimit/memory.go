@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleTTL is how long an untouched, unlocked bucket is kept.
+const idleTTL = time.Hour
+
+// MemoryStore keeps buckets in process memory. State is lost on restart and
+// isn't shared between server instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*Bucket), now: time.Now}
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(b *Bucket)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &Bucket{}
+		s.buckets[key] = b
+	}
+	fn(b)
+	s.sweep()
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets, key)
+	return nil
+}
+
+// sweep drops idle buckets at most once per idleTTL. The caller must hold mu.
+func (s *MemoryStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < idleTTL {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.UpdatedAt) > idleTTL && now.After(b.LockedUntil) {
+			delete(s.buckets, key)
+		}
+	}
+}
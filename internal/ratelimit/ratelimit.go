@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rule allows Limit requests per Period, refilled continuously, with bursts of
+// up to Limit.
+type Rule struct {
+	Limit  int
+	Period time.Duration
+}
+
+func (r Rule) rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// Lockout locks a key out after Threshold consecutive failures. Each failure
+// past the threshold doubles the lock, starting at Base and capped at Max.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+func (l Lockout) duration(failures int) time.Duration {
+	if failures < l.Threshold {
+		return 0
+	}
+	d := l.Base * time.Duration(math.Pow(2, float64(failures-l.Threshold)))
+	if d <= 0 || d > l.Max {
+		return l.Max
+	}
+	return d
+}
+
+// Bucket is the persisted state of one key.
+type Bucket struct {
+	Tokens      float64
+	UpdatedAt   time.Time
+	Failures    int
+	LockedUntil time.Time
+}
+
+// Store persists buckets. Update must load the bucket for key (the zero
+// Bucket if there is none), apply fn and save the result atomically.
+type Store interface {
+	Update(ctx context.Context, key string, fn func(b *Bucket)) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Result is the outcome of a rate limit check. RetryAfter is set when the
+// request isn't allowed.
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes a token from key's bucket if one is available.
+func (l *Limiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	now := l.now()
+	var result Result
+	err := l.store.Update(ctx, key, func(b *Bucket) {
+		if b.UpdatedAt.IsZero() {
+			b.Tokens = float64(rule.Limit)
+		} else if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+			b.Tokens = math.Min(float64(rule.Limit), b.Tokens+elapsed*rule.rate())
+		}
+		b.UpdatedAt = now
+
+		if b.Tokens >= 1 {
+			b.Tokens--
+			result = Result{Allowed: true}
+			return
+		}
+		wait := (1 - b.Tokens) / rule.rate()
+		result = Result{RetryAfter: time.Duration(wait * float64(time.Second))}
+	})
+	return result, err
+}
+
+// Check reports whether key is currently locked out.
+func (l *Limiter) Check(ctx context.Context, key string) (Result, error) {
+	now := l.now()
+	var result Result
+	err := l.store.Update(ctx, key, func(b *Bucket) {
+		if now.Before(b.LockedUntil) {
+			result = Result{RetryAfter: b.LockedUntil.Sub(now)}
+			return
+		}
+		result = Result{Allowed: true}
+	})
+	return result, err
+}
+
+// Fail records a failed attempt for key, locking it out once the lockout's
+// threshold is reached. The returned result reports whether further attempts
+// are still allowed.
+func (l *Limiter) Fail(ctx context.Context, key string, lockout Lockout) (Result, error) {
+	now := l.now()
+	var result Result
+	err := l.store.Update(ctx, key, func(b *Bucket) {
+		b.Failures++
+		b.UpdatedAt = now
+		if d := lockout.duration(b.Failures); d > 0 {
+			b.LockedUntil = now.Add(d)
+			result = Result{RetryAfter: d}
+			return
+		}
+		result = Result{Allowed: true}
+	})
+	return result, err
+}
+
+// Reset clears any state for key, including lockouts.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Delete(ctx, key)
+}
@@ -0,0 +1,247 @@
+package ratelimit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clock is a fake time source that only moves when told to.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+type testStore struct {
+	name  string
+	store Store
+	// count returns how many buckets the store holds.
+	count func() int
+}
+
+// newTestStores returns each kind of store, with its clock set to c.
+func newTestStores(t *testing.T, c *clock) []testStore {
+	t.Helper()
+	memory := NewMemoryStore()
+	memory.now = c.now
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ratelimit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	sqlite.now = c.now
+
+	return []testStore{
+		{"memory", memory, func() int {
+			memory.mu.Lock()
+			defer memory.mu.Unlock()
+			return len(memory.buckets)
+		}},
+		{"sqlite", sqlite, func() int {
+			var n int
+			err := sqlite.db.QueryRow("SELECT COUNT(*) FROM rate_limits").Scan(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}},
+	}
+}
+
+func newTestLimiter(store Store, c *clock) *Limiter {
+	l := New(store)
+	l.now = c.now
+	return l
+}
+
+func TestAllow(t *testing.T) {
+	// One token a second, in bursts of up to three.
+	rule := Rule{Limit: 3, Period: 3 * time.Second}
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{"burst 1", 0, true, 0},
+		{"burst 2", 0, true, 0},
+		{"burst 3", 0, true, 0},
+		{"burst exhausted", 0, false, time.Second},
+		{"half refilled", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, true, 0},
+		{"empty again", 0, false, time.Second},
+		{"idle refill 1", time.Minute, true, 0},
+		{"idle refill 2", 0, true, 0},
+		{"idle refill 3", 0, true, 0},
+		{"refill capped at the limit", 0, false, time.Second},
+	}
+
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	for _, s := range newTestStores(t, c) {
+		t.Run(s.name, func(t *testing.T) {
+			l := newTestLimiter(s.store, c)
+			for _, step := range steps {
+				c.advance(step.advance)
+				got, err := l.Allow(context.Background(), "ip:1", rule)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Allowed != step.allowed || got.RetryAfter != step.retryAfter {
+					t.Fatalf("%s: got %+v, want allowed %v, retry after %v", step.name, got, step.allowed, step.retryAfter)
+				}
+			}
+
+			// Other keys have their own buckets.
+			got, err := l.Allow(context.Background(), "ip:2", rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Allowed {
+				t.Fatalf("another key: got %+v, want allowed", got)
+			}
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	lockout := Lockout{Threshold: 3, Base: time.Minute, Max: 4 * time.Minute}
+	steps := []struct {
+		name string
+		// fail records a failure; otherwise the lock is checked.
+		fail       bool
+		reset      bool
+		advance    time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{name: "first failure", fail: true, allowed: true},
+		{name: "second failure", fail: true, allowed: true},
+		{name: "not locked below the threshold", allowed: true},
+		{name: "locked at the threshold", fail: true, retryAfter: time.Minute},
+		{name: "check while locked", advance: 30 * time.Second, retryAfter: 30 * time.Second},
+		{name: "lock ends", advance: 30 * time.Second, allowed: true},
+		{name: "lock doubles", fail: true, retryAfter: 2 * time.Minute},
+		{name: "lock doubles again", fail: true, retryAfter: 4 * time.Minute},
+		{name: "lock capped", fail: true, retryAfter: 4 * time.Minute},
+		{name: "reset unlocks", reset: true, allowed: true},
+		{name: "failures counted from zero after reset", fail: true, allowed: true},
+	}
+
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	for _, s := range newTestStores(t, c) {
+		t.Run(s.name, func(t *testing.T) {
+			l := newTestLimiter(s.store, c)
+			ctx := context.Background()
+			for _, step := range steps {
+				c.advance(step.advance)
+				var got Result
+				var err error
+				switch {
+				case step.reset:
+					err = l.Reset(ctx, "account:user@example.com")
+					if err == nil {
+						got, err = l.Check(ctx, "account:user@example.com")
+					}
+				case step.fail:
+					got, err = l.Fail(ctx, "account:user@example.com", lockout)
+				default:
+					got, err = l.Check(ctx, "account:user@example.com")
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Allowed != step.allowed || got.RetryAfter != step.retryAfter {
+					t.Fatalf("%s: got %+v, want allowed %v, retry after %v", step.name, got, step.allowed, step.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreSweepsIdleBuckets(t *testing.T) {
+	rule := Rule{Limit: 3, Period: time.Minute}
+	lockout := Lockout{Threshold: 1, Base: 2 * time.Hour, Max: 2 * time.Hour}
+
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	for _, s := range newTestStores(t, c) {
+		t.Run(s.name, func(t *testing.T) {
+			l := newTestLimiter(s.store, c)
+			ctx := context.Background()
+			// The first update sweeps, so the buckets below are only swept
+			// once idleTTL has passed again.
+			_, err := l.Allow(ctx, "ip:first", rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = l.Allow(ctx, "ip:idle", rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = l.Fail(ctx, "account:locked", lockout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := s.count(); n != 3 {
+				t.Fatalf("got %d buckets, want 3", n)
+			}
+
+			c.advance(idleTTL + time.Minute)
+			_, err = l.Allow(ctx, "ip:new", rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The idle buckets are gone; the locked one and the new one stay.
+			if n := s.count(); n != 2 {
+				t.Fatalf("got %d buckets after the sweep, want 2", n)
+			}
+			got, err := l.Check(ctx, "account:locked")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Allowed {
+				t.Fatal("sweep dropped a bucket that's still locked")
+			}
+		})
+	}
+}
+
+func TestSQLiteStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.db")
+	c := &clock{t: time.Unix(1_700_000_000, 0)}
+	lockout := Lockout{Threshold: 1, Base: time.Minute, Max: time.Minute}
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.now = c.now
+	_, err = newTestLimiter(store, c).Fail(context.Background(), "account:user@example.com", lockout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A restarted server, or another one sharing the file, sees the lock.
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.now = c.now
+	c.advance(20 * time.Second)
+	got, err := newTestLimiter(store, c).Check(context.Background(), "account:user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Allowed || got.RetryAfter != 40*time.Second {
+		t.Fatalf("got %+v, want locked for another 40s", got)
+	}
+}
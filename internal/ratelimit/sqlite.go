@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore keeps buckets in a SQLite database so limits and lockouts
+// survive restarts and can be shared by processes using the same file.
+type SQLiteStore struct {
+	db  *sql.DB
+	now func() time.Time
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewSQLiteStore(pathToDB string) (*SQLiteStore, error) {
+	// Immediate transactions take the write lock up front, so two processes
+	// can't both read a bucket and then overwrite each other's update.
+	db, err := sql.Open("sqlite3", pathToDB+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens REAL NOT NULL,
+		updated_at INTEGER NOT NULL,
+		failures INTEGER NOT NULL,
+		locked_until INTEGER NOT NULL
+	);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, now: time.Now}, nil
+}
+
+func (s *SQLiteStore) Update(ctx context.Context, key string, fn func(b *Bucket)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var b Bucket
+	var updatedAt, lockedUntil int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT tokens, updated_at, failures, locked_until
+		FROM rate_limits
+		WHERE key = ?
+	`, key).Scan(&b.Tokens, &updatedAt, &b.Failures, &lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		b.UpdatedAt = fromUnixNano(updatedAt)
+		b.LockedUntil = fromUnixNano(lockedUntil)
+	}
+
+	fn(&b)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_limits (key, tokens, updated_at, failures, locked_until)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = excluded.tokens,
+			updated_at = excluded.updated_at,
+			failures = excluded.failures,
+			locked_until = excluded.locked_until
+	`, key, b.Tokens, toUnixNano(b.UpdatedAt), b.Failures, toUnixNano(b.LockedUntil))
+	if err != nil {
+		return err
+	}
+	err = s.sweep(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sweep deletes idle buckets at most once per idleTTL, so keys that are
+// never seen again, like the IPs of one-off visitors, don't pile up.
+func (s *SQLiteStore) sweep(ctx context.Context, tx *sql.Tx) error {
+	now := s.now()
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < idleTTL {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM rate_limits
+		WHERE updated_at < ? AND locked_until <= ?
+	`, now.Add(-idleTTL).UnixNano(), now.UnixNano())
+	return err
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE key = ?", key)
+	return err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
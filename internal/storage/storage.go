@@ -0,0 +1,34 @@
+// Package storage abstracts the blob stores that hold uploaded media.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Get when no object exists at the key.
+var ErrNotFound = errors.New("object not found")
+
+// Presigner is implemented by stores that can hand out time-limited links
+// to their objects.
+type Presigner interface {
+	// PresignGet returns a URL the object at key can be fetched from
+	// until expires has passed.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// Store keeps blobs under slash-separated keys. Deleting a key that doesn't
+// exist is not an error, so cleanup can safely be retried.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the key of every object in the store, in no particular
+	// order.
+	List(ctx context.Context) ([]string, error)
+	// Ping checks that the store can be reached, without touching any
+	// object.
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveQuery records a database statement as a span under the span in
+// ctx. Statements run outside a traced request or job aren't recorded. It
+// is a database.QueryHook.
+func ObserveQuery(ctx context.Context, query database.Query) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	_, span := Start(ctx, "db "+query.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(query.Start),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationName(query.Name),
+		),
+	)
+	if query.Err != nil {
+		span.RecordError(query.Err)
+		span.SetStatus(codes.Error, query.Err.Error())
+	}
+	span.End(trace.WithTimestamp(query.Start.Add(query.Duration)))
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartRequest starts a server span for r, continuing the trace from the
+// client's traceparent header if it sent one, and returns a copy of r
+// carrying it. The trace ID is added to the request's log attributes.
+func StartRequest(r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		),
+	)
+	addTraceID(ctx)
+	return r.WithContext(ctx), span
+}
+
+// EndRequest names the span after the mux pattern that served r, records
+// the response status and ends it. r must be the request StartRequest
+// returned, as that's the one the mux sets the pattern on.
+func EndRequest(span trace.Span, r *http.Request, status int) {
+	// Patterns may or may not start with the method; routes don't.
+	route := strings.TrimPrefix(r.Pattern, r.Method+" ")
+	if route == "" {
+		route = "unmatched"
+	}
+	span.SetName(r.Method + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRoute(route),
+		semconv.HTTPResponseStatusCode(status),
+	)
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx in its W3C traceparent form, or
+// "" if ctx isn't part of a trace, so work queued for later can be linked
+// back to it.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns a copy of ctx continuing the trace a traceparent from
+// Inject refers to. It returns ctx unchanged if traceparent is empty or
+// malformed.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// addTraceID adds the trace ID of the span in ctx to its log attributes.
+func addTraceID(ctx context.Context) {
+	sc := trace.SpanContextFromContext(ctx)
+	if sc.HasTraceID() {
+		logging.Add(ctx, slog.String(logging.KeyTraceID, sc.TraceID().String()))
+	}
+}
@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapJob wraps a job handler so each run is recorded as a span. A job can
+// run long after the request that queued it has finished, so it starts a
+// trace of its own, linked to the request's.
+func WrapJob(handler jobs.Handler) jobs.Handler {
+	return func(ctx context.Context, job database.Job) error {
+		opts := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithNewRoot(),
+			trace.WithAttributes(
+				attribute.String("job.id", job.ID.String()),
+				attribute.String("job.kind", job.Kind),
+				attribute.Int("job.attempt", job.Attempts),
+			),
+		}
+		parent := trace.SpanContextFromContext(Extract(context.Background(), job.TraceParent))
+		if parent.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: parent}))
+		}
+		ctx, span := Start(ctx, "job "+job.Kind, opts...)
+		addTraceID(ctx)
+		err := handler(ctx, job)
+		End(span, err)
+		return err
+	}
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentStore wraps a blob store so each operation is recorded as a
+// span, with the backend and key as attributes. A Get that finds nothing
+// isn't marked as failed.
+func InstrumentStore(backend string, store storage.Store) storage.Store {
+	return tracedStore{store: store, backend: backend}
+}
+
+type tracedStore struct {
+	store   storage.Store
+	backend string
+}
+
+func (s tracedStore) start(ctx context.Context, operation, key string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("storage.backend", s.backend)}
+	if key != "" {
+		attrs = append(attrs, attribute.String("storage.key", key))
+	}
+	return Start(ctx, "storage "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func (s tracedStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	ctx, span := s.start(ctx, "put", key)
+	err := s.store.Put(ctx, key, body, contentType)
+	End(span, err)
+	return err
+}
+
+func (s tracedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	ctx, span := s.start(ctx, "get", key)
+	body, err := s.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		span.SetAttributes(attribute.Bool("storage.not_found", true))
+		End(span, nil)
+	} else {
+		End(span, err)
+	}
+	return body, err
+}
+
+func (s tracedStore) Delete(ctx context.Context, key string) error {
+	ctx, span := s.start(ctx, "delete", key)
+	err := s.store.Delete(ctx, key)
+	End(span, err)
+	return err
+}
+
+func (s tracedStore) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "ping", "")
+	err := s.store.Ping(ctx)
+	End(span, err)
+	return err
+}
+
+func (s tracedStore) List(ctx context.Context) ([]string, error) {
+	ctx, span := s.start(ctx, "list", "")
+	keys, err := s.store.List(ctx)
+	span.SetAttributes(attribute.Int("storage.keys", len(keys)))
+	End(span, err)
+	return keys, err
+}
@@ -0,0 +1,241 @@
+// Package validate checks request structs against rules declared in their
+// `validate` struct tags, for example:
+//
+//	Title string `json:"title" validate:"required,max=200"`
+//	Role  string `json:"role" validate:"required,oneof=viewer editor owner"`
+//
+// Rules are separated by commas:
+//
+//	required   the field must be set; strings must not be blank
+//	nonblank   if the field is set at all, it must not be blank; for optional
+//	           pointer fields in partial updates
+//	min=N      strings and lists need at least N characters or items, and
+//	           numbers must be at least N
+//	max=N      the same, at most
+//	maxbytes=N strings of at most N bytes once UTF-8 encoded, for limits
+//	           such as bcrypt's that count bytes rather than characters
+//	email      a bare email address
+//	oneof=A B  one of the space-separated values
+//	uuid       a UUID in its canonical form
+//	url        an absolute http or https URL with a host
+//
+// Rules other than required are skipped for empty values, so optional fields
+// are only checked when given. Embedded structs are checked as part of the
+// struct that embeds them.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FieldError is one failed rule. Field is the field's JSON name.
+type FieldError struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Struct checks every field of the struct v points to and returns one error
+// per failing field, in field order.
+func Struct(v any) []FieldError {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	errs := []FieldError{}
+	checkStruct(rv, &errs)
+	return errs
+}
+
+func checkStruct(rv reflect.Value, errs *[]FieldError) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		value := rv.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			checkStruct(value, errs)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if err, ok := checkField(jsonName(field), value, tag); !ok {
+			*errs = append(*errs, err)
+		}
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// checkField applies a field's rules in order and reports the first that
+// fails.
+func checkField(name string, value reflect.Value, tag string) (FieldError, bool) {
+	given := !(value.Kind() == reflect.Pointer && value.IsNil())
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			break
+		}
+		value = value.Elem()
+	}
+	empty := isEmpty(value)
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if rule == "required" {
+			if empty {
+				return FieldError{name, "required", "is required"}, false
+			}
+			continue
+		}
+		if rule == "nonblank" {
+			if given && empty {
+				return FieldError{name, "blank", "can't be blank"}, false
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		switch rule {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validate: bad %s limit %q on %s", rule, arg, name))
+			}
+			if err, ok := checkLimit(name, value, rule, limit); !ok {
+				return err, false
+			}
+		case "maxbytes":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: bad %s limit %q on %s", rule, arg, name))
+			}
+			if value.Kind() != reflect.String {
+				panic(fmt.Sprintf("validate: %s doesn't apply to %s", rule, value.Kind()))
+			}
+			if len(value.String()) > limit {
+				return FieldError{name, "too_long", "must be at most " + arg + " bytes"}, false
+			}
+		case "email":
+			s := value.String()
+			addr, err := mail.ParseAddress(s)
+			if err != nil || addr.Address != s {
+				return FieldError{name, "invalid_email", "must be a valid email address"}, false
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			s := fmt.Sprint(value.Interface())
+			found := false
+			for _, option := range options {
+				if s == option {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return FieldError{name, "invalid_choice", "must be one of " + joinChoices(options)}, false
+			}
+		case "uuid":
+			_, err := uuid.Parse(value.String())
+			if err != nil {
+				return FieldError{name, "invalid_uuid", "must be a UUID"}, false
+			}
+		case "url":
+			u, err := url.Parse(value.String())
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return FieldError{name, "invalid_url", "must be an http or https URL"}, false
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+	}
+	return FieldError{}, true
+}
+
+func checkLimit(name string, value reflect.Value, rule string, limit float64) (FieldError, bool) {
+	var n float64
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		n = float64(utf8.RuneCountInString(value.String()))
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(value.Len())
+		unit = " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = value.Float()
+	default:
+		panic(fmt.Sprintf("validate: %s doesn't apply to %s", rule, value.Kind()))
+	}
+
+	limitText := strconv.FormatFloat(limit, 'f', -1, 64)
+	if rule == "min" && n < limit {
+		if unit == "" {
+			return FieldError{name, "too_small", "must be at least " + limitText}, false
+		}
+		return FieldError{name, "too_short", "must have at least " + limitText + unit}, false
+	}
+	if rule == "max" && n > limit {
+		if unit == "" {
+			return FieldError{name, "too_large", "must be at most " + limitText}, false
+		}
+		return FieldError{name, "too_long", "must have at most " + limitText + unit}, false
+	}
+	return FieldError{}, true
+}
+
+// isEmpty reports whether a value counts as not given: nil, a blank string,
+// or an empty list. Zero numbers and false are values in their own right.
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return false
+}
+
+func joinChoices(options []string) string {
+	if len(options) <= 1 {
+		return strings.Join(options, "")
+	}
+	return strings.Join(options[:len(options)-1], ", ") + " or " + options[len(options)-1]
+}
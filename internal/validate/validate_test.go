@@ -0,0 +1,51 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxBytes(t *testing.T) {
+	type params struct {
+		Password string `json:"password" validate:"required,maxbytes=72"`
+	}
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"ASCII at the limit", strings.Repeat("a", 72), false},
+		{"ASCII over the limit", strings.Repeat("a", 73), true},
+		// 37 characters, but 74 bytes.
+		{"multibyte over the limit", strings.Repeat("é", 37), true},
+		{"multibyte at the limit", strings.Repeat("é", 36), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Struct(&params{Password: tt.password})
+			if !tt.wantErr {
+				if len(errs) != 0 {
+					t.Fatalf("got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != "password" || errs[0].Code != "too_long" {
+				t.Fatalf("got %v, want password too_long", errs)
+			}
+		})
+	}
+}
+
+func TestMaxCountsCharacters(t *testing.T) {
+	type params struct {
+		Title string `json:"title" validate:"max=5"`
+	}
+	errs := Struct(&params{Title: "héllo"})
+	if len(errs) != 0 {
+		t.Fatalf("got %v for 5 characters", errs)
+	}
+	errs = Struct(&params{Title: "héllos"})
+	if len(errs) != 1 || errs[0].Code != "too_long" {
+		t.Fatalf("got %v for 6 characters", errs)
+	}
+}
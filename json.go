@@ -2,33 +2,235 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validate"
+)
+
+// maxJSONBodyBytes caps JSON request bodies. Nothing the API accepts as JSON
+// comes close; uploads use multipart forms with their own limits.
+const maxJSONBodyBytes = 1 << 20
+
+// problem is an RFC 7807 problem details response. Code is a stable,
+// machine-readable identifier clients can branch on; Detail is meant for
+// people and may change.
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError describes one invalid field in a request body. Field is the
+// JSON name of the field.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes that are more specific than the status code alone.
+const (
+	codeMalformedJSON      = "malformed_json"
+	codeEmptyBody          = "empty_body"
+	codeUnknownField       = "unknown_field"
+	codeValidationFailed   = "validation_failed"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeTokenMissing       = "token_missing"
+	codeTokenInvalid       = "token_invalid"
+	codeInsufficientScope  = "insufficient_scope"
+	codeInvalidCredentials = "invalid_credentials"
+	codeInvalidMFACode     = "invalid_mfa_code"
+	codeAccountDisabled    = "account_disabled"
+	codeEmailUnverified    = "email_unverified"
+	codeEmailTaken         = "email_taken"
+	codeQuotaExceeded      = "quota_exceeded"
+	codeRateLimited        = "rate_limited"
 )
 
+// statusCodes are the default error codes for each status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  codeUnsupportedMedia,
+	http.StatusUnprocessableEntity:   codeValidationFailed,
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// respondWithError responds with a problem whose code is the default for the
+// status. err is logged with the request but never sent to the client.
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithErrorCode(w, code, statusCode(code), msg, err)
+}
+
+// respondWithErrorCode is respondWithError with a specific error code.
+func respondWithErrorCode(w http.ResponseWriter, status int, code, msg string, err error) {
+	if err == nil && status > 499 {
+		err = errors.New(msg)
+	}
 	if err != nil {
-		log.Println(err)
+		logResponseError(w, err)
 	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+	respondWithProblem(w, problem{
+		Status: status,
+		Detail: msg,
+		Code:   code,
+	})
+}
+
+// respondWithValidationErrors responds with 422 and every invalid field.
+func respondWithValidationErrors(w http.ResponseWriter, errs []fieldError) {
+	respondWithProblem(w, problem{
+		Status: http.StatusUnprocessableEntity,
+		Detail: "The request has invalid fields",
+		Code:   codeValidationFailed,
+		Errors: errs,
+	})
+}
+
+func respondWithProblem(w http.ResponseWriter, p problem) {
+	if p.Type == "" {
+		p.Type = "urn:tubely:problem:" + p.Code
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
 	}
-	respondWithJSON(w, code, errorResponse{
-		Error: msg,
-	})
+	dat, err := json.Marshal(p)
+	if err != nil {
+		logResponseError(w, fmt.Errorf("couldn't marshal JSON: %w", err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(dat)
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		logResponseError(w, fmt.Errorf("couldn't marshal JSON: %w", err))
 		w.WriteHeader(500)
 		return
 	}
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+// decodeJSON decodes a JSON request body into v and checks it against v's
+// validate tags, responding with the right problem and returning false if
+// either fails. A missing Content-Type is tolerated; any other type than JSON
+// is not. Fields v doesn't declare are rejected rather than ignored.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			respondWithError(w, http.StatusUnsupportedMediaType, "Request body must be JSON", err)
+			return false
+		}
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return validateParams(w, v)
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, io.EOF):
+		respondWithErrorCode(w, http.StatusBadRequest, codeEmptyBody, "Request body is empty", nil)
+	case errors.As(err, &maxBytesErr):
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must be at most %d bytes", maxBytesErr.Limit), nil)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondWithValidationErrors(w, []fieldError{{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: fmt.Sprintf("must be a %s", jsonTypeName(typeErr.Type.Kind().String())),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		respondWithValidationErrors(w, []fieldError{{
+			Field:   field,
+			Code:    codeUnknownField,
+			Message: "is not a known field",
+		}})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &typeErr):
+		respondWithErrorCode(w, http.StatusBadRequest, codeMalformedJSON, "Request body is not valid JSON", nil)
+	default:
+		respondWithErrorCode(w, http.StatusBadRequest, codeMalformedJSON, "Couldn't decode parameters", err)
+	}
+	return false
+}
+
+// validateParams checks v against its validate tags and responds with every
+// failing field, returning false if any fail.
+func validateParams(w http.ResponseWriter, v any) bool {
+	errs := validate.Struct(v)
+	if len(errs) == 0 {
+		return true
+	}
+	fieldErrs := make([]fieldError, 0, len(errs))
+	for _, err := range errs {
+		fieldErrs = append(fieldErrs, fieldError{
+			Field:   err.Field,
+			Code:    err.Code,
+			Message: err.Message,
+		})
+	}
+	respondWithValidationErrors(w, fieldErrs)
+	return false
+}
+
+// respondWithFormFileError responds to a failure to read an uploaded file,
+// telling an oversized upload apart from a malformed one.
+func respondWithFormFileError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload must be at most %d bytes", maxBytesErr.Limit), nil)
+		return
+	}
+	respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+}
+
+// jsonTypeName names a Go kind the way a JSON client would think of it.
+func jsonTypeName(kind string) string {
+	switch {
+	case kind == "string":
+		return "string"
+	case kind == "bool":
+		return "boolean"
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice", kind == "array":
+		return "list"
+	}
+	return "object"
+}
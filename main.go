@@ -2,135 +2,342 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/health"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db             database.Client
+	jwtSecret      string
+	platform       string
+	filepathRoot   string
+	assetsRoot     string
+	port           string
+	publicURL      string
+	thumbnailStore storage.Store
+	videoStore     storage.Store
+	exportStore    storage.Store
+	mailer         mailer.Mailer
+	limiter        *ratelimit.Limiter
+	oidc           *oidc.Client
+	jobs           *jobs.Runner
+	metrics        *metrics.Metrics
+	// progress carries videos' upload and processing progress to the
+	// events endpoint.
+	progress *progress.Broker
+	// webhookClient sends webhook deliveries.
+	webhookClient *http.Client
+	// videoBaseURL is where videos in the video store are served from.
+	videoBaseURL string
+	// videoPresigner makes the links to videos that aren't public when
+	// they're stored in S3.
+	videoPresigner storage.Presigner
+	// importRoot confines imports through the API; they're off when it's
+	// empty.
+	importRoot string
+	readiness  *health.Checker
+	// trustProxyHeaders makes rate limiting key on X-Forwarded-For.
+	trustProxyHeaders bool
+	// tempDir holds this process's temp files; it's removed on shutdown.
+	// tempDirLock holds the directory's lock, so other processes don't
+	// remove it as stale.
+	tempDir     string
+	tempDirLock *os.File
 }
 
 func main() {
 	godotenv.Load(".env")
+	slog.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+	os.Exit(runCLI(os.Args[1:]))
+}
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+// runServe runs the HTTP server and job workers until ctx is done, then
+// shuts down gracefully.
+func runServe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tubely serve", flag.ContinueOnError)
+	loader := config.NewLoader(fs)
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	err := fs.Parse(args)
+	if err != nil {
+		return usageError(err)
+	}
+	conf, err := loader.Load()
+	if err != nil {
+		return err
+	}
+	if *printConfig {
+		return conf.WriteYAML(os.Stdout)
 	}
 
-	db, err := database.NewClient(pathToDB)
+	logLevel, err := logging.ParseLevel(conf.Log.Level)
 	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+		return fmt.Errorf("couldn't configure logging: %w", err)
 	}
+	slog.SetDefault(logging.New(os.Stdout, logLevel))
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// The OTLP exporter reads the standard OTEL_EXPORTER_OTLP_* variables.
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    conf.Tracing.Exporter,
+		File:        conf.Tracing.File,
+		ServiceName: "tubely",
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't configure tracing: %w", err)
 	}
+	// Deferred first so traces are flushed last, after everything else has
+	// shut down.
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(flushCtx)
+		if err != nil {
+			slog.Error("Couldn't flush traces", "error", err)
+		}
+	}()
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	cfg, err := newAPIConfig(conf)
+	if err != nil {
+		return err
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	if conf.Auth.AdminEmail != "" {
+		err = promoteAdmin(cfg.db, conf.Auth.AdminEmail)
+		if err != nil {
+			cfg.close()
+			return fmt.Errorf("couldn't promote admin user: %w", err)
+		}
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	oidcClient, mockOIDC, err := newOIDCClient(conf.OIDC, conf.Server.PublicURL)
+	if err != nil {
+		cfg.close()
+		return fmt.Errorf("couldn't configure single sign-on: %w", err)
 	}
+	cfg.oidc = oidcClient
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	rateLimitStore, err := newRateLimitStore(conf.RateLimit)
+	if err != nil {
+		cfg.close()
+		return fmt.Errorf("couldn't configure rate limiting: %w", err)
 	}
+	cfg.limiter = ratelimit.New(rateLimitStore)
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	cfg.readiness = cfg.readinessChecks()
+
+	mux := cfg.routes(mockOIDC)
+	// The tests keep the document in sync, so a mismatch here is a build
+	// that skipped them; the routes still work, only the reference is off.
+	err = checkOpenAPI(mux.patterns)
+	if err != nil {
+		slog.Warn("The OpenAPI document doesn't match the routes", "error", err)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	cfg.registerJobs()
+	go cfg.jobs.Run(context.Background())
+
+	requests := &inFlightRequests{}
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:    ":" + cfg.port,
+		Handler: requests.middleware(requestIDMiddleware(tracingMiddleware(accessLogMiddleware(cfg.metricsMiddleware(mux))))),
+		// Cancelled if requests are still running at the shutdown deadline.
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Serving on: http://localhost:" + cfg.port + "/app/")
+		serveErr <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+		// A second signal kills the process without waiting.
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		slog.Info("Shutting down", "timeout", conf.Server.ShutdownTimeout.String())
 	}
 
-	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+	cfg.shutdown(srv, requests, cancelRequests, conf.Server.ShutdownTimeout)
+	closeRateLimitStore(rateLimitStore)
+	closeErr := cfg.db.Close()
+	if closeErr != nil {
+		slog.Error("Couldn't close database", "error", closeErr)
+	}
+	if err != nil {
+		return err
 	}
+	slog.Info("Shutdown complete")
+	return nil
+}
 
-	err = cfg.ensureAssetsDir()
+// closeRateLimitStore closes a rate limit store that holds resources, such
+// as the SQLite store's database.
+func closeRateLimitStore(store ratelimit.Store) {
+	closer, ok := store.(io.Closer)
+	if !ok {
+		return
+	}
+	err := closer.Close()
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		slog.Error("Couldn't close rate limit store", "error", err)
 	}
+}
 
-	ctx := context.Background()
-	config, err := config.LoadDefaultConfig(ctx, config.WithRegion(cfg.s3Region))
+// newAPIConfig opens the database and blob stores and sets up everything
+// else the server and the admin commands share. It starts nothing.
+func newAPIConfig(conf *config.Config) (*apiConfig, error) {
+	db, err := database.NewClient(conf.Database.Path)
 	if err != nil {
-		log.Fatalf("Couldn't load AWS config: %v", err)
+		return nil, fmt.Errorf("couldn't connect to database: %w", err)
 	}
-	cfg.s3Client = s3.NewFromConfig(config)
+	appMetrics := metrics.New()
+	db.OnQuery(appMetrics.ObserveQuery)
+	db.OnQuery(tracing.ObserveQuery)
+	appMetrics.WatchJobQueue(db)
 
-	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/", appHandler)
+	cfg := &apiConfig{
+		db:            db,
+		jwtSecret:     conf.Auth.JWTSecret,
+		platform:      conf.Platform,
+		filepathRoot:  conf.Server.FilepathRoot,
+		assetsRoot:    conf.Storage.AssetsRoot,
+		port:          conf.Server.Port,
+		publicURL:     conf.Server.PublicURL,
+		importRoot:    conf.Import.Root,
+		mailer:        newMailer(conf.Mail),
+		jobs:          jobs.NewRunner(db),
+		metrics:       appMetrics,
+		progress:      progress.New(),
+		webhookClient: newWebhookClient(),
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+		trustProxyHeaders: conf.Server.TrustProxyHeaders,
+	}
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	err = cfg.ensureAssetsDir()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't create assets directory: %w", err)
+	}
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	cfg.tempDir, cfg.tempDirLock, err = newTempDir()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't create temp directory: %w", err)
+	}
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	cfg.thumbnailStore = cfg.instrumentStore("local", storage.LocalStore{Root: cfg.assetsRoot})
+	cfg.exportStore = cfg.instrumentStore("local", storage.LocalStore{Root: conf.Storage.ExportsRoot})
+	err = cfg.configureVideoStore(context.Background(), conf.Storage)
+	if err != nil {
+		cfg.close()
+		return nil, fmt.Errorf("couldn't configure video storage: %w", err)
+	}
+	return cfg, nil
+}
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+// close removes the temp directory and closes the database, for commands
+// that don't go through the server's shutdown.
+func (cfg *apiConfig) close() error {
+	return errors.Join(cfg.removeTempDir(), cfg.db.Close())
+}
 
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+// instrumentStore wraps a blob store with metrics and tracing.
+func (cfg *apiConfig) instrumentStore(backend string, store storage.Store) storage.Store {
+	return cfg.metrics.InstrumentStore(backend, tracing.InstrumentStore(backend, store))
+}
+
+func promoteAdmin(db database.Client, email string) error {
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		slog.Warn("ADMIN_EMAIL has no account yet; sign up and restart to promote it", "email", email)
+		return nil
+	}
+	if user.Role == string(auth.RoleAdmin) {
+		return nil
+	}
+	return db.SetUserRole(user.ID, string(auth.RoleAdmin))
+}
+
+// newOIDCClient configures single sign-on, which is off when no issuer is
+// set. The mock issuer serves a mock provider from this server under
+// /mock-oidc so the flow can be exercised locally; config only allows it in
+// dev.
+func newOIDCClient(conf config.OIDCConfig, publicURL string) (*oidc.Client, *oidc.MockProvider, error) {
+	if conf.Issuer == "" {
+		return nil, nil, nil
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	var mock *oidc.MockProvider
+	if conf.Issuer == config.OIDCMockIssuer {
+		if conf.ClientID == "" {
+			conf.ClientID = "tubely"
+		}
+		if conf.ClientSecret == "" {
+			conf.ClientSecret = "mock-secret"
+		}
+		conf.Issuer = publicURL + "/mock-oidc"
+		var err error
+		mock, err = oidc.NewMockProvider(conf.Issuer, conf.ClientID, conf.ClientSecret)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	client := oidc.NewClient(oidc.Config{
+		Issuer:       conf.Issuer,
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURL,
+	})
+	return client, mock, nil
+}
+
+// newMailer configures outgoing email: smtp relays through an SMTP server,
+// file writes messages to a directory, and log logs them.
+func newMailer(conf config.MailConfig) mailer.Mailer {
+	switch conf.Backend {
+	case config.MailerFile:
+		return mailer.FileMailer{Dir: conf.Dir, From: conf.From}
+	case config.MailerSMTP:
+		return mailer.SMTPMailer{
+			Host:     conf.SMTP.Host,
+			Port:     conf.SMTP.Port,
+			Username: conf.SMTP.Username,
+			Password: conf.SMTP.Password,
+			From:     conf.From,
+		}
+	default:
+		return mailer.LogMailer{}
+	}
 }
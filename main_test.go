@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// newTestConfig sets up the server as it runs in dev, on a fresh database
+// with local storage, all under the test's temp directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	conf := config.Default()
+	conf.Platform = "dev"
+	conf.Database.Path = filepath.Join(dir, "tubely.db")
+	conf.Auth.JWTSecret = "test-secret"
+	conf.Server.PublicURL = "http://localhost:" + conf.Server.Port
+	conf.Server.FilepathRoot = filepath.Join(dir, "app")
+	conf.Storage.AssetsRoot = filepath.Join(dir, "assets")
+	conf.Storage.ExportsRoot = filepath.Join(dir, "exports")
+	conf.Storage.Videos = config.VideoStoreLocal
+
+	cfg, err := newAPIConfig(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cfg.close() })
+	cfg.limiter = ratelimit.New(ratelimit.NewMemoryStore())
+	cfg.readiness = cfg.readinessChecks()
+	return cfg
+}
+
+// newTestServer serves cfg's routes until the test ends.
+func newTestServer(t *testing.T, cfg *apiConfig) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(cfg.routes(nil))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// createTestUser creates an account with the given password.
+func createTestUser(t *testing.T, cfg *apiConfig, email, password string) database.User {
+	t.Helper()
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hashedPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user
+}
+
+// testSession is the response to a successful login.
+type testSession struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// loginTestUser signs in and returns the new session.
+func loginTestUser(t *testing.T, srv *httptest.Server, email, password string) testSession {
+	t.Helper()
+	resp := sendTestRequest(t, srv, http.MethodPost, "/api/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	var session testSession
+	decodeTestResponse(t, resp, http.StatusOK, &session)
+	return session
+}
+
+// sendTestRequest sends a request to srv, authenticated with token unless
+// it's empty. A non-nil body is sent as JSON.
+func sendTestRequest(t *testing.T, srv *httptest.Server, method, path, token string, body any) *http.Response {
+	t.Helper()
+	return doTestRequest(t, srv, newTestRequest(t, srv, method, path, token, body))
+}
+
+// newTestRequest is the request sendTestRequest sends, for tests that need
+// to set more headers on it.
+func newTestRequest(t *testing.T, srv *httptest.Server, method, path, token string, body any) *http.Request {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// doTestRequest sends req to srv.
+func doTestRequest(t *testing.T, srv *httptest.Server, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeTestResponse fails the test unless resp has the wanted status, and
+// decodes its JSON body into out unless out is nil.
+func decodeTestResponse(t *testing.T, resp *http.Response, wantStatus int, out any) {
+	t.Helper()
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: got status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, wantStatus, dat)
+	}
+	if out == nil {
+		return
+	}
+	err = json.Unmarshal(dat, out)
+	if err != nil {
+		t.Fatalf("%s %s: couldn't decode response: %v", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+}
+
+// problemCode returns the code of a problem response.
+func problemCode(t *testing.T, resp *http.Response, wantStatus int) string {
+	t.Helper()
+	var problem struct {
+		Code string `json:"code"`
+	}
+	decodeTestResponse(t, resp, wantStatus, &problem)
+	return problem.Code
+}
+
+// requestPath returns the path and query of a URL the server handed out.
+func requestPath(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.RequestURI()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPIDocument describes every route the server registers. checkOpenAPI
+// holds the two together: TestOpenAPIMatchesRoutes fails if a route is
+// missing from the document, or the document describes a route that
+// doesn't exist, and the server logs a warning on startup.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// undocumentedRoutes are registered but left out of the document on
+// purpose.
+var undocumentedRoutes = map[string]bool{
+	// The mock identity provider only runs in dev, standing in for a real
+	// one.
+	"/mock-oidc/": true,
+}
+
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// routeMux is a ServeMux that remembers the patterns registered on it, so
+// they can be checked against the OpenAPI document.
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouteMux() *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux()}
+}
+
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// checkOpenAPI reports every route pattern the OpenAPI document doesn't
+// describe, and every operation in it that isn't a route. Patterns without
+// a method are documented as GET, and a pattern ending in a slash, which
+// serves everything under it, as a {path} beneath it.
+func checkOpenAPI(patterns []string) error {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(openAPIDocument, &doc)
+	if err != nil {
+		return fmt.Errorf("couldn't parse OpenAPI document: %w", err)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	var problems []string
+	for _, pattern := range patterns {
+		if undocumentedRoutes[pattern] {
+			continue
+		}
+		method, path, ok := strings.Cut(pattern, " ")
+		if !ok {
+			method, path = http.MethodGet, pattern
+		}
+		if strings.HasSuffix(path, "/") {
+			path += "{path}"
+		}
+		operation := method + " " + path
+		switch {
+		case documented[operation]:
+			delete(documented, operation)
+		case operation == pattern:
+			problems = append(problems, fmt.Sprintf("%s isn't documented", pattern))
+		default:
+			problems = append(problems, fmt.Sprintf("%s isn't documented, as %s", pattern, operation))
+		}
+	}
+	for operation := range documented {
+		problems = append(problems, fmt.Sprintf("%s is documented but isn't a route", operation))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "; "))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	cfg := newTestConfig(t)
+	mockOIDC, err := oidc.NewMockProvider("http://localhost/mock-oidc", "tubely", "mock-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := cfg.routes(mockOIDC)
+	err = checkOpenAPI(mux.patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOpenAPIReportsDrift(t *testing.T) {
+	cfg := newTestConfig(t)
+	patterns := append(cfg.routes(nil).patterns, "GET /api/undocumented")
+	err := checkOpenAPI(patterns[1:])
+	if err == nil {
+		t.Fatal("checkOpenAPI accepted routes that don't match the document")
+	}
+	want := "GET /api/undocumented isn't documented; GET /app/{path} is documented but isn't a route"
+	if err.Error() != want {
+		t.Fatalf("got %q, want %q", err, want)
+	}
+}
+
+func TestHandlerOpenAPI(t *testing.T) {
+	srv := newTestServer(t, newTestConfig(t))
+	resp := sendTestRequest(t, srv, http.MethodGet, "/api/openapi.json", "", nil)
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+	}
+	decodeTestResponse(t, resp, http.StatusOK, &doc)
+	if doc.OpenAPI == "" {
+		t.Fatal("served document has no openapi version")
+	}
+}
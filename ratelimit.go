@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+var (
+	loginIPRule        = ratelimit.Rule{Limit: 20, Period: time.Minute}
+	loginAccountRule   = ratelimit.Rule{Limit: 10, Period: time.Minute}
+	signupIPRule       = ratelimit.Rule{Limit: 10, Period: time.Hour}
+	signupAccountRule  = ratelimit.Rule{Limit: 3, Period: time.Hour}
+	refreshIPRule      = ratelimit.Rule{Limit: 60, Period: time.Minute}
+	refreshAccountRule = ratelimit.Rule{Limit: 30, Period: time.Minute}
+	emailChangeRule    = ratelimit.Rule{Limit: 3, Period: time.Hour}
+	dataExportRule     = ratelimit.Rule{Limit: 3, Period: 24 * time.Hour}
+
+	// loginLockout locks an account after 5 consecutive bad passwords, for a
+	// minute at first and doubling with each further failure up to an hour.
+	loginLockout = ratelimit.Lockout{Threshold: 5, Base: time.Minute, Max: time.Hour}
+)
+
+func ipKey(scope, ip string) string {
+	return scope + ":ip:" + ip
+}
+
+func accountKey(scope, account string) string {
+	return scope + ":account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func lockoutKey(email string) string {
+	return accountKey("lockout", email)
+}
+
+// clientIP returns the address the request came from. X-Forwarded-For is only
+// honored when the server is configured to sit behind a trusted proxy.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitIP wraps a handler with a per-IP token bucket.
+func (cfg *apiConfig) rateLimitIP(scope string, rule ratelimit.Rule, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.allowRequest(w, r, ipKey(scope, cfg.clientIP(r)), rule) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowRequest takes a token from key's bucket, writing a 429 and returning
+// false if none is left. If the limiter's store fails the request is let
+// through, so an outage of the store doesn't lock everyone out.
+func (cfg *apiConfig) allowRequest(w http.ResponseWriter, r *http.Request, key string, rule ratelimit.Rule) bool {
+	result, err := cfg.limiter.Allow(r.Context(), key, rule)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rate limiter unavailable", "error", err)
+		return true
+	}
+	if !result.Allowed {
+		respondTooManyRequests(w, result.RetryAfter, "Too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+// checkLockout writes a 429 and returns false if key is locked out.
+func (cfg *apiConfig) checkLockout(w http.ResponseWriter, r *http.Request, key string) bool {
+	result, err := cfg.limiter.Check(r.Context(), key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Rate limiter unavailable", "error", err)
+		return true
+	}
+	if !result.Allowed {
+		respondTooManyRequests(w, result.RetryAfter, "Too many failed attempts, account temporarily locked")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) recordFailure(ctx context.Context, key string, lockout ratelimit.Lockout) {
+	_, err := cfg.limiter.Fail(ctx, key, lockout)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rate limiter unavailable", "error", err)
+	}
+}
+
+func (cfg *apiConfig) resetLimit(ctx context.Context, key string) {
+	err := cfg.limiter.Reset(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rate limiter unavailable", "error", err)
+	}
+}
+
+func respondTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
+
+// newRateLimitStore configures where rate limit state lives: in memory, or
+// in a SQLite file, which by default is the main database.
+func newRateLimitStore(conf config.RateLimitConfig) (ratelimit.Store, error) {
+	if conf.Store == config.RateLimitSQLite {
+		return ratelimit.NewSQLiteStore(conf.DBPath)
+	}
+	return ratelimit.NewMemoryStore(), nil
+}
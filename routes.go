@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+)
+
+// routes registers every route the server serves. mockOIDC, if not nil, is
+// served under /mock-oidc in place of a real identity provider.
+func (cfg *apiConfig) routes(mockOIDC *oidc.MockProvider) *routeMux {
+	mux := newRouteMux()
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
+	mux.Handle("/app/", appHandler)
+
+	mux.Handle("/assets/", noCacheMiddleware(cfg.assetsHandler()))
+
+	// Each route declares who may call it. Authenticated routes run behind
+	// requireAuth, which puts the caller's principal in the request context,
+	// and requireScope, so tokens issued for narrower purposes are held to
+	// them. Admin routes also require a role, as requireAuth read it from the
+	// database.
+	authed := func(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+		return cfg.requireAuth(requireScope(scope, next))
+	}
+	admin := func(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+		return authed(auth.ScopeAdmin, requireRole(role, next))
+	}
+
+	mux.HandleFunc("POST /api/login", cfg.rateLimitIP("login", loginIPRule, cfg.handlerLogin))
+	mux.HandleFunc("POST /api/login/totp", cfg.rateLimitIP("login", loginIPRule, cfg.handlerLoginTOTP))
+	mux.HandleFunc("POST /api/refresh", cfg.rateLimitIP("refresh", refreshIPRule, cfg.handlerRefresh))
+	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/auth/oidc/login", cfg.rateLimitIP("login", loginIPRule, cfg.handlerOIDCLogin))
+	mux.HandleFunc("GET /api/auth/oidc/callback", cfg.rateLimitIP("login", loginIPRule, cfg.handlerOIDCCallback))
+	if mockOIDC != nil {
+		mux.Handle("/mock-oidc/", http.StripPrefix("/mock-oidc", mockOIDC.Handler()))
+	}
+
+	mux.HandleFunc("POST /api/users", cfg.rateLimitIP("signup", signupIPRule, cfg.handlerUsersCreate))
+	mux.HandleFunc("DELETE /api/users", authed(auth.ScopeAccount, cfg.handlerUserDelete))
+	mux.HandleFunc("PUT /api/users/email", authed(auth.ScopeAccount, cfg.handlerUserEmailUpdate))
+	mux.HandleFunc("POST /api/users/email/confirm", cfg.handlerUserEmailConfirm)
+	mux.HandleFunc("PUT /api/users/password", authed(auth.ScopeAccount, cfg.handlerUserPasswordUpdate))
+	mux.HandleFunc("GET /api/users/totp", authed(auth.ScopeAccount, cfg.handlerTOTPStatus))
+	mux.HandleFunc("POST /api/users/totp/enroll", authed(auth.ScopeAccount, cfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/users/totp/confirm", authed(auth.ScopeAccount, cfg.handlerTOTPConfirm))
+	mux.HandleFunc("POST /api/users/totp/recovery_codes", authed(auth.ScopeAccount, cfg.handlerTOTPRecoveryCodes))
+	mux.HandleFunc("POST /api/users/totp/disable", authed(auth.ScopeAccount, cfg.handlerTOTPDisable))
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", authed(auth.ScopeAccount, cfg.handlerResendVerification))
+	mux.HandleFunc("POST /api/tokens", authed(auth.ScopeAccount, cfg.handlerScopedTokenCreate))
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordResetConfirm)
+
+	mux.HandleFunc("POST /api/exports", authed(auth.ScopeAccount, cfg.handlerDataExportCreate))
+	mux.HandleFunc("GET /api/exports", authed(auth.ScopeAccount, cfg.handlerDataExportsList))
+	mux.HandleFunc("GET /api/exports/{exportID}", authed(auth.ScopeAccount, cfg.handlerDataExportGet))
+	// Authorized by the link's signature instead of a token.
+	mux.HandleFunc("GET /api/exports/{exportID}/download", cfg.handlerDataExportDownload)
+
+	mux.HandleFunc("POST /api/videos", authed(auth.ScopeVideosWrite, cfg.idempotent(cfg.handlerVideoMetaCreate)))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", authed(auth.ScopeVideosWrite, cfg.idempotent(cfg.handlerUploadThumbnail)))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", authed(auth.ScopeVideosWrite, cfg.idempotent(cfg.handlerUploadVideo)))
+	mux.HandleFunc("GET /api/videos", authed(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.optionalAuth(cfg.handlerVideoGet))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", authed(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", authed(auth.ScopeVideosWrite, cfg.handlerVideoMetaDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/events", authed(auth.ScopeVideosRead, cfg.handlerVideoEvents))
+	mux.HandleFunc("GET /api/videos/{videoID}/collaborators", authed(auth.ScopeVideosRead, cfg.handlerVideoCollaboratorsList))
+	mux.HandleFunc("POST /api/videos/{videoID}/collaborators", authed(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorsInvite))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/collaborators/{userID}", authed(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorsRemove))
+
+	mux.HandleFunc("POST /api/webhooks", authed(auth.ScopeWebhooks, cfg.handlerWebhooksCreate))
+	mux.HandleFunc("GET /api/webhooks", authed(auth.ScopeWebhooks, cfg.handlerWebhooksList))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}", authed(auth.ScopeWebhooks, cfg.handlerWebhookGet))
+	mux.HandleFunc("PATCH /api/webhooks/{webhookID}", authed(auth.ScopeWebhooks, cfg.handlerWebhookUpdate))
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", authed(auth.ScopeWebhooks, cfg.handlerWebhookDelete))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", authed(auth.ScopeWebhooks, cfg.handlerWebhookDeliveriesList))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries/{deliveryID}", authed(auth.ScopeWebhooks, cfg.handlerWebhookDeliveryGet))
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", authed(auth.ScopeWebhooks, cfg.handlerWebhookRedeliver))
+
+	mux.HandleFunc("POST /api/organizations", authed(auth.ScopeOrgs, cfg.handlerOrganizationsCreate))
+	mux.HandleFunc("GET /api/organizations", authed(auth.ScopeOrgs, cfg.handlerOrganizationsRetrieve))
+	mux.HandleFunc("GET /api/organizations/{orgID}", authed(auth.ScopeOrgs, cfg.handlerOrganizationGet))
+	mux.HandleFunc("GET /api/organizations/{orgID}/members", authed(auth.ScopeOrgs, cfg.handlerOrganizationMembersList))
+	mux.HandleFunc("POST /api/organizations/{orgID}/members", authed(auth.ScopeOrgs, cfg.handlerOrganizationMembersAdd))
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/members/{userID}", authed(auth.ScopeOrgs, cfg.handlerOrganizationMembersRemove))
+
+	mux.Handle("GET /metrics", cfg.metrics.Handler())
+	mux.HandleFunc("GET /api/openapi.json", cfg.handlerOpenAPI)
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+
+	// Dev only; the handler refuses on any other platform.
+	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/users", admin(auth.RoleAdmin, cfg.handlerAdminUsersList))
+	mux.HandleFunc("POST /admin/users/{userID}/disable", admin(auth.RoleAdmin, cfg.handlerAdminUserDisable))
+	mux.HandleFunc("POST /admin/users/{userID}/enable", admin(auth.RoleAdmin, cfg.handlerAdminUserEnable))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", admin(auth.RoleAdmin, cfg.handlerAdminUserRole))
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", admin(auth.RoleAdmin, cfg.handlerAdminUserUnlock))
+	mux.HandleFunc("GET /admin/videos", admin(auth.RoleModerator, cfg.handlerAdminVideosList))
+	mux.HandleFunc("PUT /admin/organizations/{orgID}/quota", admin(auth.RoleAdmin, cfg.handlerAdminOrganizationQuota))
+	mux.HandleFunc("POST /admin/imports", admin(auth.RoleAdmin, cfg.handlerAdminImportCreate))
+	mux.HandleFunc("GET /admin/imports", admin(auth.RoleAdmin, cfg.handlerAdminImportsList))
+	mux.HandleFunc("GET /admin/imports/{importID}", admin(auth.RoleAdmin, cfg.handlerAdminImportGet))
+	return mux
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long handlers still running at the shutdown deadline
+// get to clean up, such as removing temp files, once their requests are
+// cancelled.
+const shutdownGrace = 5 * time.Second
+
+// tempDirPrefix starts the name of each server process's temp directory.
+const tempDirPrefix = "tubely-"
+
+// inFlightRequests tracks requests being served, so shutdown can wait for
+// handlers that outlive srv.Shutdown.
+type inFlightRequests struct {
+	wg sync.WaitGroup
+}
+
+func (f *inFlightRequests) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.wg.Add(1)
+		defer f.wg.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// wait waits for every request to be served, or for ctx to be done.
+func (f *inFlightRequests) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the server and the job runner. New connections are refused
+// at once; in-flight requests and running jobs get until timeout to finish.
+// Requests still running then are cancelled, and jobs go back to the queue
+// to be picked up on the next start. Temp files are removed once nothing can
+// be using them. Progress event streams would never finish on their own, so
+// they're ended first.
+func (cfg *apiConfig) shutdown(srv *http.Server, requests *inFlightRequests, cancelRequests context.CancelFunc, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cfg.progress.Close()
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		err := srv.Shutdown(ctx)
+		if err == nil {
+			return
+		}
+		slog.Warn("Requests still running at shutdown deadline, cancelling them", "error", err)
+		cancelRequests()
+		srv.Close()
+		graceCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		err = requests.wait(graceCtx)
+		if err != nil {
+			slog.Error("Requests didn't finish after being cancelled", "error", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		err := cfg.jobs.Shutdown(ctx)
+		if err != nil {
+			slog.Warn("Jobs still running at shutdown deadline were returned to the queue", "error", err)
+		}
+	}()
+	wg.Wait()
+
+	err := cfg.removeTempDir()
+	if err != nil {
+		slog.Error("Couldn't remove temp files", "dir", cfg.tempDir, "error", err)
+	}
+}
+
+// newTempDir creates a directory for this process's temp files, first
+// removing any left behind by processes that were killed before they could
+// clean up. It returns the directory locked: each process holds an
+// exclusive flock on its directory until it exits, so a directory that can
+// be locked is no one's. Unlike a process ID, which is reused and is 1 for
+// every server in a container, the lock can't outlive its process.
+func newTempDir() (string, *os.File, error) {
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(os.TempDir(), entry.Name())
+		lock, err := lockDir(dir)
+		if err != nil {
+			// In use, or not ours to remove.
+			continue
+		}
+		slog.Info("Removing stale temp files", "dir", dir)
+		err = os.RemoveAll(dir)
+		if err != nil {
+			slog.Warn("Couldn't remove stale temp files", "dir", dir, "error", err)
+		}
+		lock.Close()
+	}
+
+	for {
+		dir, err := os.MkdirTemp("", tempDirPrefix+"*")
+		if err != nil {
+			return "", nil, err
+		}
+		lock, err := lockDir(dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		// Another process starting up may have found the directory before
+		// it was locked, and removed it as stale.
+		locked, err := lock.Stat()
+		if err != nil {
+			lock.Close()
+			return "", nil, err
+		}
+		current, err := os.Stat(dir)
+		if err == nil && os.SameFile(locked, current) {
+			return dir, lock, nil
+		}
+		lock.Close()
+	}
+}
+
+// lockDir takes an exclusive flock on a directory without waiting. The lock
+// is held until the returned file is closed or the process exits.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// removeTempDir removes this process's temp directory and releases its
+// lock.
+func (cfg *apiConfig) removeTempDir() error {
+	err := os.RemoveAll(cfg.tempDir)
+	if cfg.tempDirLock != nil {
+		cfg.tempDirLock.Close()
+	}
+	return err
+}
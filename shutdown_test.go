@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTempDirRemovesOnlyUnlockedDirs(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	// Left behind by a server that was killed, so nothing holds its lock.
+	stale := filepath.Join(os.TempDir(), tempDirPrefix+"stale")
+	err := os.MkdirAll(stale, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(stale, "upload-1.mp4"), []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(os.TempDir(), "other-app")
+	err = os.MkdirAll(unrelated, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running, runningLock, err := newTempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale temp directory wasn't removed: %v", err)
+	}
+
+	// A second server leaves the running one's directory alone, even
+	// though, as in a container, it may have the same process ID.
+	second, secondLock, err := newTempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondLock.Close()
+	if second == running {
+		t.Fatal("two servers got the same temp directory")
+	}
+	for _, dir := range []string{running, unrelated} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("%s was removed: %v", dir, err)
+		}
+	}
+
+	// Once the first server is gone, its directory is stale.
+	runningLock.Close()
+	_, thirdLock, err := newTempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thirdLock.Close()
+	if _, err := os.Stat(running); !os.IsNotExist(err) {
+		t.Fatalf("temp directory of an exited server wasn't removed: %v", err)
+	}
+	if _, err := os.Stat(second); err != nil {
+		t.Fatalf("running server's temp directory was removed: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/google/uuid"
+)
+
+// uploadProgressInterval is the least time between upload progress events,
+// so a fast upload doesn't publish one per read.
+const uploadProgressInterval = 250 * time.Millisecond
+
+// publishFailure tells a video's subscribers its upload or processing
+// failed. The error itself is logged by whoever handles it; subscribers get
+// a message that's safe to show anyone who can view the video.
+func (cfg *apiConfig) publishFailure(videoID uuid.UUID, err error) {
+	msg := "Couldn't process video"
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		msg = "Organization storage quota exceeded"
+	case errors.Is(err, errUploadFailed):
+		msg = "Upload failed"
+	}
+	cfg.progress.Publish(progress.Event{VideoID: videoID, Stage: progress.StageFailed, Error: msg})
+}
+
+// errUploadFailed is passed to publishFailure when the upload itself, not
+// its processing, went wrong.
+var errUploadFailed = errors.New("upload failed")
+
+// uploadProgressReader counts the bytes read through it and publishes them
+// as upload progress for a video. total is zero if it isn't known.
+type uploadProgressReader struct {
+	r         io.Reader
+	broker    *progress.Broker
+	videoID   uuid.UUID
+	total     int64
+	read      int64
+	published time.Time
+}
+
+func (u *uploadProgressReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.read += int64(n)
+	if err == io.EOF || time.Since(u.published) >= uploadProgressInterval {
+		u.publish()
+	}
+	return n, err
+}
+
+func (u *uploadProgressReader) publish() {
+	u.published = time.Now()
+	event := progress.Event{
+		VideoID:    u.videoID,
+		Stage:      progress.StageUploading,
+		Bytes:      u.read,
+		TotalBytes: u.total,
+	}
+	if u.total > 0 {
+		percent := min(100, 100*float64(u.read)/float64(u.total))
+		event.Percent = &percent
+	}
+	u.broker.Publish(event)
+}
+
+// ffmpegProgressWriter parses what ffmpeg writes with -progress: blocks of
+// key=value lines, each ending with progress=continue or progress=end. It
+// calls onOutTime with how far into the input ffmpeg has got, once per
+// block.
+type ffmpegProgressWriter struct {
+	onOutTime func(outTime time.Duration)
+
+	mu      sync.Mutex
+	partial []byte
+	outTime time.Duration
+}
+
+// newFFmpegProgressWriter returns a writer for ffmpeg's -progress output
+// that calls onProgress with the percentage of an input lasting duration
+// that ffmpeg has got through. It never calls it if duration isn't known.
+func newFFmpegProgressWriter(duration time.Duration, onProgress func(percent float64)) *ffmpegProgressWriter {
+	return &ffmpegProgressWriter{onOutTime: func(outTime time.Duration) {
+		if duration <= 0 {
+			return
+		}
+		onProgress(min(100, 100*float64(outTime)/float64(duration)))
+	}}
+}
+
+func (f *ffmpegProgressWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.partial = append(f.partial, p...)
+	lastNewline := bytes.LastIndexByte(f.partial, '\n')
+	if lastNewline < 0 {
+		return len(p), nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(f.partial[:lastNewline+1]))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		// Despite its name, out_time_ms is in microseconds too; older
+		// ffmpeg versions only write that one.
+		case "out_time_us", "out_time_ms":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && us >= 0 {
+				f.outTime = time.Duration(us) * time.Microsecond
+			}
+		case "progress":
+			f.onOutTime(f.outTime)
+		}
+	}
+	f.partial = append(f.partial[:0], f.partial[lastNewline+1:]...)
+	return len(p), nil
+}
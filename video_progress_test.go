@@ -0,0 +1,99 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFFmpegProgressWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		// chunks are written one at a time, as ffmpeg's pipe delivers them.
+		chunks []string
+		want   []float64
+	}{
+		{
+			name:     "one block per write",
+			duration: 4 * time.Second,
+			chunks: []string{
+				"frame=24\nout_time_us=1000000\nout_time=00:00:01.000000\nprogress=continue\n",
+				"frame=48\nout_time_us=2000000\nprogress=continue\n",
+				"out_time_us=4000000\nprogress=end\n",
+			},
+			want: []float64{25, 50, 100},
+		},
+		{
+			name:     "lines split across writes",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_u", "s=3000000\nprog", "ress=contin", "ue\n"},
+			want:     []float64{75},
+		},
+		{
+			name:     "several blocks in one write",
+			duration: 10 * time.Second,
+			chunks:   []string{"out_time_us=1000000\nprogress=continue\nout_time_us=2000000\nprogress=continue\n"},
+			want:     []float64{10, 20},
+		},
+		{
+			name:     "unfinished line isn't parsed yet",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_us=1000000\nprogress=cont"},
+			want:     nil,
+		},
+		{
+			name:     "end before any output",
+			duration: 4 * time.Second,
+			chunks:   []string{"progress=end\n"},
+			want:     []float64{0},
+		},
+		{
+			name:     "older ffmpeg's out_time_ms is microseconds too",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_ms=2000000\nprogress=end\n"},
+			want:     []float64{50},
+		},
+		{
+			name:     "unknown out time keeps the last",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_us=1000000\nprogress=continue\nout_time_us=N/A\nprogress=continue\n"},
+			want:     []float64{25, 25},
+		},
+		{
+			name:     "CRLF line endings",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_us=2000000\r\nprogress=end\r\n"},
+			want:     []float64{50},
+		},
+		{
+			name:     "capped at 100",
+			duration: 4 * time.Second,
+			chunks:   []string{"out_time_us=4100000\nprogress=end\n"},
+			want:     []float64{100},
+		},
+		{
+			name:     "unknown duration",
+			duration: 0,
+			chunks:   []string{"out_time_us=1000000\nprogress=end\n"},
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			w := newFFmpegProgressWriter(tt.duration, func(percent float64) {
+				got = append(got, percent)
+			})
+			for _, chunk := range tt.chunks {
+				n, err := w.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got percents %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/google/uuid"
+)
+
+// Video lifecycle events webhooks can subscribe to.
+const (
+	webhookEventVideoCreated     = "video.created"
+	webhookEventVideoUploaded    = "video.uploaded"
+	webhookEventVideoReady       = "video.ready"
+	webhookEventVideoFailed      = "video.failed"
+	webhookEventVideoDeleted     = "video.deleted"
+	webhookEventThumbnailUpdated = "thumbnail.updated"
+)
+
+var webhookEvents = []string{
+	webhookEventVideoCreated,
+	webhookEventVideoUploaded,
+	webhookEventVideoReady,
+	webhookEventVideoFailed,
+	webhookEventVideoDeleted,
+	webhookEventThumbnailUpdated,
+}
+
+const (
+	jobKindWebhookDelivery = "webhook_delivery"
+	// webhookDeliveryAttempts spaces retries over about an hour with the
+	// job runner's backoff.
+	webhookDeliveryAttempts = 8
+	webhookTimeout          = 10 * time.Second
+)
+
+// Headers sent with each delivery. The signature lets receivers check a
+// delivery came from us and wasn't replayed long after it was sent; see
+// signWebhookPayload.
+const (
+	webhookEventHeader     = "Tubely-Event"
+	webhookDeliveryHeader  = "Tubely-Delivery"
+	webhookSignatureHeader = "Tubely-Signature"
+)
+
+type webhookDeliveryJob struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// webhookPayload is the body of a delivery. ID identifies the event, so
+// it's the same across redeliveries and receivers can use it to ignore
+// repeats.
+type webhookPayload struct {
+	ID        uuid.UUID          `json:"id"`
+	Type      string             `json:"type"`
+	CreatedAt time.Time          `json:"created_at"`
+	Data      webhookPayloadData `json:"data"`
+}
+
+type webhookPayloadData struct {
+	Video database.Video `json:"video"`
+}
+
+// errWebhookAddressBlocked is why a delivery to an address webhooks can't be
+// sent to fails.
+var errWebhookAddressBlocked = errors.New("endpoint isn't on a public address")
+
+// webhookBlockedPrefixes are special-purpose ranges that IsGlobalUnicast
+// lets through but that aren't on the public internet, or, like NAT64 and
+// 6to4, reach IPv4 addresses that may not be.
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+}
+
+// webhookAddressAllowed reports whether deliveries can be sent to addr:
+// only global unicast addresses that aren't private or special-purpose.
+// Anyone can register a webhook, so endpoints on the server's own network,
+// or the cloud metadata service, would let them probe it.
+func webhookAddressAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookDialControl refuses connections to addresses deliveries can't be
+// sent to. It runs after the host is resolved, so a host that resolved to
+// a public address when the webhook was registered can't be pointed at an
+// internal one later.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAddressAllowed(addrPort.Addr()) {
+		return errWebhookAddressBlocked
+	}
+	return nil
+}
+
+// newWebhookClient makes the client deliveries are sent with. Redirects
+// aren't followed: the endpoint should be registered at its real URL.
+// Deliveries connect to the endpoint directly, never through a proxy, so
+// webhookDialControl sees the endpoint's address.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// generateWebhookSecret makes the key a webhook's deliveries are signed
+// with.
+func generateWebhookSecret() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// signWebhookPayload signs a delivery body as sent at the given time. The
+// signature is "t=<unix seconds>,v1=<hex HMAC-SHA256>", where the HMAC is
+// of "<unix seconds>.<body>" keyed with the webhook's secret.
+func signWebhookPayload(secret string, sentAt time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhookEvent queues a delivery of an event about a video to each
+// webhook subscribed to it. It's called once whatever the event describes
+// has happened, so failing to queue deliveries is logged rather than
+// failing the caller.
+func (cfg *apiConfig) emitWebhookEvent(ctx context.Context, event string, video database.Video) {
+	// Deliveries are queued even if the request that caused the event has
+	// gone away.
+	ctx = context.WithoutCancel(ctx)
+	err := cfg.queueWebhookDeliveries(ctx, event, video)
+	if err != nil {
+		slog.ErrorContext(ctx, "Couldn't queue webhook deliveries", "event", event, "video_id", video.ID, "error", err)
+	}
+}
+
+func (cfg *apiConfig) queueWebhookDeliveries(ctx context.Context, event string, video database.Video) error {
+	webhooks, err := cfg.db.WithContext(ctx).GetVideoWebhooks(video)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	eventID := uuid.New()
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		if payload == nil {
+			signedVideo, err := cfg.dbVideoToSignedVideo(ctx, video)
+			if err != nil {
+				return err
+			}
+			payload, err = json.Marshal(webhookPayload{
+				ID:        eventID,
+				Type:      event,
+				CreatedAt: time.Now().UTC(),
+				Data:      webhookPayloadData{Video: signedVideo},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		_, err = cfg.queueWebhookDelivery(ctx, database.CreateWebhookDeliveryParams{
+			WebhookID: webhook.ID,
+			EventID:   eventID,
+			EventType: event,
+			Payload:   payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueWebhookDelivery records a delivery in the log and queues the job
+// that sends it.
+func (cfg *apiConfig) queueWebhookDelivery(ctx context.Context, params database.CreateWebhookDeliveryParams) (database.WebhookDelivery, error) {
+	delivery, err := cfg.db.WithContext(ctx).CreateWebhookDelivery(params)
+	if err != nil {
+		return database.WebhookDelivery{}, err
+	}
+	err = cfg.enqueueJob(ctx, jobKindWebhookDelivery, webhookDeliveryJob{DeliveryID: delivery.ID}, time.Now(), webhookDeliveryAttempts)
+	if err != nil {
+		return database.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// runWebhookDelivery sends a delivery and logs the outcome. Anything but a
+// 2xx response is retried, with the job runner's backoff, until the job
+// runs out of attempts and the delivery is marked failed.
+func (cfg *apiConfig) runWebhookDelivery(ctx context.Context, job database.Job) error {
+	params := webhookDeliveryJob{}
+	err := json.Unmarshal(job.Payload, &params)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	db := cfg.db.WithContext(ctx)
+	delivery, err := db.GetWebhookDelivery(params.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery == nil || delivery.Status != database.WebhookDeliveryStatusPending {
+		// The webhook was deleted, or an earlier attempt got through.
+		return nil
+	}
+	webhook, err := db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+	if webhook == nil || !webhook.Active {
+		msg := "webhook is disabled"
+		delivery.Status = database.WebhookDeliveryStatusFailed
+		delivery.Error = &msg
+		return db.UpdateWebhookDelivery(delivery)
+	}
+
+	sendErr := cfg.sendWebhookDelivery(ctx, *webhook, delivery)
+	if sendErr != nil && ctx.Err() != nil {
+		// Shutting down; the job goes back on the queue and this attempt
+		// doesn't count.
+		return sendErr
+	}
+	switch {
+	case sendErr == nil:
+		delivery.Status = database.WebhookDeliveryStatusSucceeded
+		delivery.DeliveredAt = delivery.LastAttemptAt
+		delivery.Error = nil
+	case job.Attempts >= job.MaxAttempts:
+		delivery.Status = database.WebhookDeliveryStatusFailed
+	}
+	err = db.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		return errors.Join(sendErr, err)
+	}
+	return sendErr
+}
+
+// sendWebhookDelivery makes one attempt at a delivery, recording what
+// happened on it.
+func (cfg *apiConfig) sendWebhookDelivery(ctx context.Context, webhook database.Webhook, delivery *database.WebhookDelivery) (err error) {
+	start := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &start
+	delivery.ResponseStatus = nil
+	defer func() {
+		duration := time.Since(start).Milliseconds()
+		delivery.DurationMS = &duration
+		if err != nil {
+			msg := err.Error()
+			delivery.Error = &msg
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1.0")
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(webhook.Secret, start, delivery.Payload))
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	// Only the status is kept: the body is whatever the endpoint chose to
+	// send, and the delivery log shouldn't echo it back.
+	resp.Body.Close()
+	delivery.ResponseStatus = &resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}